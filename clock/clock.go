@@ -0,0 +1,25 @@
+// Package clock abstracts the time source, so time dependent code can be tested without waiting.
+package clock
+
+import "time"
+
+// Clock - source of the current time and timers
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After waits for the duration to elapse and then sends the current time on the returned channel
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real - the clock backed by the time package
+type Real struct{}
+
+// Now returns the current local time
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// After is the same as time.After
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,87 @@
+// Package clocktest provides the manual clock for tests.
+package clocktest
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake - the clock which time moves only when it's told to.
+// It's safe for concurrent use.
+type Fake struct {
+	mux     sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFake returns the clock set to the time
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mux)
+	return f
+}
+
+// Now returns the clock's current time
+func (f *Fake) Now() time.Time {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.now
+}
+
+// After returns the channel which receives the clock's time once it's advanced by the duration
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, waiter{until: f.now.Add(d), ch: ch})
+	f.cond.Broadcast()
+	return ch
+}
+
+// Advance moves the clock forward by the duration and fires all timers which are due
+func (f *Fake) Advance(d time.Duration) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.until.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = pending
+}
+
+// Set moves the clock to the time, it must not be earlier than the clock's current time
+func (f *Fake) Set(t time.Time) {
+	f.Advance(t.Sub(f.Now()))
+}
+
+// BlockUntil waits until there are at least n goroutines waiting for the clock's timers.
+// It's used to make sure that the code under test is sleeping before the clock is advanced.
+func (f *Fake) BlockUntil(n int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// Waiters returns the number of timers which are not fired yet
+func (f *Fake) Waiters() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return len(f.waiters)
+}
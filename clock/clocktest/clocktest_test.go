@@ -0,0 +1,42 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := NewFake(start)
+
+	if got := clk.Now(); !got.Equal(start) {
+		t.Errorf("Fake.Now() = %v, want %v", got, start)
+	}
+	if got := <-clk.After(0); !got.Equal(start) {
+		t.Errorf("Fake.After(0) = %v, want %v", got, start)
+	}
+
+	fired := make(chan time.Time)
+	go func() {
+		fired <- <-clk.After(time.Minute)
+	}()
+	clk.BlockUntil(1)
+
+	clk.Advance(30 * time.Second)
+	select {
+	case <-fired:
+		t.Fatalf("Fake.After() fired too early")
+	default:
+	}
+	if clk.Waiters() != 1 {
+		t.Errorf("Fake.Waiters() = %v, want 1", clk.Waiters())
+	}
+
+	clk.Set(start.Add(time.Minute))
+	if got := <-fired; !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("Fake.After() = %v, want %v", got, start.Add(time.Minute))
+	}
+	if clk.Waiters() != 0 {
+		t.Errorf("Fake.Waiters() = %v, want 0", clk.Waiters())
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusError - kvserver responded with the HTTP code other than 200
+type statusError struct {
+	code int
+	msg  string // response's body as returned by the server
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// client - thin wrapper around kvserver's HTTP API
+type client struct {
+	server string // base URL, i.e. http://<host>:<port>
+	http   *http.Client
+}
+
+func newClient(server string, timeout time.Duration) (*client, error) {
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("bad server address '%v'", server)
+	}
+	return &client{
+		server: strings.TrimRight(u.String(), "/"),
+		http:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// get returns the value for the key and the time when the element expires.
+// Expiration time is zero if server didn't provide it.
+func (c *client) get(key string) (string, time.Time, error) {
+	resp, err := c.http.Get(c.keyURL(key))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	var expires time.Time
+	if header := resp.Header.Get("Expires"); header != "" {
+		expires, _ = http.ParseTime(header)
+	}
+	return body, expires, nil
+}
+
+// set stores the value by its key
+func (c *client) set(key, value string) error {
+	return c.post(key, url.Values{"value": {value}})
+}
+
+// del removes the element by its key
+func (c *client) del(key string) error {
+	// POST request without the form deletes the element
+	return c.post(key, nil)
+}
+
+// touch resets element's lifetime without changing its value
+func (c *client) touch(key string) error {
+	return c.post(key, url.Values{"touch": {""}})
+}
+
+// expireAt sets absolute expiration time for the element
+func (c *client) expireAt(key string, expires time.Time) error {
+	return c.post(key, url.Values{"expireat": {strconv.FormatInt(expires.Unix(), 10)}})
+}
+
+// persist makes the element never expire
+func (c *client) persist(key string) error {
+	return c.post(key, url.Values{"persist": {""}})
+}
+
+func (c *client) post(key string, form url.Values) error {
+	resp, err := c.http.PostForm(c.keyURL(key), form)
+	if err != nil {
+		return err
+	}
+	_, err = readBody(resp)
+	return err
+}
+
+// keys returns all keys stored on the server, the oldest element goes first.
+// The keys are listed as JSON, so they may contain any bytes, line breaks included.
+func (c *client) keys() ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.server+"/keys/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	if err := json.Unmarshal([]byte(body), &keys); err != nil {
+		return nil, fmt.Errorf("bad list of the keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (c *client) keyURL(key string) string {
+	return c.server + "/key/" + url.PathEscape(key)
+}
+
+// readBody reads and closes response's body, any HTTP code other than 200 is reported as *statusError
+func readBody(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return "", &statusError{code: resp.StatusCode, msg: msg}
+	}
+	return string(body), nil
+}
+
+// isNotFound reports whether the server has no record for the key
+func isNotFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusNotFound
+}
+
+// isWrongType reports whether the key holds other kind of value than string, e.g. hash
+func isWrongType(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.code == http.StatusConflict
+}
@@ -0,0 +1,372 @@
+// kvctl is the command-line client for kvserver.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// exit codes, these reflect HTTP codes returned by kvserver
+const (
+	exitOK          = 0
+	exitFailure     = 1 // server is not reachable or local I/O error
+	exitUsage       = 2 // wrong command line arguments
+	exitBadRequest  = 3 // 400
+	exitNotFound    = 4 // 404
+	exitServerError = 5 // 500
+)
+
+const usage = `Usage: kvctl [flags] <command> [args]
+
+Commands:
+  get <key>          print the value for the key
+  set <key> [value]  store the value, it's read from -f file or stdin if omitted
+  del <key>          delete the key
+  touch <key>        reset lifetime of the key without changing its value
+  expireat <key> <time>
+                     expire the key at the time, Unix time, secs or RFC 3339
+  persist <key>      make the key never expire
+  ttl <key>          print remaining lifetime of the key, secs., -1 if it never expires
+  keys               print all keys, the oldest goes first
+  dump               print all key-value pairs, string values only
+  load [file]        store key-value pairs produced by dump, from file or stdin
+
+Exit codes:
+  0 success, 1 failure, 2 usage error,
+  3 malformed request (400), 4 key not found (404), 5 storage error (500)
+
+Flags:
+`
+
+// cli - single run of the command line client
+type cli struct {
+	client *client
+	json   bool // output format is JSON, plain text otherwise
+	file   string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("kvctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	server := flags.String("server", "127.0.0.1:8080", "kvserver address, host:port or URL")
+	output := flags.String("o", "text", "output format, text or json")
+	file := flags.String("f", "", "file to read the value (set) or key-value pairs (load) from")
+	timeout := flags.Duration("timeout", 10*time.Second, "HTTP request timeout")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "kvctl: unknown output format '%v'\n", *output)
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	c, err := newClient(*server, *timeout)
+	if err != nil {
+		fmt.Fprintf(stderr, "kvctl: %v\n", err)
+		return exitUsage
+	}
+	cmd := &cli{
+		client: c,
+		json:   *output == "json",
+		file:   *file,
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+
+	name, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	command, nargs, ok := commandFactory(name)
+	if !ok || len(cmdArgs) < nargs[0] || len(cmdArgs) > nargs[1] {
+		fmt.Fprintf(stderr, "kvctl: wrong command '%v'\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		return exitUsage
+	}
+	if err := command(cmd, cmdArgs); err != nil {
+		return cmd.fail(err)
+	}
+	return exitOK
+}
+
+// commandFactory returns the command by its name and the min/max number of its arguments
+func commandFactory(name string) (func(*cli, []string) error, [2]int, bool) {
+	switch name {
+	case "get":
+		return (*cli).get, [2]int{1, 1}, true
+	case "set":
+		return (*cli).set, [2]int{1, 2}, true
+	case "del":
+		return (*cli).del, [2]int{1, 1}, true
+	case "touch":
+		return (*cli).touch, [2]int{1, 1}, true
+	case "expireat":
+		return (*cli).expireAt, [2]int{2, 2}, true
+	case "persist":
+		return (*cli).persist, [2]int{1, 1}, true
+	case "ttl":
+		return (*cli).ttl, [2]int{1, 1}, true
+	case "keys":
+		return (*cli).keys, [2]int{0, 0}, true
+	case "dump":
+		return (*cli).dump, [2]int{0, 0}, true
+	case "load":
+		return (*cli).load, [2]int{0, 1}, true
+	}
+	return nil, [2]int{}, false
+}
+
+func (c *cli) get(args []string) error {
+	value, _, err := c.client.get(args[0])
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]string{"key": args[0], "value": value})
+	}
+	fmt.Fprint(c.stdout, value)
+	return nil
+}
+
+func (c *cli) set(args []string) error {
+	var value string
+	if len(args) == 2 {
+		value = args[1]
+	} else {
+		data, err := c.readInput(c.file)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	return c.client.set(args[0], value)
+}
+
+func (c *cli) del(args []string) error {
+	return c.client.del(args[0])
+}
+
+func (c *cli) touch(args []string) error {
+	return c.client.touch(args[0])
+}
+
+func (c *cli) expireAt(args []string) error {
+	expires, err := parseTime(args[1])
+	if err != nil {
+		return fmt.Errorf("bad time '%v', Unix time or RFC 3339 is expected", args[1])
+	}
+	return c.client.expireAt(args[0], expires)
+}
+
+func (c *cli) persist(args []string) error {
+	return c.client.persist(args[0])
+}
+
+func (c *cli) ttl(args []string) error {
+	_, expires, err := c.client.get(args[0])
+	if err != nil {
+		return err
+	}
+	// the key without Expires header never expires
+	secs := int64(-1)
+	if !expires.IsZero() {
+		// Expires header has one second precision, so the remaining time is rounded up
+		secs = int64((time.Until(expires) + time.Second - 1) / time.Second)
+		if secs < 0 {
+			secs = 0
+		}
+	}
+	if c.json {
+		return c.printJSON(map[string]interface{}{"key": args[0], "ttl": secs})
+	}
+	fmt.Fprintln(c.stdout, secs)
+	return nil
+}
+
+func (c *cli) keys(args []string) error {
+	keys, err := c.client.keys()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(keys)
+	}
+	for _, key := range keys {
+		fmt.Fprintln(c.stdout, textKey(key))
+	}
+	return nil
+}
+
+// textKey returns the key for the text output, the same as the server lists it: the key which would
+// break the line or the pair, or looks quoted is quoted as Go string, the others are as they are
+func textKey(key string) string {
+	if strings.HasPrefix(key, `"`) || strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		return strconv.Quote(key)
+	}
+	return key
+}
+
+// dump prints all key-value pairs with string values, JSON object or one pair per line with the value quoted,
+// the key is quoted if it has to be, see textKey
+func (c *cli) dump(args []string) error {
+	keys, err := c.client.keys()
+	if err != nil {
+		return err
+	}
+	pairs := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, _, err := c.client.get(key)
+		if isNotFound(err) || isWrongType(err) {
+			// the element has expired or been deleted since the keys were listed,
+			// or it's not a string, only strings are dumped
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		pairs[key] = value
+	}
+	if c.json {
+		return c.printJSON(pairs)
+	}
+	for _, key := range keys {
+		if value, ok := pairs[key]; ok {
+			fmt.Fprintf(c.stdout, "%v\t%v\n", textKey(key), strconv.Quote(value))
+		}
+	}
+	return nil
+}
+
+// load stores key-value pairs in either of the formats produced by dump
+func (c *cli) load(args []string) error {
+	file := c.file
+	if len(args) == 1 {
+		file = args[0]
+	}
+	data, err := c.readInput(file)
+	if err != nil {
+		return err
+	}
+	pairs, err := parseDump(data)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.client.set(key, pairs[key]); err != nil {
+			return fmt.Errorf("key '%v': %w", key, err)
+		}
+	}
+	return nil
+}
+
+func parseDump(data []byte) (map[string]string, error) {
+	pairs := make(map[string]string)
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		if err := json.Unmarshal(data, &pairs); err != nil {
+			return nil, fmt.Errorf("bad JSON dump: %w", err)
+		}
+		return pairs, nil
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad dump, line %v: no value for the key", i+1)
+		}
+		key := parts[0]
+		if strings.HasPrefix(key, `"`) {
+			// the key which can't be written as it is, see textKey
+			unquoted, err := strconv.Unquote(key)
+			if err != nil {
+				return nil, fmt.Errorf("bad dump, line %v: key is not quoted properly", i+1)
+			}
+			key = unquoted
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad dump, line %v: value is not quoted", i+1)
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+// parseTime parses either Unix time, secs or RFC 3339 time
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// readInput reads the whole file, stdin is used when the file is either empty or "-"
+func (c *cli) readInput(file string) ([]byte, error) {
+	if file == "" || file == "-" {
+		return io.ReadAll(c.stdin)
+	}
+	return os.ReadFile(file)
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// fail reports the error and returns the exit code for it
+func (c *cli) fail(err error) int {
+	code := exitFailure
+	status := 0
+	var se *statusError
+	if errors.As(err, &se) {
+		status = se.code
+		switch se.code {
+		case http.StatusBadRequest:
+			code = exitBadRequest
+		case http.StatusNotFound:
+			code = exitNotFound
+		case http.StatusInternalServerError:
+			code = exitServerError
+		}
+	}
+	if c.json {
+		out := map[string]interface{}{"error": err.Error()}
+		if status != 0 {
+			out["status"] = status
+		}
+		_ = json.NewEncoder(c.stderr).Encode(out)
+	} else {
+		fmt.Fprintf(c.stderr, "kvctl: %v\n", err)
+	}
+	return code
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/router"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	storage := kvstorage.NewStorage()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key/", router.GetURLrouter(storage, 60))
+	mux.HandleFunc("/keys/", router.GetKeysRouter(storage))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_run(t *testing.T) {
+	server := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantCode   int
+		wantStdout string
+	}{
+		{
+			name:     "No command",
+			args:     []string{},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "Unknown command",
+			args:     []string{"put", "key1"},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "Wrong number of arguments",
+			args:     []string{"get"},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "Unknown output format",
+			args:     []string{"-o", "xml", "keys"},
+			wantCode: exitUsage,
+		},
+		{
+			name:     "Getting value from the empty storage",
+			args:     []string{"get", "key1"},
+			wantCode: exitNotFound,
+		},
+		{
+			name:     "Setting value from the argument",
+			args:     []string{"set", "key1", "value 1"},
+			wantCode: exitOK,
+		},
+		{
+			name:     "Setting value from stdin",
+			args:     []string{"set", "key2"},
+			stdin:    "value\n2",
+			wantCode: exitOK,
+		},
+		{
+			name:     "Setting empty value",
+			args:     []string{"set", "key3", ""},
+			wantCode: exitBadRequest,
+		},
+		{
+			name:     "Empty value is not set",
+			args:     []string{"get", "key3"},
+			wantCode: exitNotFound,
+		},
+		{
+			name:     "Setting value after empty one",
+			args:     []string{"set", "key3", "3"},
+			wantCode: exitOK,
+		},
+		{
+			name:       "Getting value",
+			args:       []string{"get", "key2"},
+			wantCode:   exitOK,
+			wantStdout: "value\n2",
+		},
+		{
+			name:       "Getting value as JSON",
+			args:       []string{"-o", "json", "get", "key1"},
+			wantCode:   exitOK,
+			wantStdout: "{\n  \"key\": \"key1\",\n  \"value\": \"value 1\"\n}\n",
+		},
+		{
+			name:       "Remaining lifetime",
+			args:       []string{"ttl", "key1"},
+			wantCode:   exitOK,
+			wantStdout: "60\n",
+		},
+		{
+			name:       "Listing the keys as JSON",
+			args:       []string{"-o", "json", "keys"},
+			wantCode:   exitOK,
+			wantStdout: "[\n  \"key1\",\n  \"key2\",\n  \"key3\"\n]\n",
+		},
+		{
+			name:       "Dump",
+			args:       []string{"dump"},
+			wantCode:   exitOK,
+			wantStdout: "key1\t\"value 1\"\nkey2\t\"value\\n2\"\nkey3\t\"3\"\n",
+		},
+		{
+			name:     "Touching the key",
+			args:     []string{"touch", "key1"},
+			wantCode: exitOK,
+		},
+		{
+			name:     "Touching missing key",
+			args:     []string{"touch", "key9"},
+			wantCode: exitNotFound,
+		},
+		{
+			name:     "Expiration time in the past",
+			args:     []string{"expireat", "key3", "1"},
+			wantCode: exitOK,
+		},
+		{
+			name:     "Expired key is removed",
+			args:     []string{"get", "key3"},
+			wantCode: exitNotFound,
+		},
+		{
+			name:     "Bad expiration time",
+			args:     []string{"expireat", "key2", "tomorrow"},
+			wantCode: exitFailure,
+		},
+		{
+			name:     "Making the key persistent",
+			args:     []string{"persist", "key2"},
+			wantCode: exitOK,
+		},
+		{
+			name:       "Persistent key never expires",
+			args:       []string{"ttl", "key2"},
+			wantCode:   exitOK,
+			wantStdout: "-1\n",
+		},
+		{
+			name:     "Deleting the key",
+			args:     []string{"del", "key1"},
+			wantCode: exitOK,
+		},
+		{
+			name:     "Deleting the key again",
+			args:     []string{"del", "key1"},
+			wantCode: exitNotFound,
+		},
+		{
+			name:     "Loading the dump",
+			args:     []string{"load"},
+			stdin:    "key4\t\"value\\t4\"\nkey5\t\"value 5\"\n",
+			wantCode: exitOK,
+		},
+		{
+			name:     "Loading the broken dump",
+			args:     []string{"load"},
+			stdin:    "key6 value 6\n",
+			wantCode: exitFailure,
+		},
+		{
+			name:       "Loaded value",
+			args:       []string{"get", "key4"},
+			wantCode:   exitOK,
+			wantStdout: "value\t4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"-server", server.URL}, tt.args...)
+			got := run(args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if got != tt.wantCode {
+				t.Errorf("run() = %v, want %v, stderr: %v", got, tt.wantCode, stderr.String())
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("run() stdout = %q, want %q", stdout.String(), tt.wantStdout)
+			}
+		})
+	}
+}
+
+func Test_parseDump(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "JSON dump",
+			data: `{"a": "1", "b": ""}`,
+			want: map[string]string{"a": "1", "b": ""},
+		},
+		{
+			name: "Text dump",
+			data: "a\t\"1\"\n\nb\t\"\"\n",
+			want: map[string]string{"a": "1", "b": ""},
+		},
+		{
+			name:    "Value is not quoted",
+			data:    "a\t1\n",
+			wantErr: true,
+		},
+		{
+			name:    "Broken JSON",
+			data:    `{"a": 1}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDump([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseDump() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && len(got) != len(tt.want) {
+				t.Errorf("parseDump() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("parseDump() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_dump_skipsHashes(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("string", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.HSet("hash", "field", "value"); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key/", router.GetURLrouter(storage, 60))
+	mux.HandleFunc("/keys/", router.GetKeysRouter(storage))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	if got := run([]string{"-server", server.URL, "dump"}, nil, &stdout, &stderr); got != exitOK {
+		t.Errorf("run() = %v, want %v, stderr: %v", got, exitOK, stderr.String())
+	}
+	if want := "string\t\"value\"\n"; stdout.String() != want {
+		t.Errorf("run() stdout = %q, want %q", stdout.String(), want)
+	}
+}
+
+func Test_dump_load(t *testing.T) {
+	from, to := newTestServer(t), newTestServer(t)
+	pairs := map[string]string{
+		"plain":       "value",
+		"line\nbreak": "1",
+		"tab\tkey":    "2",
+		`"quoted"`:    "3",
+	}
+	for key, value := range pairs {
+		if got := run([]string{"-server", from.URL, "set", key, value}, nil, io.Discard, io.Discard); got != exitOK {
+			t.Fatalf("run(set %q) = %v", key, got)
+		}
+	}
+	var dump, stderr bytes.Buffer
+	if got := run([]string{"-server", from.URL, "dump"}, nil, &dump, &stderr); got != exitOK {
+		t.Fatalf("run(dump) = %v, stderr: %v", got, stderr.String())
+	}
+	if got := run([]string{"-server", to.URL, "load"}, strings.NewReader(dump.String()), io.Discard, &stderr); got != exitOK {
+		t.Fatalf("run(load) = %v, stderr: %v, dump: %q", got, stderr.String(), dump.String())
+	}
+	for key, value := range pairs {
+		var stdout bytes.Buffer
+		if got := run([]string{"-server", to.URL, "get", key}, nil, &stdout, io.Discard); got != exitOK || stdout.String() != value {
+			t.Errorf("run(get %q) = %v, %q, want %q", key, got, stdout.String(), value)
+		}
+	}
+}
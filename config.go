@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/proway2/kvserver/logger"
+)
+
+const (
+	// environment variables of the settings are named like KVSERVER_MAX_KEY_LENGTH for -max-key-length
+	envPrefix       = "KVSERVER_"
+	configFlag      = "config"
+	printConfigFlag = "print-config"
+)
+
+// loadOptions returns the settings from the command line, the environment and the config file.
+// The command line overrides the environment, the environment overrides the config file,
+// the config file overrides the defaults. The config file has the same settings as the flags,
+// e.g. max-key-length: 256. All problems with the settings are reported at once.
+func loadOptions(fs *flag.FlagSet, args, environ []string) (options, error) {
+	var opts options
+	defineFlags(fs, &opts)
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	env := make(map[string]interface{})
+	for _, kv := range environ {
+		if name, value, ok := cutEnv(kv); ok {
+			env[name] = value
+		}
+	}
+	if config, ok := env[configFlag]; ok && !explicit[configFlag] {
+		opts.config = config.(string)
+	}
+	delete(env, configFlag)
+
+	var problems []string
+	// apply sets the settings unless they are given on the command line
+	apply := func(source string, settings map[string]interface{}) {
+		for _, name := range sortedNames(settings) {
+			value, ok := settingValue(settings[name])
+			f := fs.Lookup(name)
+			switch {
+			case f == nil || name == configFlag || name == printConfigFlag:
+				problems = append(problems, fmt.Sprintf("%v: unknown setting '%v'", source, name))
+			case !ok:
+				problems = append(problems, fmt.Sprintf("%v: %v must be a single value", source, name))
+			case isStringFlag(f) && !isString(settings[name]):
+				// e.g. TOML's 0o660 would be turned into 432
+				problems = append(problems, fmt.Sprintf("%v: %v must be a string, quote it", source, name))
+			case explicit[name]:
+			default:
+				if err := fs.Set(name, value); err != nil {
+					problems = append(problems, fmt.Sprintf("%v: invalid value '%v' of %v: %v", source, value, name, err))
+				}
+			}
+		}
+	}
+	if opts.config != "" {
+		settings, err := readConfig(opts.config)
+		if err != nil {
+			return opts, fmt.Errorf("cannot read config file: %w", err)
+		}
+		apply("config file", settings)
+	}
+	apply("environment", env)
+	problems = append(problems, opts.validate()...)
+	if len(problems) != 0 {
+		return opts, errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return opts, nil
+}
+
+// validate returns the problems with the settings, one per setting
+func (o *options) validate() []string {
+	var problems []string
+	if _, ok := expirationModes[o.expiration]; !ok {
+		problems = append(problems, fmt.Sprintf("expiration: unknown mode '%v', it must be absolute or sliding", o.expiration))
+	}
+	if o.purgeBatch < 1 {
+		problems = append(problems, "purge-batch: must be positive")
+	}
+	if o.purgeBudget < 0 {
+		problems = append(problems, "purge-budget: must not be negative")
+	}
+	if o.maxKeyLength < 1 {
+		problems = append(problems, "max-key-length: must be positive")
+	}
+	if o.pubsubBuffer < 1 {
+		problems = append(problems, "pubsub-buffer: must be positive")
+	}
+	if o.port < 0 || o.port > 65535 {
+		problems = append(problems, "port: must be from 0 to 65535")
+	}
+	if o.port == 0 && o.unixSocket == "" {
+		problems = append(problems, "port: either TCP port or Unix socket must be set")
+	}
+	if o.grpcPort < 0 || o.grpcPort > 65535 {
+		problems = append(problems, "grpc-port: must be from 0 to 65535")
+	}
+	if _, err := logger.ParseLevel(o.logLevel); err != nil {
+		problems = append(problems, "log-level: "+err.Error())
+	}
+	if _, err := logger.ParseFormat(o.logFormat); err != nil {
+		problems = append(problems, "log-format: "+err.Error())
+	}
+	if o.traceEndpoint != "" {
+		if u, err := url.Parse(o.traceEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("trace-endpoint: '%v' is not valid HTTP URL", o.traceEndpoint))
+		}
+		if o.traceFile != "" {
+			problems = append(problems, "trace-file: either trace endpoint or trace file may be set")
+		}
+	}
+	if mode, err := strconv.ParseUint(o.socketMode, 8, 32); err != nil || mode > 0777 {
+		problems = append(problems, fmt.Sprintf("unix-socket-mode: '%v' is not valid octal permissions", o.socketMode))
+	}
+	return problems
+}
+
+// readConfig returns the settings from the config file, the format is told by the file's extension
+func readConfig(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		// numbers are kept as they are written, e.g. big quotas are not turned into floats
+		dec.UseNumber()
+		err = dec.Decode(&settings)
+	case ".yaml", ".yml":
+		settings, err = readYAML(data)
+	case ".toml":
+		err = toml.Unmarshal(data, &settings)
+	default:
+		return nil, fmt.Errorf("unknown format of %v, it must be .yaml, .yml, .toml or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return settings, nil
+}
+
+// readYAML returns the settings from YAML, the scalars are kept as they are written,
+// e.g. unquoted 0660 is not turned into 432. The lists, the tables and the nulls are kept as nodes.
+func readYAML(data []byte) (map[string]interface{}, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	settings := make(map[string]interface{})
+	if len(doc.Content) == 0 {
+		// the file is empty
+		return settings, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("settings must be a mapping like ttl: 300")
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name, value := root.Content[i], root.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		if value.Kind == yaml.ScalarNode && value.Tag != "!!null" {
+			settings[name.Value] = value.Value
+			continue
+		}
+		settings[name.Value] = value
+	}
+	return settings, nil
+}
+
+// isStringFlag reports whether the flag's value is a string, e.g. -unix-socket-mode
+func isStringFlag(f *flag.Flag) bool {
+	_, ok := f.Value.(flag.Getter).Get().(string)
+	return ok
+}
+
+func isString(v interface{}) bool {
+	_, ok := v.(string)
+	return ok
+}
+
+// settingValue returns the value of the setting from the config file as the flag's value,
+// false if it's a list, a table or a null
+func settingValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool, int, int64, uint64, float64, json.Number:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// cutEnv returns the setting's name of the environment variable like KVSERVER_MAX_KEY_LENGTH=256,
+// false if the variable is not the setting
+func cutEnv(kv string) (string, string, bool) {
+	if !strings.HasPrefix(kv, envPrefix) {
+		return "", "", false
+	}
+	i := strings.IndexByte(kv, '=')
+	if i < 0 {
+		return "", "", false
+	}
+	name := strings.ToLower(strings.ReplaceAll(kv[len(envPrefix):i], "_", "-"))
+	return name, kv[i+1:], true
+}
+
+// printConfig writes the effective settings as JSON, it's fine as the config file
+func printConfig(w io.Writer, fs *flag.FlagSet) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(effectiveSettings(fs))
+}
+
+// effectiveSettings returns the values of the settings by their names, durations are strings like 1ms
+func effectiveSettings(fs *flag.FlagSet) map[string]interface{} {
+	settings := make(map[string]interface{})
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == configFlag || f.Name == printConfigFlag {
+			return
+		}
+		value := f.Value.(flag.Getter).Get()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		settings[f.Name] = value
+	})
+	return settings
+}
+
+// sortedNames returns the names of the settings in order, so the problems are always reported the same way
+func sortedNames(settings map[string]interface{}) []string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFlagSet returns the flag set which doesn't print anything or exit
+func newFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("kvserver", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// writeConfig writes the config file with the content and returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_loadOptions(t *testing.T) {
+	yamlConfig := writeConfig(t, "kvserver.yaml", "ttl: 30\nport: 9000\npurge-budget: 5ms\nmax-memory: 1048576\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		environ []string
+		want    func(o options) bool
+	}{
+		{
+			name: "Defaults",
+			want: func(o options) bool { return o.ttl == 60 && o.port == 8080 && o.purgeBudget == time.Millisecond },
+		},
+		{
+			name: "Config file",
+			args: []string{"-config", yamlConfig},
+			want: func(o options) bool {
+				return o.ttl == 30 && o.port == 9000 && o.purgeBudget == 5*time.Millisecond && o.maxMemory == 1<<20
+			},
+		},
+		{
+			name:    "Config file from the environment",
+			environ: []string{"KVSERVER_CONFIG=" + yamlConfig},
+			want:    func(o options) bool { return o.ttl == 30 },
+		},
+		{
+			name:    "Environment overrides config file",
+			args:    []string{"-config", yamlConfig},
+			environ: []string{"KVSERVER_TTL=20", "KVSERVER_DELETE_ON_READ=true", "HOME=/root"},
+			want:    func(o options) bool { return o.ttl == 20 && o.port == 9000 && o.deleteOnRead },
+		},
+		{
+			name:    "Command line overrides environment",
+			args:    []string{"-config", yamlConfig, "-ttl", "10"},
+			environ: []string{"KVSERVER_TTL=20"},
+			want:    func(o options) bool { return o.ttl == 10 && o.port == 9000 },
+		},
+		{
+			name: "JSON config file",
+			args: []string{"-config", writeConfig(t, "kvserver.json", `{"max-key-length": 256, "expiration": "sliding"}`)},
+			want: func(o options) bool { return o.maxKeyLength == 256 && o.expiration == "sliding" },
+		},
+		{
+			name: "Unquoted octal in YAML config file",
+			args: []string{"-config", writeConfig(t, "kvserver.yaml", "unix-socket-mode: 0660\naddr: 0.0.0.0\n")},
+			want: func(o options) bool { return o.socketMode == "0660" && o.addr == "0.0.0.0" },
+		},
+		{
+			name: "TOML config file",
+			args: []string{"-config", writeConfig(t, "kvserver.toml", "addr = \"0.0.0.0\"\npubsub-buffer = 10\n")},
+			want: func(o options) bool { return o.addr == "0.0.0.0" && o.pubsubBuffer == 10 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadOptions(newFlagSet(), tt.args, tt.environ)
+			if err != nil {
+				t.Fatalf("loadOptions() error = %v", err)
+			}
+			if !tt.want(got) {
+				t.Errorf("loadOptions() = %+v", got)
+			}
+		})
+	}
+}
+
+func Test_loadOptions_problems(t *testing.T) {
+	config := writeConfig(t, "kvserver.yaml", "ttl: -1\nports: 80\nallowed-origins: [a, b]\nexpiration: fixed\n")
+	_, err := loadOptions(newFlagSet(),
+		[]string{"-config", config, "-purge-batch", "0"},
+		[]string{
+			"KVSERVER_MAX_KEY_LENGTH=0", "KVSERVER_UNIX_SOCKET_MODE=999",
+			"KVSERVER_TRACE_ENDPOINT=localhost:4318", "KVSERVER_TRACE_FILE=spans.json",
+		},
+	)
+	want := []string{
+		"invalid configuration:",
+		"config file: allowed-origins must be a single value",
+		"config file: unknown setting 'ports'",
+		`config file: invalid value '-1' of ttl: parse error`,
+		"expiration: unknown mode 'fixed', it must be absolute or sliding",
+		"purge-batch: must be positive",
+		"max-key-length: must be positive",
+		"trace-endpoint: 'localhost:4318' is not valid HTTP URL",
+		"trace-file: either trace endpoint or trace file may be set",
+		"unix-socket-mode: '999' is not valid octal permissions",
+	}
+	if err == nil {
+		t.Fatal("loadOptions() error = nil")
+	}
+	got := strings.Split(err.Error(), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("loadOptions() error = %v, want %v problems", err, len(want)-1)
+	}
+	for i := range want {
+		if !strings.HasPrefix(strings.TrimSpace(got[i]), want[i]) {
+			t.Errorf("loadOptions() problem %v = %q, want %q", i, strings.TrimSpace(got[i]), want[i])
+		}
+	}
+
+	_, err = loadOptions(newFlagSet(), []string{"-config", writeConfig(t, "kvserver.toml", "unix-socket-mode = 0o660\n")}, nil)
+	if want := "config file: unix-socket-mode must be a string, quote it"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("loadOptions() with TOML number for the string error = %v, want %v", err, want)
+	}
+
+	if _, err := loadOptions(newFlagSet(), []string{"-config", writeConfig(t, "kvserver.ini", "ttl=1")}, nil); err == nil {
+		t.Error("loadOptions() with unknown format of config file error = nil")
+	}
+}
+
+func Test_printConfig(t *testing.T) {
+	fs := newFlagSet()
+	if _, err := loadOptions(fs, []string{"-ttl", "30", "-print-config"}, []string{"KVSERVER_ADDR=0.0.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := printConfig(&out, fs); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["ttl"] != 30.0 || got["addr"] != "0.0.0.0" || got["purge-budget"] != "1ms" || got["delete-on-read"] != false {
+		t.Errorf("printConfig() = %s", out.String())
+	}
+	if _, ok := got[printConfigFlag]; ok {
+		t.Errorf("printConfig() has %v", printConfigFlag)
+	}
+
+	// the printed configuration is fine as the config file
+	config := writeConfig(t, "kvserver.json", out.String())
+	if opts, err := loadOptions(newFlagSet(), []string{"-config", config}, nil); err != nil || opts.ttl != 30 {
+		t.Errorf("loadOptions() of printed configuration = %+v, %v", opts, err)
+	}
+}
@@ -3,11 +3,59 @@ package element
 import (
 	"container/list"
 	"time"
+
+	"github.com/proway2/kvserver/skiplist"
+)
+
+// Kind - the kind of the element's value
+type Kind int
+
+const (
+	// String - the value is a string, it's in Val (default)
+	String Kind = iota
+	// Hash - the value is a field to value map, it's in Hash
+	Hash
+	// List - the value is a list of strings, it's in List
+	List
+	// Set - the value is a set of unique strings, it's in Members
+	Set
+	// SortedSet - the value is a set of unique strings ordered by their scores, it's in Scores and Ranking
+	SortedSet
+	// Lock - the value is the owner's token of the lock in Val, the lock expires when its lease is over
+	Lock
 )
 
 // Element - структура описывающая один элемент хранилища
 type Element struct {
-	Val          string        // the actual value of the element
-	Timestamp    time.Time     // time when element is created or updated
-	QueueElement *list.Element // pointer to the position in the queue (LIFO stack)
+	Kind         Kind                // the kind of the value
+	Val          string              // the actual value of the element
+	Hash         map[string]string   // fields of the hash
+	List         *list.List          // values of the list, strings
+	Members      map[string]struct{} // members of the set
+	Scores       map[string]float64  // members of the sorted set and their scores
+	Ranking      *skiplist.List      // members of the sorted set ordered by their scores
+	Fence        uint64              // fencing token of the lock, it grows with every acquisition
+	Size         uint64              // memory taken by the key and the value, bytes
+	Timestamp    time.Time           // time when element is created, updated or touched, lifetime is counted from it
+	Created      time.Time           // time when element is created, it's kept on updates
+	Version      uint64              // storage's revision at the moment of the last update
+	Expires      time.Time           // absolute expiration time, zero if the element expires by the storage's TTL
+	Persistent   bool                // the element never expires
+	QueueElement *list.Element       // pointer to the position in the queue (LIFO stack), nil if the element is not in the queue
+	WheelSlot    *list.List          // slot of the timing wheel the element is in, nil if Expires is zero
+	WheelElement *list.Element       // pointer to the position in the wheel's slot
+}
+
+// ExpiresAt returns the time when the element expires, false if it never expires.
+// ttl is the storage's element lifetime, it counts unless the element has its own expiration time.
+func (e Element) ExpiresAt(ttl time.Duration) (time.Time, bool) {
+	switch {
+	case e.Persistent:
+		return time.Time{}, false
+	case !e.Expires.IsZero():
+		return e.Expires, true
+	case ttl > 0:
+		return e.Timestamp.Add(ttl), true
+	}
+	return time.Time{}, false
 }
@@ -1,3 +1,19 @@
 module github.com/proway2/kvserver
 
-go 1.14
+go 1.17
+
+require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/gorilla/websocket v1.5.0
+	google.golang.org/grpc v1.54.0
+	google.golang.org/protobuf v1.28.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/golang/protobuf v1.5.2 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/sys v0.6.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
+	google.golang.org/genproto v0.0.0-20230110181048-76db0878b65f // indirect
+)
@@ -0,0 +1,283 @@
+// Package grpcserver - gRPC service of kvserver backed by the same storage as the HTTP API
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/proway2/kvserver/element"
+	"github.com/proway2/kvserver/kvpb"
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/pubsub"
+)
+
+type storage interface {
+	Lookup(key string) (element.Element, bool, error)
+	Set(key, value string, mode ...kvstorage.SetMode) error
+	Delete(key string) (bool, error)
+	Exec(watched map[string]uint64, ops []kvstorage.TxOp) ([]kvstorage.TxResult, error)
+}
+
+// ttler - the storage which tells element's lifetime, it may be changed at runtime
+type ttler interface {
+	TTL() time.Duration
+}
+
+type subscriber interface {
+	Subscribe(channels, patterns []string) (*pubsub.Subscription, error)
+}
+
+const (
+	// default maximum length of the key, bytes
+	defaultMaxKeyLength = 1024
+	// maxBatch - the batch holds the storage's lock while it's applied, so it's bounded, the same as /txn
+	maxBatch = 1000
+)
+
+var conditions = map[kvpb.Condition]kvstorage.SetMode{
+	kvpb.Condition_UPSERT:    kvstorage.Upsert,
+	kvpb.Condition_IF_ABSENT: kvstorage.IfAbsent,
+	kvpb.Condition_IF_EXISTS: kvstorage.IfExists,
+}
+
+var opKinds = map[kvpb.Operation_Kind]kvstorage.TxOpKind{
+	kvpb.Operation_GET:    kvstorage.TxGet,
+	kvpb.Operation_SET:    kvstorage.TxSet,
+	kvpb.Operation_DELETE: kvstorage.TxDelete,
+}
+
+// Server - implementation of kvpb.KVServer
+type Server struct {
+	kvpb.UnimplementedKVServer
+	stor         storage
+	bus          subscriber
+	ttl          time.Duration
+	maxKeyLength int
+}
+
+// Option configures the server, see NewServer
+type Option func(*Server)
+
+// WithTTL sets element's lifetime in the storage, it's used to tell clients when the element expires
+// unless the storage tells its current TTL itself
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.ttl = ttl
+	}
+}
+
+// WithMaxKeyLength sets the maximum length of the key, bytes. Requests with longer keys get INVALID_ARGUMENT.
+func WithMaxKeyLength(length int) Option {
+	return func(s *Server) {
+		if length > 0 {
+			s.maxKeyLength = length
+		}
+	}
+}
+
+// NewServer returns the server of the storage. Changes of the watched keys come from the bus
+// as the messages published by pubsub.Bus.KeyspaceNotifier.
+func NewServer(stor storage, bus subscriber, opts ...Option) *Server {
+	s := &Server{stor: stor, bus: bus, maxKeyLength: defaultMaxKeyLength}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Get returns the string value of the key
+func (s *Server) Get(ctx context.Context, req *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	if err := s.validKey(req.GetKey()); err != nil {
+		return nil, err
+	}
+	elem, found, err := s.stor.Lookup(req.GetKey())
+	if err != nil {
+		return nil, storageStatus(err)
+	}
+	if !found {
+		return nil, notFound(req.GetKey())
+	}
+	if elem.Kind != element.String {
+		return nil, storageStatus(kvstorage.ErrWrongType)
+	}
+	return &kvpb.GetResponse{Value: s.value(req.GetKey(), elem)}, nil
+}
+
+// Set stores the string value of the key
+func (s *Server) Set(ctx context.Context, req *kvpb.SetRequest) (*kvpb.SetResponse, error) {
+	if err := s.validKey(req.GetKey()); err != nil {
+		return nil, err
+	}
+	mode, ok := conditions[req.GetCondition()]
+	if !ok || req.GetValue() == "" {
+		return nil, status.Error(codes.InvalidArgument, "value is missing or condition is unknown")
+	}
+	if err := s.stor.Set(req.GetKey(), req.GetValue(), mode); err != nil {
+		return nil, storageStatus(err)
+	}
+	return &kvpb.SetResponse{}, nil
+}
+
+// Delete removes the key
+func (s *Server) Delete(ctx context.Context, req *kvpb.DeleteRequest) (*kvpb.DeleteResponse, error) {
+	if err := s.validKey(req.GetKey()); err != nil {
+		return nil, err
+	}
+	found, err := s.stor.Delete(req.GetKey())
+	if err != nil {
+		return nil, storageStatus(err)
+	}
+	if !found {
+		return nil, notFound(req.GetKey())
+	}
+	return &kvpb.DeleteResponse{}, nil
+}
+
+// Watch streams the current versions of the keys and then their changes until the client cancels the call.
+// The client which doesn't read the changes in time gets RESOURCE_EXHAUSTED, it has to watch the keys again.
+func (s *Server) Watch(req *kvpb.WatchRequest, stream kvpb.KV_WatchServer) error {
+	keys := req.GetKeys()
+	if len(keys) == 0 || len(keys) > maxBatch {
+		return status.Errorf(codes.InvalidArgument, "1 to %v keys must be watched", maxBatch)
+	}
+	channels := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := s.validKey(key); err != nil {
+			return err
+		}
+		channels = append(channels, pubsub.KeyspacePrefix+key)
+	}
+	// the keys are subscribed to before their versions are read, so no change is missed
+	sub, err := s.bus.Subscribe(channels, nil)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer sub.Close()
+	for _, key := range keys {
+		elem, found, err := s.stor.Lookup(key)
+		if err != nil {
+			return storageStatus(err)
+		}
+		event := &kvpb.WatchEvent{Key: key}
+		if found {
+			event.Version = elem.Version
+		}
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "changes came faster than they were read")
+			}
+			version, err := strconv.ParseUint(msg.Payload, 10, 64)
+			if err != nil {
+				continue
+			}
+			key := msg.Channel[len(pubsub.KeyspacePrefix):]
+			if err := stream.Send(&kvpb.WatchEvent{Key: key, Version: version}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Batch applies the operations atomically, either all of them or none
+func (s *Server) Batch(ctx context.Context, req *kvpb.BatchRequest) (*kvpb.BatchResponse, error) {
+	if len(req.GetOps()) == 0 || len(req.GetOps()) > maxBatch || len(req.GetWatch()) > maxBatch {
+		return nil, status.Errorf(codes.InvalidArgument, "batch must have 1 to %v operations and watched keys", maxBatch)
+	}
+	ops := make([]kvstorage.TxOp, 0, len(req.GetOps()))
+	for i, o := range req.GetOps() {
+		kind, ok := opKinds[o.GetKind()]
+		mode, known := conditions[o.GetCondition()]
+		switch {
+		case !ok || !known:
+			return nil, status.Errorf(codes.InvalidArgument, "operation %v: kind or condition is unknown", i)
+		case kind == kvstorage.TxSet && o.GetValue() == "":
+			return nil, status.Errorf(codes.InvalidArgument, "operation %v: value is missing", i)
+		}
+		if err := s.validKey(o.GetKey()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "operation %v: %v", i, status.Convert(err).Message())
+		}
+		ops = append(ops, kvstorage.TxOp{
+			Kind: kind, Key: o.GetKey(), Value: o.GetValue(), Mode: mode, Version: o.GetVersion(),
+		})
+	}
+	results, err := s.stor.Exec(req.GetWatch(), ops)
+	if err != nil {
+		return nil, storageStatus(err)
+	}
+	resp := &kvpb.BatchResponse{Results: make([]*kvpb.Result, 0, len(results))}
+	for _, res := range results {
+		resp.Results = append(resp.Results, &kvpb.Result{
+			Key: res.Key, Value: res.Value, Found: res.Found, Version: res.Version,
+		})
+	}
+	return resp, nil
+}
+
+// validKey returns INVALID_ARGUMENT if the key is empty or too long
+func (s *Server) validKey(key string) error {
+	if len(key) == 0 || len(key) > s.maxKeyLength {
+		return status.Errorf(codes.InvalidArgument, "key is missing or longer than %v bytes", s.maxKeyLength)
+	}
+	return nil
+}
+
+// value returns the element's representation in the response, the same as JSON response of the HTTP API
+func (s *Server) value(key string, elem element.Element) *kvpb.Value {
+	val := &kvpb.Value{
+		Key:     key,
+		Value:   elem.Val,
+		Version: elem.Version,
+		Created: timestamppb.New(elem.Created),
+		Updated: timestamppb.New(elem.Timestamp),
+	}
+	ttl := s.ttl
+	if t, ok := s.stor.(ttler); ok && t.TTL() > 0 {
+		ttl = t.TTL()
+	}
+	if expires, ok := elem.ExpiresAt(ttl); ok {
+		val.Expires = timestamppb.New(expires)
+	}
+	return val
+}
+
+// notFound returns NOT_FOUND for the key, the same message as the one of the HTTP API
+func notFound(key string) error {
+	return status.Errorf(codes.NotFound, "there is no record in the storage for key '%v'", key)
+}
+
+// storageStatus returns gRPC status for the storage's error, the codes mirror the ones of the HTTP API
+func storageStatus(err error) error {
+	var watchErr *kvstorage.WatchError
+	if errors.As(err, &watchErr) {
+		return status.Errorf(codes.Aborted, "watched key '%v' has changed", watchErr.Key)
+	}
+	// the error of the batch tells which operation has failed
+	msg := err.Error()
+	switch {
+	case errors.Is(err, kvstorage.ErrKeyNotFound):
+		return status.Error(codes.NotFound, msg)
+	case errors.Is(err, kvstorage.ErrKeyExists):
+		return status.Error(codes.AlreadyExists, msg)
+	case errors.Is(err, kvstorage.ErrWrongType), errors.Is(err, kvstorage.ErrVersionMismatch),
+		errors.Is(err, kvstorage.ErrLocked), errors.Is(err, kvstorage.ErrNotOwner):
+		return status.Error(codes.FailedPrecondition, msg)
+	case errors.Is(err, kvstorage.ErrNoMemory):
+		return status.Error(codes.ResourceExhausted, msg)
+	}
+	// something went wrong with the storage
+	return status.Error(codes.Internal, msg)
+}
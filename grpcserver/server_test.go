@@ -0,0 +1,267 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/proway2/kvserver/kvpb"
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/pubsub"
+)
+
+// newClient starts the server of the storage and returns its client
+func newClient(t *testing.T, stor *kvstorage.KVStorage, bus *pubsub.Bus) kvpb.KVClient {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	kvpb.RegisterKVServer(server, NewServer(stor, bus, WithTTL(time.Minute), WithMaxKeyLength(16)))
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return kvpb.NewKVClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if _, err := storage.HSet("hash", "field", "value"); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(t, storage, pubsub.NewBus())
+	ctx := context.Background()
+	get := func() error {
+		_, err := client.Get(ctx, &kvpb.GetRequest{Key: "key"})
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode codes.Code
+	}{
+		{
+			name:     "Getting missing key",
+			call:     get,
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "Setting the key",
+			call: func() error {
+				_, err := client.Set(ctx, &kvpb.SetRequest{Key: "key", Value: "value"})
+				return err
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "Getting the key",
+			call:     get,
+			wantCode: codes.OK,
+		},
+		{
+			name: "Creating existing key",
+			call: func() error {
+				_, err := client.Set(ctx, &kvpb.SetRequest{Key: "key", Value: "new", Condition: kvpb.Condition_IF_ABSENT})
+				return err
+			},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name: "Setting empty value",
+			call: func() error {
+				_, err := client.Set(ctx, &kvpb.SetRequest{Key: "key"})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "Key is too long",
+			call: func() error {
+				_, err := client.Get(ctx, &kvpb.GetRequest{Key: "12345678901234567"})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "Getting the hash",
+			call: func() error {
+				_, err := client.Get(ctx, &kvpb.GetRequest{Key: "hash"})
+				return err
+			},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "Deleting the key",
+			call: func() error {
+				_, err := client.Delete(ctx, &kvpb.DeleteRequest{Key: "key"})
+				return err
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "Deleting missing key",
+			call: func() error {
+				_, err := client.Delete(ctx, &kvpb.DeleteRequest{Key: "key"})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(tt.call()); got != tt.wantCode {
+				t.Errorf("code = %v, want %v", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestServer_Get(t *testing.T) {
+	storage := kvstorage.NewStorage(kvstorage.WithTTL(time.Minute))
+	if err := storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(t, storage, pubsub.NewBus())
+	resp, err := client.Get(context.Background(), &kvpb.GetRequest{Key: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := resp.GetValue()
+	if val.GetKey() != "key" || val.GetValue() != "value" || val.GetVersion() != 1 {
+		t.Errorf("Get() = %v, want key, value and version 1", val)
+	}
+	if got, want := val.GetExpires().AsTime(), val.GetUpdated().AsTime().Add(time.Minute); !got.Equal(want) {
+		t.Errorf("Get() expires = %v, want %v", got, want)
+	}
+}
+
+func TestServer_Batch(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("from", "100"); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(t, storage, pubsub.NewBus())
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		req         *kvpb.BatchRequest
+		wantCode    codes.Code
+		wantResults []*kvpb.Result
+	}{
+		{
+			name: "Version doesn't match",
+			req: &kvpb.BatchRequest{Ops: []*kvpb.Operation{
+				{Kind: kvpb.Operation_DELETE, Key: "from", Version: 7},
+			}},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "Watched key has changed",
+			req: &kvpb.BatchRequest{
+				Watch: map[string]uint64{"from": 7},
+				Ops:   []*kvpb.Operation{{Kind: kvpb.Operation_GET, Key: "from"}},
+			},
+			wantCode: codes.Aborted,
+		},
+		{
+			name:     "No operations",
+			req:      &kvpb.BatchRequest{},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "Moving the value",
+			req: &kvpb.BatchRequest{
+				Watch: map[string]uint64{"from": 1, "to": 0},
+				Ops: []*kvpb.Operation{
+					{Kind: kvpb.Operation_GET, Key: "from", Version: 1},
+					{Kind: kvpb.Operation_SET, Key: "to", Value: "100", Condition: kvpb.Condition_IF_ABSENT},
+					{Kind: kvpb.Operation_DELETE, Key: "from"},
+				},
+			},
+			wantCode: codes.OK,
+			wantResults: []*kvpb.Result{
+				{Key: "from", Value: "100", Found: true, Version: 1},
+				{Key: "to", Found: false, Version: 2},
+				{Key: "from", Found: true, Version: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Batch(ctx, tt.req)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("Batch() code = %v, want %v", got, tt.wantCode)
+			}
+			if len(resp.GetResults()) != len(tt.wantResults) {
+				t.Fatalf("Batch() = %v, want %v", resp.GetResults(), tt.wantResults)
+			}
+			for i, got := range resp.GetResults() {
+				want := tt.wantResults[i]
+				if got.GetKey() != want.GetKey() || got.GetValue() != want.GetValue() ||
+					got.GetFound() != want.GetFound() || got.GetVersion() != want.GetVersion() {
+					t.Errorf("Batch() result %v = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Watch(t *testing.T) {
+	bus := pubsub.NewBus()
+	storage := kvstorage.NewStorage(kvstorage.WithNotifier(bus.KeyspaceNotifier()))
+	if err := storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(t, storage, bus)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Watch(ctx, &kvpb.WatchRequest{Keys: []string{"key", "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the current versions go first, the changes are made once the client has got them
+	want := []*kvpb.WatchEvent{{Key: "key", Version: 1}, {Key: "missing", Version: 0}}
+	changes := []func(){
+		func() { _ = storage.Set("key", "new") },
+		func() { _, _ = storage.Delete("key") },
+		func() { _ = storage.Set("other", "value") },
+		func() { _ = storage.Set("missing", "value") },
+	}
+	want = append(want, &kvpb.WatchEvent{Key: "key", Version: 2}, &kvpb.WatchEvent{Key: "key", Version: 0},
+		&kvpb.WatchEvent{Key: "missing", Version: 4})
+	for i, w := range want {
+		got, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.GetKey() != w.GetKey() || got.GetVersion() != w.GetVersion() {
+			t.Errorf("Watch() event %v = %v, want %v", i, got, w)
+		}
+		if i == 1 {
+			for _, change := range changes {
+				change()
+			}
+		}
+	}
+
+	noKeys, err := client.Watch(ctx, &kvpb.WatchRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noKeys.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Watch() without keys error = %v, want %v", err, codes.InvalidArgument)
+	}
+}
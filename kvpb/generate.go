@@ -0,0 +1,4 @@
+// Package kvpb - protobuf messages and gRPC service of kvserver, see kv.proto
+package kvpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative kv.proto
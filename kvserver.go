@@ -1,61 +1,421 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"google.golang.org/grpc"
+
+	"github.com/proway2/kvserver/clock"
+	"github.com/proway2/kvserver/grpcserver"
+	"github.com/proway2/kvserver/kvpb"
 	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/logger"
+	"github.com/proway2/kvserver/namespace"
+	"github.com/proway2/kvserver/pubsub"
 	"github.com/proway2/kvserver/router"
+	"github.com/proway2/kvserver/tracing"
 	"github.com/proway2/kvserver/vacuum"
 )
 
-func getCLIargs() (string, int, uint64) {
-	ttlP := flag.Uint64(
+// serviceName - the name of the server in the exported spans
+const serviceName = "kvserver"
+
+// expiration modes available from the command line
+var expirationModes = map[string]kvstorage.ExpirationMode{
+	"absolute": kvstorage.ExpireAfterWrite,
+	"sliding":  kvstorage.ExpireAfterAccess,
+}
+
+// options - server's settings, see loadOptions
+type options struct {
+	addr          string
+	port          int
+	ttl           uint64
+	expiration    string
+	purgeBatch    int
+	purgeBudget   time.Duration
+	deleteOnRead  bool
+	maxMemory     uint64
+	maxKeyLength  int
+	pubsubBuffer  int
+	origins       string
+	grpcPort      int
+	unixSocket    string
+	socketMode    string
+	logLevel      string
+	logFormat     string
+	accessLog     bool
+	traceEndpoint string
+	traceFile     string
+	// these are given on the command line only, KVSERVER_CONFIG is fine as well
+	config      string
+	printConfig bool
+}
+
+// defineFlags defines the command line flags of the settings, their values go to opts
+func defineFlags(fs *flag.FlagSet, opts *options) {
+	fs.Uint64Var(&opts.ttl,
 		"ttl",
 		60,
 		"element's (key-value) lifetime in the storage, secs.",
 	)
-	addr := flag.String(
+	fs.StringVar(&opts.addr,
 		"addr",
 		"127.0.0.1",
 		"IP address to bind to",
 	)
-	port := flag.Int(
+	fs.IntVar(&opts.port,
 		"port",
 		8080,
 		"port to listen to",
 	)
-	flag.Parse()
-	return *addr, *port, *ttlP
+	fs.StringVar(&opts.expiration,
+		"expiration",
+		"absolute",
+		"element's lifetime is counted either from the last update (absolute) or the last access (sliding)",
+	)
+	fs.IntVar(&opts.purgeBatch,
+		"purge-batch",
+		1024,
+		"maximum number of expired elements purged while the storage is locked",
+	)
+	fs.DurationVar(&opts.purgeBudget,
+		"purge-budget",
+		time.Millisecond,
+		"maximum time the storage is locked for purging expired elements, 0 - no limit",
+	)
+	fs.BoolVar(&opts.deleteOnRead,
+		"delete-on-read",
+		false,
+		"remove expired elements when they are accessed instead of waiting for the cleaner",
+	)
+	fs.Uint64Var(&opts.maxMemory,
+		"max-memory",
+		0,
+		"memory quota for keys and values of the default namespace, bytes, 0 - no quota",
+	)
+	fs.IntVar(&opts.maxKeyLength,
+		"max-key-length",
+		1024,
+		"maximum length of the key, bytes",
+	)
+	fs.IntVar(&opts.pubsubBuffer,
+		"pubsub-buffer",
+		pubsub.DefaultBufferSize,
+		"messages a subscriber may fall behind by before it's disconnected",
+	)
+	fs.StringVar(&opts.origins,
+		"allowed-origins",
+		"",
+		"comma-separated origins of the pages allowed to open WebSocket connections besides the server's own one, * - any",
+	)
+	fs.IntVar(&opts.grpcPort,
+		"grpc-port",
+		0,
+		"port to serve gRPC API at, 0 - gRPC API is off",
+	)
+	fs.StringVar(&opts.unixSocket,
+		"unix-socket",
+		"",
+		"path of Unix domain socket to serve HTTP API at besides TCP port, with -port 0 Unix socket only",
+	)
+	fs.StringVar(&opts.socketMode,
+		"unix-socket-mode",
+		"0660",
+		"file permissions of Unix domain socket, octal",
+	)
+	fs.StringVar(&opts.logLevel,
+		"log-level",
+		"info",
+		"lowest level of the records logged: debug, info, warn or error",
+	)
+	fs.StringVar(&opts.logFormat,
+		"log-format",
+		"logfmt",
+		"format of the log: logfmt or json",
+	)
+	fs.BoolVar(&opts.accessLog,
+		"access-log",
+		true,
+		"log every request to the keys at info level",
+	)
+	fs.StringVar(&opts.traceEndpoint,
+		"trace-endpoint",
+		"",
+		"URL of OTLP/HTTP collector the spans of the requests are sent to, e.g. http://localhost:4318/v1/traces",
+	)
+	fs.StringVar(&opts.traceFile,
+		"trace-file",
+		"",
+		"path of the file the spans of the requests are appended to as OTLP/JSON instead of the collector",
+	)
+	fs.StringVar(&opts.config,
+		configFlag,
+		"",
+		"path of the config file, either YAML, TOML or JSON, the format is told by the file's extension",
+	)
+	fs.BoolVar(&opts.printConfig,
+		printConfigFlag,
+		false,
+		"print the effective configuration as JSON and exit",
+	)
+}
+
+func getCLIargs() options {
+	opts, err := loadOptions(flag.CommandLine, os.Args[1:], os.Environ())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.printConfig {
+		if err := printConfig(os.Stdout, flag.CommandLine); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	return opts
 }
 
 func main() {
 	// для дальнейшей работы надо или получить аргументы
 	// из командной строки или установить значения по умолчанию
-	addr, port, ttl := getCLIargs()
+	opts := getCLIargs()
+	// the settings are valid, see loadOptions
+	mode := expirationModes[opts.expiration]
+	socketMode, _ := strconv.ParseUint(opts.socketMode, 8, 32)
+	logLevel, _ := logger.ParseLevel(opts.logLevel)
+	logFormat, _ := logger.ParseFormat(opts.logFormat)
+	lg := logger.NewLogger(os.Stderr, logger.WithLevel(logLevel), logger.WithFormat(logFormat))
+	cleanerLog := lg.With("component", "cleaner")
+	tracer, err := newTracer(opts, lg)
+	if err != nil {
+		lg.Fatal("Cannot initialize tracer!", "err", err)
+	}
 
+	// the storages, the cleaners and the handlers tell the time by the same clock
+	clk := clock.Real{}
 	// инициализация хранилища
-	storage := kvstorage.NewStorage()
+	// these are shared by the default storage and the namespaces
+	storageOpts := []kvstorage.Option{
+		kvstorage.WithClock(clk),
+		kvstorage.WithExpirationMode(mode),
+		kvstorage.WithPurgeNotifier(func(key string) { cleanerLog.Debug("key is purged", "key", key) }),
+	}
+	if opts.deleteOnRead {
+		storageOpts = append(storageOpts, kvstorage.WithDeleteOnRead())
+	}
+	if tracer != nil {
+		// the operations are the children of the requests' spans, the time waiting for the lock is told apart
+		storageOpts = append(storageOpts, kvstorage.WithTracer(
+			func(ctx context.Context, op string, start, locked, unlocked time.Time) {
+				tracer.Locked(ctx, "kvstorage."+op, start, locked, unlocked)
+			},
+		))
+	}
+	cleanerOpts := []vacuum.Option{
+		vacuum.WithClock(clk),
+		vacuum.WithBatch(opts.purgeBatch, opts.purgeBudget),
+		vacuum.WithLogger(cleanerLog),
+	}
+	// pub/sub channels are not stored, only the current subscribers get the messages
+	bus := pubsub.NewBus(pubsub.WithBufferSize(opts.pubsubBuffer))
+	storage := kvstorage.NewStorage(append(storageOpts,
+		// the storage must know the TTL to never return expired elements, even if the cleaner lags behind
+		kvstorage.WithTTL(time.Duration(opts.ttl)*time.Second),
+		kvstorage.WithMaxMemory(opts.maxMemory),
+		// changes of the keys are published to their channels, so the clients may watch them
+		kvstorage.WithNotifier(bus.KeyspaceNotifier()),
+	)...)
 	if storage == nil {
-		log.Fatal("Cannot initialize storage!")
+		lg.Fatal("Cannot initialize storage!")
 	}
 
 	// cleaner must be initialized before use
-	cleaner, err := vacuum.NewCleaner(storage, ttl)
+	cleaner, err := vacuum.NewCleaner(storage, opts.ttl, cleanerOpts...)
 	if err != nil {
-		log.Fatal("Cannot initialize cleaner!")
+		lg.Fatal("Cannot initialize cleaner!", "err", err)
 	}
 	// для очистки хранилища от старых элементов используем отдельный поток
 	go cleaner.Run()
 
 	server := &http.Server{
-		Addr: addr + ":" + strconv.Itoa(port),
+		Addr: opts.addr + ":" + strconv.Itoa(opts.port),
+	}
+	routerOpts := []router.Option{router.WithMaxKeyLength(opts.maxKeyLength), router.WithClock(clk)}
+	if opts.origins != "" {
+		routerOpts = append(routerOpts, router.WithAllowedOrigins(strings.Split(opts.origins, ",")...))
 	}
-	urlHandler := router.GetURLrouter(storage)
+	if opts.accessLog {
+		routerOpts = append(routerOpts, router.WithAccessLog(lg.With("component", "access")))
+	}
+	if tracer != nil {
+		routerOpts = append(routerOpts, router.WithTracer(tracer))
+	}
+	urlHandler := router.GetURLrouter(storage, opts.ttl, routerOpts...)
+	// every namespace has its own storage and cleaner, these are created and dropped via admin API
+	namespaces := namespace.NewRegistry(storageOpts, cleanerOpts, routerOpts)
+	pubsubHandler := router.GetPubSubRouter(bus, routerOpts...)
 
 	// для работы веб-сервера требуется определить обработчик URL
-	http.HandleFunc("/key/", urlHandler)
-	log.Fatal(server.ListenAndServe())
+	http.HandleFunc("/keys/", router.GetKeysRouter(storage))
+	http.HandleFunc("/txn", router.GetTxnRouter(storage, routerOpts...))
+	http.HandleFunc("/admin/ns/", router.GetNamespacesAdminRouter(namespaces))
+	// the settings are re-read either on SIGHUP or via admin API
+	settings := newReloader(flag.CommandLine, os.Args[1:], os.Environ(), storage, cleaner, lg)
+	reloadOnSignal(settings, lg)
+	http.HandleFunc("/admin/reload", router.GetReloadAdminRouter(settings))
+	http.HandleFunc("/subscribe", pubsubHandler)
+	http.HandleFunc("/ws", router.GetWebSocketRouter(storage, bus, opts.ttl, routerOpts...))
+	// paths with the keys are routed as is, http.ServeMux would redirect the keys like "a//b" or ".."
+	server.Handler = http.HandlerFunc(router.GetPrefixRouter(map[string]http.HandlerFunc{
+		"/key/":     urlHandler,
+		"/ns/":      router.GetNamespaceRouter(namespaces),
+		"/publish/": pubsubHandler,
+	}, http.DefaultServeMux))
+	var unixLis net.Listener
+	if opts.unixSocket != "" {
+		unixLis, err = listenUnix(opts.unixSocket, os.FileMode(socketMode))
+		if err != nil {
+			lg.Fatal("Cannot listen to Unix socket", "err", err)
+		}
+		removeOnSignal(opts.unixSocket)
+	}
+	// fatal exits the same as lg.Fatal, the Unix socket is removed before
+	fatal := func(msg string, err error) {
+		if unixLis != nil {
+			unixLis.Close()
+		}
+		lg.Fatal(msg, "err", err)
+	}
+	if opts.grpcPort != 0 {
+		lis, err := net.Listen("tcp", opts.addr+":"+strconv.Itoa(opts.grpcPort))
+		if err != nil {
+			fatal("Cannot listen to gRPC port", err)
+		}
+		grpcServer := grpc.NewServer()
+		kvpb.RegisterKVServer(grpcServer, grpcserver.NewServer(storage, bus,
+			grpcserver.WithTTL(time.Duration(opts.ttl)*time.Second),
+			grpcserver.WithMaxKeyLength(opts.maxKeyLength),
+		))
+		lg.Info("gRPC API is served", "addr", lis.Addr())
+		go func() {
+			fatal("gRPC server has stopped", grpcServer.Serve(lis))
+		}()
+	}
+	if unixLis != nil {
+		lg.Info("HTTP API is served", "addr", opts.unixSocket)
+		if opts.port == 0 {
+			fatal("HTTP server has stopped", server.Serve(unixLis))
+		}
+		go func() {
+			fatal("HTTP server has stopped", server.Serve(unixLis))
+		}()
+	}
+	lg.Info("HTTP API is served", "addr", server.Addr)
+	fatal("HTTP server has stopped", server.ListenAndServe())
+}
+
+// newTracer returns the tracer exporting the spans either to OTLP/HTTP collector or to the file,
+// it's nil if the requests are not traced
+func newTracer(opts options, lg *logger.Logger) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch {
+	case opts.traceEndpoint != "":
+		exporter = tracing.NewHTTPExporter(opts.traceEndpoint, serviceName)
+	case opts.traceFile != "":
+		file, err := tracing.NewFileExporter(opts.traceFile, serviceName)
+		if err != nil {
+			return nil, err
+		}
+		exporter = file
+	default:
+		return nil, nil
+	}
+	return tracing.NewTracer(exporter, tracing.WithErrorHandler(func(err error) {
+		lg.Warn("spans are not exported", "err", err)
+	})), nil
+}
+
+// unixListener - the listener of Unix domain socket which removes the socket once it's closed
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+// Addr returns the socket's path, not the one it was created at
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close stops listening and removes the socket
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	if rmErr := os.Remove(l.path); err == nil && !os.IsNotExist(rmErr) {
+		err = rmErr
+	}
+	return err
+}
+
+// listenUnix listens to Unix domain socket with the file permissions.
+// The socket left by the server which is not running anymore is replaced, the one in use is not.
+// The socket is created in the private directory next to path and is moved to path once it has the permissions,
+// so nobody may connect to it before. It's removed once the listener is closed.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %v is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	// the directory is accessible by the owner only
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".kvserver-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	lis, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is moved, unixListener removes it by its path
+	lis.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return &unixListener{Listener: lis, path: path}, nil
+}
+
+// removeOnSignal removes the Unix socket once the server gets SIGINT or SIGTERM,
+// then the signal terminates the server as usual
+func removeOnSignal(path string) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-stop
+		os.Remove(path)
+		signal.Stop(stop)
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			p.Signal(sig)
+		}
+	}()
 }
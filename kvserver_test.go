@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_listenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvserver.sock")
+
+	lis, err := listenUnix(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("socket's permissions = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+	if _, err := listenUnix(path, 0600); err == nil {
+		t.Error("listenUnix() replaced the socket in use")
+	}
+	// the private directory the socket is created in is removed
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("socket's directory has %v entries, want the socket only, err = %v", len(entries), err)
+	}
+
+	// the socket of the server which has crashed is left behind
+	lis.(*unixListener).Listener.Close()
+	lis, err = listenUnix(path, 0660)
+	if err != nil {
+		t.Fatalf("listenUnix() didn't replace the stale socket: %v", err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("replaced socket's permissions = %v, want %v", info.Mode().Perm(), os.FileMode(0660))
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("cannot connect to the socket: %v", err)
+	}
+	conn.Close()
+	lis.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket is not removed on Close(), err = %v", err)
+	}
+}
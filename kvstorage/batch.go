@@ -0,0 +1,34 @@
+package kvstorage
+
+import "time"
+
+// how often the time budget of the batch is checked, in elements
+const batchCheckPeriod = 32
+
+// batch - bounds the work done within one critical section
+type batch struct {
+	limit    int       // maximum number of elements
+	deadline time.Time // zero if there is no time limit
+	done     int       // number of processed elements
+}
+
+// newBatch starts the batch. The budget is measured by the real clock,
+// since it limits how long the storage is locked rather than the storage's time.
+func newBatch(limit int, budget time.Duration) *batch {
+	b := &batch{limit: limit}
+	if budget > 0 {
+		b.deadline = time.Now().Add(budget)
+	}
+	return b
+}
+
+// next reports whether one more element may be processed
+func (b *batch) next() bool {
+	if b.done >= b.limit {
+		return false
+	}
+	if b.deadline.IsZero() || b.done == 0 || b.done%batchCheckPeriod != 0 {
+		return true
+	}
+	return time.Now().Before(b.deadline)
+}
@@ -0,0 +1,159 @@
+package kvstorage
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/proway2/kvserver/element"
+)
+
+// ErrNotInteger - the value is not an integer or the result of the operation is out of range
+var ErrNotInteger = errors.New("value is not an integer or out of range")
+
+// HSet sets the field of the hash, the hash is created if the key is not in the storage.
+// The whole hash expires at once, any change starts its lifetime over.
+// It returns true if the field is new.
+func (kv *KVStorage) HSet(key, field, value string) (bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return false, errors.New("hset: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("HSet")()
+	now := kv.clock.Now()
+	elem, found, err := kv.writable(key, element.Hash, now)
+	if err != nil {
+		return false, err
+	}
+	exists := false
+	if found {
+		_, exists = elem.Hash[field]
+	}
+	if err := kv.setField(key, field, value, elem, now); err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+// HGet returns the value of the hash's field.
+// The second value reports whether both the key and the field are in the storage.
+func (kv *KVStorage) HGet(key, field string) (string, bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return "", false, errors.New("hget: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("HGet")()
+	elem, found, err := kv.readable(key, element.Hash, kv.clock.Now())
+	if err != nil || !found {
+		return "", false, err
+	}
+	kv.accessed(elem)
+	value, ok := elem.Hash[field]
+	return value, ok, nil
+}
+
+// HGetAll returns a copy of all fields of the hash.
+// The second value reports whether the key is in the storage.
+func (kv *KVStorage) HGetAll(key string) (map[string]string, bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return nil, false, errors.New("hgetall: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("HGetAll")()
+	elem, found, err := kv.readable(key, element.Hash, kv.clock.Now())
+	if err != nil || !found {
+		return nil, false, err
+	}
+	kv.accessed(elem)
+	fields := make(map[string]string, len(elem.Hash))
+	for field, value := range elem.Hash {
+		fields[field] = value
+	}
+	return fields, true, nil
+}
+
+// HDel removes the fields from the hash and returns the number of removed fields.
+// The hash is removed from the storage along with its last field.
+func (kv *KVStorage) HDel(key string, fields ...string) (int, error) {
+	if !kv.initialized || len(key) == 0 {
+		return 0, errors.New("hdel: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("HDel")()
+	elem, found, err := kv.readable(key, element.Hash, kv.clock.Now())
+	if err != nil || !found {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range fields {
+		if value, ok := elem.Hash[field]; ok {
+			delete(elem.Hash, field)
+			kv.resize(elem, uint64(len(field)+len(value)), 0)
+			removed++
+		}
+	}
+	switch {
+	case len(elem.Hash) == 0:
+		kv.purgeElement(key)
+	case removed > 0:
+		kv.updated(key, elem)
+	}
+	return removed, nil
+}
+
+// HIncrBy adds incr to the integer value of the hash's field and returns the new value.
+// Missing field or hash is created with the value of incr.
+func (kv *KVStorage) HIncrBy(key, field string, incr int64) (int64, error) {
+	if !kv.initialized || len(key) == 0 {
+		return 0, errors.New("hincrby: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("HIncrBy")()
+	now := kv.clock.Now()
+	elem, found, err := kv.writable(key, element.Hash, now)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	old, exists := "", false
+	if found {
+		old, exists = elem.Hash[field]
+	}
+	if exists {
+		if current, err = strconv.ParseInt(old, 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if (incr > 0 && current > math.MaxInt64-incr) || (incr < 0 && current < math.MinInt64-incr) {
+		return 0, ErrNotInteger
+	}
+	current += incr
+	if err := kv.setField(key, field, strconv.FormatInt(current, 10), elem, now); err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+// setField sets the field of the hash element, the hash is created if elem is nil.
+// MUST be called within critical section.
+func (kv *KVStorage) setField(key, field, value string, elem *element.Element, now time.Time) error {
+	old, exists := "", false
+	var keySize uint64
+	if elem != nil {
+		old, exists = elem.Hash[field]
+	} else {
+		keySize = uint64(len(key))
+	}
+	size, freed := uint64(len(field)+len(value)), uint64(0)
+	if exists {
+		freed = uint64(len(field) + len(old))
+	}
+	if !kv.fits(freed, size+keySize) {
+		return ErrNoMemory
+	}
+	if elem == nil {
+		elem = kv.create(key, element.Hash, now)
+		elem.Hash = make(map[string]string)
+	} else {
+		kv.updated(key, elem)
+	}
+	elem.Hash[field] = value
+	kv.resize(elem, freed, size)
+	return nil
+}
@@ -0,0 +1,160 @@
+package kvstorage
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/clock/clocktest"
+)
+
+func TestKVStorage_Hash(t *testing.T) {
+	storage := NewStorage(WithMaxMemory(64))
+	check(storage.Set("string", KEYVALUE), t)
+
+	tests := []struct {
+		name       string
+		op         func() (interface{}, error)
+		want       interface{}
+		wantErr    error
+		wantFields map[string]string // fields of the hash after the operation, nil if there is no hash
+	}{
+		{
+			name:       "Setting the field creates the hash",
+			op:         func() (interface{}, error) { return storage.HSet("hash", "name", "John") },
+			want:       true,
+			wantFields: map[string]string{"name": "John"},
+		},
+		{
+			name:       "Updating the field",
+			op:         func() (interface{}, error) { return storage.HSet("hash", "name", "Jane") },
+			want:       false,
+			wantFields: map[string]string{"name": "Jane"},
+		},
+		{
+			name: "Getting the field",
+			op: func() (interface{}, error) {
+				value, _, err := storage.HGet("hash", "name")
+				return value, err
+			},
+			want:       "Jane",
+			wantFields: map[string]string{"name": "Jane"},
+		},
+		{
+			name: "Getting missing field",
+			op: func() (interface{}, error) {
+				_, found, err := storage.HGet("hash", "age")
+				return found, err
+			},
+			want:       false,
+			wantFields: map[string]string{"name": "Jane"},
+		},
+		{
+			name:       "Incrementing missing field",
+			op:         func() (interface{}, error) { return storage.HIncrBy("hash", "visits", 5) },
+			want:       int64(5),
+			wantFields: map[string]string{"name": "Jane", "visits": "5"},
+		},
+		{
+			name:       "Incrementing the field",
+			op:         func() (interface{}, error) { return storage.HIncrBy("hash", "visits", -7) },
+			want:       int64(-2),
+			wantFields: map[string]string{"name": "Jane", "visits": "-2"},
+		},
+		{
+			name:       "Incrementing not an integer",
+			op:         func() (interface{}, error) { return storage.HIncrBy("hash", "name", 1) },
+			want:       int64(0),
+			wantErr:    ErrNotInteger,
+			wantFields: map[string]string{"name": "Jane", "visits": "-2"},
+		},
+		{
+			name:       "Increment overflows",
+			op:         func() (interface{}, error) { return storage.HIncrBy("hash", "visits", math.MinInt64) },
+			want:       int64(0),
+			wantErr:    ErrNotInteger,
+			wantFields: map[string]string{"name": "Jane", "visits": "-2"},
+		},
+		{
+			name:       "Memory quota is exceeded",
+			op:         func() (interface{}, error) { return storage.HSet("hash", "bio", string(make([]byte, 64))) },
+			want:       false,
+			wantErr:    ErrNoMemory,
+			wantFields: map[string]string{"name": "Jane", "visits": "-2"},
+		},
+		{
+			name:       "Hash operation on the string",
+			op:         func() (interface{}, error) { return storage.HSet("string", "name", "John") },
+			want:       false,
+			wantErr:    ErrWrongType,
+			wantFields: map[string]string{"name": "Jane", "visits": "-2"},
+		},
+		{
+			name: "String operation on the hash",
+			op: func() (interface{}, error) {
+				value, err := storage.Get("hash")
+				return value == nil, err
+			},
+			want:       true,
+			wantErr:    ErrWrongType,
+			wantFields: map[string]string{"name": "Jane", "visits": "-2"},
+		},
+		{
+			name:       "Deleting the fields",
+			op:         func() (interface{}, error) { return storage.HDel("hash", "visits", "age") },
+			want:       1,
+			wantFields: map[string]string{"name": "Jane"},
+		},
+		{
+			name:       "Deleting the last field removes the hash",
+			op:         func() (interface{}, error) { return storage.HDel("hash", "name") },
+			want:       1,
+			wantFields: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if err != tt.wantErr {
+				t.Errorf("KVStorage error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KVStorage = %v, want %v", got, tt.want)
+			}
+			fields, found, err := storage.HGetAll("hash")
+			if err != nil || found != (tt.wantFields != nil) || !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("KVStorage.HGetAll() = %v, %v, %v, want %v", fields, found, err, tt.wantFields)
+			}
+		})
+	}
+	// only the string is left in the storage
+	if memory, _ := storage.MemoryUsage(); memory != uint64(len("string"+KEYVALUE)) {
+		t.Errorf("KVStorage.MemoryUsage() = %v, want %v", memory, len("string"+KEYVALUE))
+	}
+}
+
+func TestKVStorage_Hash_TTL(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	storage := NewStorage(WithTTL(time.Minute), WithClock(clk))
+	for i := 0; i < 3; i++ {
+		_, err := storage.HSet("hash", "field"+strconv.Itoa(i), KEYVALUE)
+		check(err, t)
+		// every change starts the lifetime of the whole hash over
+		clk.Advance(50 * time.Second)
+	}
+	if _, found, _ := storage.HGet("hash", "field0"); !found {
+		t.Errorf("KVStorage.HGet() the hash has expired too early")
+	}
+	clk.Advance(11 * time.Second)
+	if _, found, _ := storage.HGetAll("hash"); found {
+		t.Errorf("KVStorage.HGetAll() the hash has not expired")
+	}
+	// expired hash is replaced by the new one
+	check(storage.Set("hash", KEYVALUE), t)
+	if value, err := storage.Get("hash"); err != nil || string(value) != KEYVALUE {
+		t.Errorf("KVStorage.Get() = %s, %v, want %v", value, err, KEYVALUE)
+	}
+}
@@ -0,0 +1,291 @@
+package kvstorage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/proway2/kvserver/element"
+)
+
+// LPush inserts the values at the head of the list one by one, the list is created if the key is not in the storage.
+// The whole list expires at once, any change starts its lifetime over.
+// It returns the length of the list.
+func (kv *KVStorage) LPush(key string, values ...string) (int, error) {
+	return kv.push(key, values, true)
+}
+
+// RPush appends the values to the tail of the list, see LPush.
+func (kv *KVStorage) RPush(key string, values ...string) (int, error) {
+	return kv.push(key, values, false)
+}
+
+// LPop removes and returns the first value of the list.
+// The second value reports whether there is a value, the list is removed along with its last value.
+func (kv *KVStorage) LPop(key string) (string, bool, error) {
+	return kv.lockedPop(key, true)
+}
+
+// RPop removes and returns the last value of the list, see LPop.
+func (kv *KVStorage) RPop(key string) (string, bool, error) {
+	return kv.lockedPop(key, false)
+}
+
+// BLPop is the same as LPop, but if the list is empty it waits for the value to be pushed
+// until the timeout expires or ctx is done. Zero timeout means no timeout.
+func (kv *KVStorage) BLPop(ctx context.Context, key string, timeout time.Duration) (string, bool, error) {
+	return kv.blockingPop(ctx, key, timeout, true)
+}
+
+// BRPop is the same as RPop, but it waits for the value to be pushed, see BLPop.
+func (kv *KVStorage) BRPop(ctx context.Context, key string, timeout time.Duration) (string, bool, error) {
+	return kv.blockingPop(ctx, key, timeout, false)
+}
+
+// LRange returns the values of the list from start to stop, both inclusive.
+// Negative index counts from the tail of the list, i.e. -1 is the last value.
+// The second value reports whether the key is in the storage.
+func (kv *KVStorage) LRange(key string, start, stop int) ([]string, bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return nil, false, errors.New("lrange: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("LRange")()
+	elem, found, err := kv.readable(key, element.List, kv.clock.Now())
+	if err != nil || !found {
+		return nil, false, err
+	}
+	kv.accessed(elem)
+	values := []string{}
+	start, stop, ok := listRange(elem.List.Len(), start, stop)
+	if !ok {
+		return values, true, nil
+	}
+	e := elem.List.Front()
+	for i := 0; i < start; i++ {
+		e = e.Next()
+	}
+	for i := start; i <= stop; i++ {
+		values = append(values, e.Value.(string))
+		e = e.Next()
+	}
+	return values, true, nil
+}
+
+// LTrim keeps the values of the list from start to stop, both inclusive, the rest is removed.
+// Indexes are the same as for LRange. The list is removed if no values are left.
+// It returns false if the key is not in the storage.
+func (kv *KVStorage) LTrim(key string, start, stop int) (bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return false, errors.New("ltrim: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("LTrim")()
+	elem, found, err := kv.readable(key, element.List, kv.clock.Now())
+	if err != nil || !found {
+		return false, err
+	}
+	length := elem.List.Len()
+	start, stop, ok := listRange(length, start, stop)
+	if !ok {
+		kv.purgeElement(key)
+		return true, nil
+	}
+	for i := 0; i < start; i++ {
+		kv.removeValue(elem, elem.List.Front())
+	}
+	for i := stop + 1; i < length; i++ {
+		kv.removeValue(elem, elem.List.Back())
+	}
+	if start > 0 || stop < length-1 {
+		kv.updated(key, elem)
+	}
+	return true, nil
+}
+
+// push inserts the values at either the head or the tail of the list
+func (kv *KVStorage) push(key string, values []string, head bool) (int, error) {
+	if !kv.initialized || len(key) == 0 || len(values) == 0 {
+		return 0, errors.New("push: Storage is not initialized, key is empty or no values provided")
+	}
+	op := "RPush"
+	if head {
+		op = "LPush"
+	}
+	defer kv.lock(op)()
+	now := kv.clock.Now()
+	elem, found, err := kv.writable(key, element.List, now)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	for _, value := range values {
+		size += uint64(len(value))
+	}
+	if !found {
+		size += uint64(len(key))
+	}
+	if !kv.fits(0, size) {
+		return 0, ErrNoMemory
+	}
+	if found {
+		kv.updated(key, elem)
+	} else {
+		elem = kv.create(key, element.List, now)
+		elem.List = list.New()
+		size -= uint64(len(key)) // already counted by create
+	}
+	for _, value := range values {
+		if head {
+			elem.List.PushFront(value)
+		} else {
+			elem.List.PushBack(value)
+		}
+	}
+	kv.resize(elem, 0, size)
+	kv.wake(key)
+	return elem.List.Len(), nil
+}
+
+// lockedPop removes and returns either the first or the last value of the list
+func (kv *KVStorage) lockedPop(key string, head bool) (string, bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return "", false, errors.New("pop: Storage is not initialized or key is empty")
+	}
+	op := "RPop"
+	if head {
+		op = "LPop"
+	}
+	defer kv.lock(op)()
+	return kv.pop(key, head)
+}
+
+// pop removes and returns either the first or the last value of the list.
+// MUST be called within critical section.
+func (kv *KVStorage) pop(key string, head bool) (string, bool, error) {
+	elem, found, err := kv.readable(key, element.List, kv.clock.Now())
+	if err != nil || !found {
+		return "", false, err
+	}
+	e := elem.List.Back()
+	if head {
+		e = elem.List.Front()
+	}
+	value := kv.removeValue(elem, e)
+	if elem.List.Len() == 0 {
+		kv.purgeElement(key)
+	} else {
+		kv.updated(key, elem)
+	}
+	return value, true, nil
+}
+
+// blockingPop pops the value, waiting for it to be pushed if the list is empty
+func (kv *KVStorage) blockingPop(ctx context.Context, key string, timeout time.Duration, head bool) (string, bool, error) {
+	if !kv.initialized || len(key) == 0 || timeout < 0 {
+		return "", false, errors.New("blockingpop: Storage is not initialized, key is empty or timeout is negative")
+	}
+	op := "BRPop"
+	if head {
+		op = "BLPop"
+	}
+	var expired <-chan time.Time
+	for {
+		unlock := kv.lock(op)
+		value, found, err := kv.pop(key, head)
+		if err != nil || found {
+			unlock()
+			return value, found, err
+		}
+		wake := make(chan struct{}, 1)
+		waiter := kv.wait(key, wake)
+		if expired == nil && timeout > 0 {
+			// the timer is set once the client is waiting, so the clock's waiter means the client's one
+			expired = kv.clock.After(timeout)
+		}
+		unlock()
+
+		select {
+		case <-wake:
+			// somebody pushed the value, but others may take it first
+			continue
+		case <-expired:
+			err = nil
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		unlock = kv.lock(op)
+		kv.stopWaiting(key, waiter)
+		unlock()
+		return "", false, err
+	}
+}
+
+// wait registers the channel which receives when the values are pushed to the list.
+// MUST be called within critical section.
+func (kv *KVStorage) wait(key string, wake chan struct{}) *list.Element {
+	if kv.waiters == nil {
+		kv.waiters = make(map[string]*list.List)
+	}
+	waiters, ok := kv.waiters[key]
+	if !ok {
+		waiters = list.New()
+		kv.waiters[key] = waiters
+	}
+	return waiters.PushBack(wake)
+}
+
+// stopWaiting removes the waiter registered by wait, unless it has been woken up already.
+// MUST be called within critical section.
+func (kv *KVStorage) stopWaiting(key string, waiter *list.Element) {
+	waiters, ok := kv.waiters[key]
+	if !ok {
+		return
+	}
+	// the waiter is not removed if it belongs to another list, i.e. it has been woken up already
+	waiters.Remove(waiter)
+	if waiters.Len() == 0 {
+		delete(kv.waiters, key)
+	}
+}
+
+// wake wakes up all clients waiting for the values of the list, they race for the values.
+// MUST be called within critical section.
+func (kv *KVStorage) wake(key string) {
+	waiters, ok := kv.waiters[key]
+	if !ok {
+		return
+	}
+	for e := waiters.Front(); e != nil; e = e.Next() {
+		e.Value.(chan struct{}) <- struct{}{}
+	}
+	delete(kv.waiters, key)
+}
+
+// removeValue removes the value from the list and returns it.
+// MUST be called within critical section.
+func (kv *KVStorage) removeValue(elem *element.Element, e *list.Element) string {
+	value := elem.List.Remove(e).(string)
+	kv.resize(elem, uint64(len(value)), 0)
+	return value
+}
+
+// listRange converts the indexes which may be negative into the range within the list of the length.
+// It returns false if the range is empty.
+func listRange(length, start, stop int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
@@ -0,0 +1,198 @@
+package kvstorage
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/clock/clocktest"
+)
+
+func TestKVStorage_List(t *testing.T) {
+	storage := NewStorage()
+	check(storage.Set("string", KEYVALUE), t)
+
+	tests := []struct {
+		name       string
+		op         func() (interface{}, error)
+		want       interface{}
+		wantErr    error
+		wantValues []string // values of the list after the operation, nil if there is no list
+	}{
+		{
+			name:       "Pushing to the tail creates the list",
+			op:         func() (interface{}, error) { return storage.RPush("list", "b", "c") },
+			want:       2,
+			wantValues: []string{"b", "c"},
+		},
+		{
+			name:       "Pushing to the head",
+			op:         func() (interface{}, error) { return storage.LPush("list", "a", "z") },
+			want:       4,
+			wantValues: []string{"z", "a", "b", "c"},
+		},
+		{
+			name: "Popping from the head",
+			op: func() (interface{}, error) {
+				value, _, err := storage.LPop("list")
+				return value, err
+			},
+			want:       "z",
+			wantValues: []string{"a", "b", "c"},
+		},
+		{
+			name: "Popping from the tail",
+			op: func() (interface{}, error) {
+				value, _, err := storage.RPop("list")
+				return value, err
+			},
+			want:       "c",
+			wantValues: []string{"a", "b"},
+		},
+		{
+			name: "Range with negative indexes",
+			op: func() (interface{}, error) {
+				values, _, err := storage.LRange("list", -1, 10)
+				return values, err
+			},
+			want:       []string{"b"},
+			wantValues: []string{"a", "b"},
+		},
+		{
+			name: "Empty range",
+			op: func() (interface{}, error) {
+				values, _, err := storage.LRange("list", 5, 10)
+				return values, err
+			},
+			want:       []string{},
+			wantValues: []string{"a", "b"},
+		},
+		{
+			name:       "List operation on the string",
+			op:         func() (interface{}, error) { return storage.RPush("string", "a") },
+			want:       0,
+			wantErr:    ErrWrongType,
+			wantValues: []string{"a", "b"},
+		},
+		{
+			name:       "Trimming the list",
+			op:         func() (interface{}, error) { return storage.LTrim("list", 1, -1) },
+			want:       true,
+			wantValues: []string{"b"},
+		},
+		{
+			name: "Popping the last value removes the list",
+			op: func() (interface{}, error) {
+				value, _, err := storage.LPop("list")
+				return value, err
+			},
+			want:       "b",
+			wantValues: nil,
+		},
+		{
+			name: "Popping from missing list",
+			op: func() (interface{}, error) {
+				_, found, err := storage.RPop("list")
+				return found, err
+			},
+			want:       false,
+			wantValues: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if err != tt.wantErr {
+				t.Errorf("KVStorage error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KVStorage = %v, want %v", got, tt.want)
+			}
+			values, found, err := storage.LRange("list", 0, -1)
+			if err != nil || found != (tt.wantValues != nil) || !reflect.DeepEqual(values, tt.wantValues) {
+				t.Errorf("KVStorage.LRange() = %v, %v, %v, want %v", values, found, err, tt.wantValues)
+			}
+		})
+	}
+	// only the string is left in the storage
+	if memory, _ := storage.MemoryUsage(); memory != uint64(len("string"+KEYVALUE)) {
+		t.Errorf("KVStorage.MemoryUsage() = %v, want %v", memory, len("string"+KEYVALUE))
+	}
+}
+
+func Test_listRange(t *testing.T) {
+	tests := []struct {
+		name                string
+		length, start, stop int
+		wantStart, wantStop int
+		wantOK              bool
+	}{
+		{name: "Whole list", length: 5, start: 0, stop: -1, wantStart: 0, wantStop: 4, wantOK: true},
+		{name: "Indexes out of the list", length: 5, start: -10, stop: 10, wantStart: 0, wantStop: 4, wantOK: true},
+		{name: "Start after stop", length: 5, start: 3, stop: 1},
+		{name: "Start after the tail", length: 5, start: 5, stop: 10},
+		{name: "Empty list", length: 0, start: 0, stop: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, stop, ok := listRange(tt.length, tt.start, tt.stop)
+			if start != tt.wantStart || stop != tt.wantStop || ok != tt.wantOK {
+				t.Errorf("listRange() = %v, %v, %v, want %v, %v, %v",
+					start, stop, ok, tt.wantStart, tt.wantStop, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestKVStorage_BLPop(t *testing.T) {
+	type result struct {
+		value string
+		found bool
+		err   error
+	}
+	var clk *clocktest.Fake
+	var storage *KVStorage
+	pop := func(ctx context.Context) <-chan result {
+		// every client has its own storage and clock, so the timers of the others don't interfere
+		clk = clocktest.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+		storage = NewStorage(WithClock(clk))
+		done := make(chan result, 1)
+		go func() {
+			value, found, err := storage.BLPop(ctx, "queue", time.Minute)
+			done <- result{value, found, err}
+		}()
+		return done
+	}
+
+	t.Run("Value is pushed while waiting", func(t *testing.T) {
+		done := pop(context.Background())
+		// the client is waiting for the value
+		clk.BlockUntil(1)
+		_, err := storage.RPush("queue", "second")
+		check(err, t)
+		if got := <-done; got != (result{"second", true, nil}) {
+			t.Errorf("KVStorage.BLPop() = %v", got)
+		}
+	})
+	t.Run("Timeout expires", func(t *testing.T) {
+		done := pop(context.Background())
+		clk.BlockUntil(1)
+		clk.Advance(time.Minute)
+		if got := <-done; got != (result{}) {
+			t.Errorf("KVStorage.BLPop() = %v", got)
+		}
+	})
+	t.Run("Client goes away", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := pop(ctx)
+		clk.BlockUntil(1)
+		cancel()
+		if got := <-done; got.found || got.err != context.Canceled {
+			t.Errorf("KVStorage.BLPop() = %v", got)
+		}
+		if len(storage.waiters) != 0 {
+			t.Errorf("KVStorage.BLPop() waiters = %v, want none", storage.waiters)
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package kvstorage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/proway2/kvserver/element"
+)
+
+var (
+	// ErrLocked - the lock is held by another owner, it can't be acquired, changed or removed
+	ErrLocked = errors.New("lock is held by another owner")
+	// ErrNotOwner - the token doesn't match the owner's token of the lock
+	ErrNotOwner = errors.New("token doesn't match the lock's owner")
+)
+
+// tokenSize - random bytes in the owner's token
+const tokenSize = 16
+
+// Lease - the lock held by the owner
+type Lease struct {
+	Token   string    // owner's token, it's required to renew or release the lock
+	Fence   uint64    // fencing token, the holder with the lower one is stale
+	Expires time.Time // the lock is released by itself unless it's renewed before
+}
+
+// Acquire takes the lock if the key is not in the storage, the lock is held for the lease.
+// Fencing token of the lock is greater than the one of any lock acquired before in the storage,
+// so the resource protected by the lock may reject the requests of stale holders.
+// It returns ErrLocked if the lock is held by another owner.
+func (kv *KVStorage) Acquire(key string, lease time.Duration) (Lease, error) {
+	if !kv.initialized || len(key) == 0 || lease <= 0 {
+		return Lease{}, errors.New("acquire: Storage is not initialized, key is empty or lease is not positive")
+	}
+	token, err := newToken()
+	if err != nil {
+		return Lease{}, err
+	}
+	defer kv.lock("Acquire")()
+	now := kv.clock.Now()
+	_, found, err := kv.writable(key, element.Lock, now)
+	if err != nil {
+		return Lease{}, err
+	}
+	if found {
+		return Lease{}, ErrLocked
+	}
+	if !kv.fits(0, uint64(len(key)+len(token))) {
+		return Lease{}, ErrNoMemory
+	}
+	elem := kv.create(key, element.Lock, now)
+	elem.Val = token
+	elem.Fence = kv.revision
+	kv.resize(elem, 0, uint64(len(token)))
+	kv.lease(key, elem, now.Add(lease))
+	return Lease{Token: token, Fence: elem.Fence, Expires: elem.Expires}, nil
+}
+
+// Renew extends the lock's lease, it's counted from now. The fencing token is kept.
+// It returns false if the lock is not held, i.e. it's released or its lease is over,
+// and ErrNotOwner if the token doesn't match.
+func (kv *KVStorage) Renew(key, token string, lease time.Duration) (Lease, bool, error) {
+	if !kv.initialized || len(key) == 0 || lease <= 0 {
+		return Lease{}, false, errors.New("renew: Storage is not initialized, key is empty or lease is not positive")
+	}
+	defer kv.lock("Renew")()
+	now := kv.clock.Now()
+	elem, found, err := kv.owned(key, token, now)
+	if err != nil || !found {
+		return Lease{}, false, err
+	}
+	kv.lease(key, elem, now.Add(lease))
+	return Lease{Token: token, Fence: elem.Fence, Expires: elem.Expires}, true, nil
+}
+
+// Release removes the lock, so it may be acquired again.
+// It returns false if the lock is not held and ErrNotOwner if the token doesn't match, see Renew.
+func (kv *KVStorage) Release(key, token string) (bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return false, errors.New("release: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("Release")()
+	_, found, err := kv.owned(key, token, kv.clock.Now())
+	if err != nil || !found {
+		return false, err
+	}
+	kv.purgeElement(key)
+	return true, nil
+}
+
+// owned returns the lock held by the owner of the token.
+// MUST be called within critical section.
+func (kv *KVStorage) owned(key, token string, now time.Time) (*element.Element, bool, error) {
+	elem, found, err := kv.readable(key, element.Lock, now)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if elem.Val != token {
+		return nil, false, ErrNotOwner
+	}
+	return elem, true, nil
+}
+
+// lease sets the time the lock is released by itself at.
+// MUST be called within critical section.
+func (kv *KVStorage) lease(key string, elem *element.Element, expires time.Time) {
+	kv.detach(elem)
+	elem.Expires = expires
+	kv.wheel.add(key, elem)
+	kv.changed(key, elem)
+}
+
+// locked reports whether the element is the lock which is still held.
+// Such element is changed by its owner only, see Renew and Release.
+// MUST be called within critical section.
+func (kv *KVStorage) locked(elem *element.Element, now time.Time) bool {
+	return elem.Kind == element.Lock && !kv.expired(elem, now)
+}
+
+// newToken returns random owner's token
+func newToken() (string, error) {
+	b := make([]byte, tokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,74 @@
+package kvstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/clock/clocktest"
+)
+
+func TestKVStorage_Lock(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	storage := NewStorage(WithClock(clk))
+
+	first, err := storage.Acquire("lock", time.Minute)
+	check(err, t)
+	if len(first.Token) != 2*tokenSize || !first.Expires.Equal(start.Add(time.Minute)) {
+		t.Errorf("KVStorage.Acquire() = %+v", first)
+	}
+	if _, err := storage.Acquire("lock", time.Minute); err != ErrLocked {
+		t.Errorf("KVStorage.Acquire() the lock is held, error = %v, want %v", err, ErrLocked)
+	}
+
+	// nobody but the owner changes the lock
+	if err := storage.Set("lock", KEYVALUE); err != ErrLocked {
+		t.Errorf("KVStorage.Set() error = %v, want %v", err, ErrLocked)
+	}
+	if _, err := storage.Delete("lock"); err != ErrLocked {
+		t.Errorf("KVStorage.Delete() error = %v, want %v", err, ErrLocked)
+	}
+	if _, err := storage.Persist("lock"); err != ErrLocked {
+		t.Errorf("KVStorage.Persist() error = %v, want %v", err, ErrLocked)
+	}
+	if _, _, err := storage.Renew("lock", "stranger", time.Minute); err != ErrNotOwner {
+		t.Errorf("KVStorage.Renew() error = %v, want %v", err, ErrNotOwner)
+	}
+	if _, err := storage.Release("lock", "stranger"); err != ErrNotOwner {
+		t.Errorf("KVStorage.Release() error = %v, want %v", err, ErrNotOwner)
+	}
+
+	clk.Advance(50 * time.Second)
+	renewed, found, err := storage.Renew("lock", first.Token, time.Minute)
+	check(err, t)
+	if !found || renewed.Fence != first.Fence || !renewed.Expires.Equal(start.Add(110*time.Second)) {
+		t.Errorf("KVStorage.Renew() = %+v, %v", renewed, found)
+	}
+
+	// the lease is over, the lock is taken by the next owner
+	clk.Advance(time.Minute)
+	if _, found, err := storage.Renew("lock", first.Token, time.Minute); found || err != nil {
+		t.Errorf("KVStorage.Renew() the lease is over = %v, %v", found, err)
+	}
+	second, err := storage.Acquire("lock", time.Minute)
+	check(err, t)
+	if second.Fence <= first.Fence || second.Token == first.Token {
+		t.Errorf("KVStorage.Acquire() = %+v, the previous lease %+v", second, first)
+	}
+	// the stale holder can't release the lock of the next owner
+	if _, err := storage.Release("lock", first.Token); err != ErrNotOwner {
+		t.Errorf("KVStorage.Release() error = %v, want %v", err, ErrNotOwner)
+	}
+	if released, err := storage.Release("lock", second.Token); !released || err != nil {
+		t.Errorf("KVStorage.Release() = %v, %v", released, err)
+	}
+	if memory, _ := storage.MemoryUsage(); memory != 0 {
+		t.Errorf("KVStorage.MemoryUsage() = %v, want 0", memory)
+	}
+
+	// the key holding other kind of value is not absent
+	check(storage.Set("string", KEYVALUE), t)
+	if _, err := storage.Acquire("string", time.Minute); err != ErrWrongType {
+		t.Errorf("KVStorage.Acquire() error = %v, want %v", err, ErrWrongType)
+	}
+}
@@ -0,0 +1,106 @@
+package kvstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/proway2/kvserver/clock"
+)
+
+// ExpirationMode - defines how element's lifetime is counted
+type ExpirationMode int
+
+const (
+	// ExpireAfterWrite - lifetime is counted from the last update of the element (default)
+	ExpireAfterWrite ExpirationMode = iota
+	// ExpireAfterAccess - lifetime is counted from the last access to the element,
+	// i.e. every read extends it (sliding expiration)
+	ExpireAfterAccess
+)
+
+// Option configures the storage, see NewStorage
+type Option func(*KVStorage)
+
+// WithExpirationMode sets how element's lifetime is counted
+func WithExpirationMode(mode ExpirationMode) Option {
+	return func(kv *KVStorage) {
+		kv.mode = mode
+	}
+}
+
+// WithWheelTick sets the resolution of the timing wheel used for the elements
+// with absolute expiration time, such elements may expire up to one tick later
+func WithWheelTick(tick time.Duration) Option {
+	return func(kv *KVStorage) {
+		if tick > 0 {
+			kv.wheelTick = tick
+		}
+	}
+}
+
+// WithClock sets the source of the current time, the real clock is used by default
+func WithClock(c clock.Clock) Option {
+	return func(kv *KVStorage) {
+		if c != nil {
+			kv.clock = c
+		}
+	}
+}
+
+// WithTTL sets element's lifetime, so the storage never returns elements which outlived it,
+// even if the cleaner has not removed them yet. Zero TTL means elements expire by the cleaner only.
+// Elements with absolute expiration time are never returned past that time regardless of this option.
+func WithTTL(ttl time.Duration) Option {
+	return func(kv *KVStorage) {
+		if ttl > 0 {
+			kv.ttl = ttl
+		}
+	}
+}
+
+// WithDeleteOnRead makes the storage remove expired elements as soon as they are accessed,
+// otherwise they are hidden from readers and are left for the cleaner
+func WithDeleteOnRead() Option {
+	return func(kv *KVStorage) {
+		kv.deleteOnRead = true
+	}
+}
+
+// WithMaxMemory sets the memory quota for keys and values, bytes.
+// Set fails with ErrNoMemory if the element does not fit into it. Zero means no quota.
+func WithMaxMemory(bytes uint64) Option {
+	return func(kv *KVStorage) {
+		kv.maxMemory = bytes
+	}
+}
+
+// WithNotifier sets the function which gets every change of the keys: the key and its new version,
+// zero version means the key is deleted, expired or purged. It's called within the storage's lock
+// in the order the changes are made, so it must be quick and must never call the storage.
+func WithNotifier(notifier func(key string, version uint64)) Option {
+	return func(kv *KVStorage) {
+		kv.notifier = notifier
+	}
+}
+
+// WithPurgeNotifier sets the function which gets the keys of the expired elements purged by the cleaner,
+// see DeleteFrontOlder and DeleteExpired. Unlike the notifier of WithNotifier it's called after the storage
+// is unlocked, once the batch of the elements is purged, so it may be slow and may use the storage.
+func WithPurgeNotifier(notifier func(key string)) Option {
+	return func(kv *KVStorage) {
+		kv.purgeNotifier = notifier
+	}
+}
+
+// Tracer gets the timings of the operation of the storage's view, see KVStorage.WithContext:
+// the operation started at start, it got the storage's lock at locked and released it at unlocked.
+// It's called outside of the storage's lock.
+type Tracer func(ctx context.Context, op string, start, locked, unlocked time.Time)
+
+// WithTracer sets the tracer of the operations, the operations of the storage itself are not traced,
+// only the ones of its views
+func WithTracer(tracer Tracer) Option {
+	return func(kv *KVStorage) {
+		kv.tracer = tracer
+	}
+}
@@ -0,0 +1,135 @@
+package kvstorage
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/proway2/kvserver/element"
+)
+
+// SAdd adds the members to the set, the set is created if the key is not in the storage.
+// The whole set expires at once, any change starts its lifetime over.
+// It returns the number of the members which are new.
+func (kv *KVStorage) SAdd(key string, members ...string) (int, error) {
+	if !kv.initialized || len(key) == 0 || len(members) == 0 {
+		return 0, errors.New("sadd: Storage is not initialized, key is empty or no members provided")
+	}
+	defer kv.lock("SAdd")()
+	now := kv.clock.Now()
+	elem, found, err := kv.writable(key, element.Set, now)
+	if err != nil {
+		return 0, err
+	}
+	// the same member may be given more than once
+	added := make(map[string]struct{}, len(members))
+	var size uint64
+	for _, member := range members {
+		if found {
+			if _, exists := elem.Members[member]; exists {
+				continue
+			}
+		}
+		if _, exists := added[member]; !exists {
+			added[member] = struct{}{}
+			size += uint64(len(member))
+		}
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	if !found {
+		size += uint64(len(key))
+	}
+	if !kv.fits(0, size) {
+		return 0, ErrNoMemory
+	}
+	if found {
+		kv.updated(key, elem)
+	} else {
+		elem = kv.create(key, element.Set, now)
+		elem.Members = make(map[string]struct{}, len(added))
+		size -= uint64(len(key)) // already counted by create
+	}
+	for member := range added {
+		elem.Members[member] = struct{}{}
+	}
+	kv.resize(elem, 0, size)
+	return len(added), nil
+}
+
+// SRem removes the members from the set and returns the number of removed members.
+// The set is removed from the storage along with its last member.
+func (kv *KVStorage) SRem(key string, members ...string) (int, error) {
+	if !kv.initialized || len(key) == 0 {
+		return 0, errors.New("srem: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("SRem")()
+	elem, found, err := kv.readable(key, element.Set, kv.clock.Now())
+	if err != nil || !found {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if _, ok := elem.Members[member]; ok {
+			delete(elem.Members, member)
+			kv.resize(elem, uint64(len(member)), 0)
+			removed++
+		}
+	}
+	switch {
+	case len(elem.Members) == 0:
+		kv.purgeElement(key)
+	case removed > 0:
+		kv.updated(key, elem)
+	}
+	return removed, nil
+}
+
+// SIsMember reports whether the member is in the set, missing set has no members.
+func (kv *KVStorage) SIsMember(key, member string) (bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return false, errors.New("sismember: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("SIsMember")()
+	elem, found, err := kv.readable(key, element.Set, kv.clock.Now())
+	if err != nil || !found {
+		return false, err
+	}
+	kv.accessed(elem)
+	_, ok := elem.Members[member]
+	return ok, nil
+}
+
+// SMembers returns all members of the set in lexicographical order.
+// The second value reports whether the key is in the storage.
+func (kv *KVStorage) SMembers(key string) ([]string, bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return nil, false, errors.New("smembers: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("SMembers")()
+	elem, found, err := kv.readable(key, element.Set, kv.clock.Now())
+	if err != nil || !found {
+		return nil, false, err
+	}
+	kv.accessed(elem)
+	members := make([]string, 0, len(elem.Members))
+	for member := range elem.Members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, true, nil
+}
+
+// SCard returns the number of members of the set, missing set has no members.
+func (kv *KVStorage) SCard(key string) (int, error) {
+	if !kv.initialized || len(key) == 0 {
+		return 0, errors.New("scard: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("SCard")()
+	elem, found, err := kv.readable(key, element.Set, kv.clock.Now())
+	if err != nil || !found {
+		return 0, err
+	}
+	kv.accessed(elem)
+	return len(elem.Members), nil
+}
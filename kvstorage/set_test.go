@@ -0,0 +1,111 @@
+package kvstorage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKVStorage_Set_members(t *testing.T) {
+	storage := NewStorage(WithMaxMemory(64))
+	check(storage.Set("string", KEYVALUE), t)
+
+	tests := []struct {
+		name        string
+		op          func() (interface{}, error)
+		want        interface{}
+		wantErr     error
+		wantMembers []string // members of the set after the operation, nil if there is no set
+	}{
+		{
+			name:        "Adding the members creates the set",
+			op:          func() (interface{}, error) { return storage.SAdd("set", "b", "a", "b") },
+			want:        2,
+			wantMembers: []string{"a", "b"},
+		},
+		{
+			name:        "Adding existing member",
+			op:          func() (interface{}, error) { return storage.SAdd("set", "a", "c") },
+			want:        1,
+			wantMembers: []string{"a", "b", "c"},
+		},
+		{
+			name:        "Checking the member",
+			op:          func() (interface{}, error) { return storage.SIsMember("set", "c") },
+			want:        true,
+			wantMembers: []string{"a", "b", "c"},
+		},
+		{
+			name:        "Checking missing member",
+			op:          func() (interface{}, error) { return storage.SIsMember("set", "z") },
+			want:        false,
+			wantMembers: []string{"a", "b", "c"},
+		},
+		{
+			name:        "Cardinality",
+			op:          func() (interface{}, error) { return storage.SCard("set") },
+			want:        3,
+			wantMembers: []string{"a", "b", "c"},
+		},
+		{
+			name:        "Cardinality of missing set",
+			op:          func() (interface{}, error) { return storage.SCard("missing") },
+			want:        0,
+			wantMembers: []string{"a", "b", "c"},
+		},
+		{
+			name:        "Memory quota is exceeded",
+			op:          func() (interface{}, error) { return storage.SAdd("set", string(make([]byte, 64))) },
+			want:        0,
+			wantErr:     ErrNoMemory,
+			wantMembers: []string{"a", "b", "c"},
+		},
+		{
+			name:        "Set operation on the string",
+			op:          func() (interface{}, error) { return storage.SIsMember("string", "a") },
+			want:        false,
+			wantErr:     ErrWrongType,
+			wantMembers: []string{"a", "b", "c"},
+		},
+		{
+			name: "String operation on the set",
+			op: func() (interface{}, error) {
+				value, err := storage.Get("set")
+				return value == nil, err
+			},
+			want:        true,
+			wantErr:     ErrWrongType,
+			wantMembers: []string{"a", "b", "c"},
+		},
+		{
+			name:        "Removing the members",
+			op:          func() (interface{}, error) { return storage.SRem("set", "a", "z") },
+			want:        1,
+			wantMembers: []string{"b", "c"},
+		},
+		{
+			name:        "Removing the last members removes the set",
+			op:          func() (interface{}, error) { return storage.SRem("set", "b", "c") },
+			want:        2,
+			wantMembers: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if err != tt.wantErr {
+				t.Errorf("KVStorage error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KVStorage = %v, want %v", got, tt.want)
+			}
+			members, found, err := storage.SMembers("set")
+			if err != nil || found != (tt.wantMembers != nil) || !reflect.DeepEqual(members, tt.wantMembers) {
+				t.Errorf("KVStorage.SMembers() = %v, %v, %v, want %v", members, found, err, tt.wantMembers)
+			}
+		})
+	}
+	// only the string is left in the storage
+	if memory, _ := storage.MemoryUsage(); memory != uint64(len("string"+KEYVALUE)) {
+		t.Errorf("KVStorage.MemoryUsage() = %v, want %v", memory, len("string"+KEYVALUE))
+	}
+}
@@ -0,0 +1,187 @@
+package kvstorage
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/proway2/kvserver/element"
+	"github.com/proway2/kvserver/skiplist"
+)
+
+// ErrNotFloat - the score is not a number or the result of the operation is not a number
+var ErrNotFloat = errors.New("score is not a valid float")
+
+// scoreSize - memory taken by the score of the sorted set's member, bytes
+const scoreSize = 8
+
+// ScoredMember - the member of the sorted set along with its score
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// ZAdd adds the member to the sorted set or updates its score, the sorted set is created if the key is not in the storage.
+// The whole sorted set expires at once, any change starts its lifetime over.
+// It returns true if the member is new.
+func (kv *KVStorage) ZAdd(key, member string, score float64) (bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return false, errors.New("zadd: Storage is not initialized or key is empty")
+	}
+	if math.IsNaN(score) {
+		return false, ErrNotFloat
+	}
+	defer kv.lock("ZAdd")()
+	now := kv.clock.Now()
+	elem, found, err := kv.writable(key, element.SortedSet, now)
+	if err != nil {
+		return false, err
+	}
+	exists := false
+	if found {
+		_, exists = elem.Scores[member]
+	}
+	if err := kv.setScore(key, member, score, elem, now); err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+// ZIncrBy adds incr to the score of the sorted set's member and returns the new score.
+// Missing member or sorted set is created with the score of incr.
+func (kv *KVStorage) ZIncrBy(key, member string, incr float64) (float64, error) {
+	if !kv.initialized || len(key) == 0 {
+		return 0, errors.New("zincrby: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("ZIncrBy")()
+	now := kv.clock.Now()
+	elem, found, err := kv.writable(key, element.SortedSet, now)
+	if err != nil {
+		return 0, err
+	}
+	var score float64
+	if found {
+		score = elem.Scores[member]
+	}
+	score += incr
+	// i.e. +Inf and -Inf are added
+	if math.IsNaN(score) {
+		return 0, ErrNotFloat
+	}
+	if err := kv.setScore(key, member, score, elem, now); err != nil {
+		return 0, err
+	}
+	return score, nil
+}
+
+// ZRem removes the members from the sorted set and returns the number of removed members.
+// The sorted set is removed from the storage along with its last member.
+func (kv *KVStorage) ZRem(key string, members ...string) (int, error) {
+	if !kv.initialized || len(key) == 0 {
+		return 0, errors.New("zrem: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("ZRem")()
+	elem, found, err := kv.readable(key, element.SortedSet, kv.clock.Now())
+	if err != nil || !found {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if score, ok := elem.Scores[member]; ok {
+			elem.Ranking.Delete(member, score)
+			delete(elem.Scores, member)
+			kv.resize(elem, uint64(len(member)+scoreSize), 0)
+			removed++
+		}
+	}
+	switch {
+	case len(elem.Scores) == 0:
+		kv.purgeElement(key)
+	case removed > 0:
+		kv.updated(key, elem)
+	}
+	return removed, nil
+}
+
+// ZRange returns the members of the sorted set from start to stop rank, both inclusive.
+// Members are ordered by their scores, the ones with the same score are in lexicographical order.
+// Ranks are the same as indexes of LRange, i.e. -1 is the member with the highest score.
+// The second value reports whether the key is in the storage.
+func (kv *KVStorage) ZRange(key string, start, stop int) ([]ScoredMember, bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return nil, false, errors.New("zrange: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("ZRange")()
+	elem, found, err := kv.readable(key, element.SortedSet, kv.clock.Now())
+	if err != nil || !found {
+		return nil, false, err
+	}
+	kv.accessed(elem)
+	start, stop, ok := listRange(len(elem.Scores), start, stop)
+	if !ok {
+		return []ScoredMember{}, true, nil
+	}
+	members := make([]ScoredMember, 0, stop-start+1)
+	for n := elem.Ranking.ByRank(start); n != nil && len(members) < cap(members); n = n.Next() {
+		members = append(members, ScoredMember{Member: n.Member(), Score: n.Score()})
+	}
+	return members, true, nil
+}
+
+// ZRangeByScore returns the members of the sorted set with the scores from min to max, both inclusive.
+// Members are ordered the same as by ZRange.
+// The second value reports whether the key is in the storage.
+func (kv *KVStorage) ZRangeByScore(key string, min, max float64) ([]ScoredMember, bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return nil, false, errors.New("zrangebyscore: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("ZRangeByScore")()
+	elem, found, err := kv.readable(key, element.SortedSet, kv.clock.Now())
+	if err != nil || !found {
+		return nil, false, err
+	}
+	kv.accessed(elem)
+	members := []ScoredMember{}
+	for n := elem.Ranking.First(min); n != nil && n.Score() <= max; n = n.Next() {
+		members = append(members, ScoredMember{Member: n.Member(), Score: n.Score()})
+	}
+	return members, true, nil
+}
+
+// setScore sets the score of the sorted set's member, the sorted set is created if elem is nil.
+// MUST be called within critical section.
+func (kv *KVStorage) setScore(key, member string, score float64, elem *element.Element, now time.Time) error {
+	exists := false
+	var size uint64
+	var old float64
+	if elem != nil {
+		old, exists = elem.Scores[member]
+	} else {
+		size = uint64(len(key))
+	}
+	if !exists {
+		size += uint64(len(member) + scoreSize)
+	}
+	if !kv.fits(0, size) {
+		return ErrNoMemory
+	}
+	if elem == nil {
+		elem = kv.create(key, element.SortedSet, now)
+		elem.Scores = make(map[string]float64)
+		elem.Ranking = skiplist.New()
+		size -= uint64(len(key)) // already counted by create
+	} else {
+		kv.updated(key, elem)
+	}
+	switch {
+	case !exists:
+		elem.Ranking.Insert(member, score)
+	case old != score:
+		// the member is moved to its new rank
+		elem.Ranking.Delete(member, old)
+		elem.Ranking.Insert(member, score)
+	}
+	elem.Scores[member] = score
+	kv.resize(elem, 0, size)
+	return nil
+}
@@ -0,0 +1,137 @@
+package kvstorage
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestKVStorage_SortedSet(t *testing.T) {
+	storage := NewStorage(WithMaxMemory(64))
+	check(storage.Set("string", KEYVALUE), t)
+
+	tests := []struct {
+		name        string
+		op          func() (interface{}, error)
+		want        interface{}
+		wantErr     error
+		wantMembers []ScoredMember // members of the sorted set after the operation, nil if there is no sorted set
+	}{
+		{
+			name:        "Adding the member creates the sorted set",
+			op:          func() (interface{}, error) { return storage.ZAdd("board", "bob", 20) },
+			want:        true,
+			wantMembers: []ScoredMember{{"bob", 20}},
+		},
+		{
+			name:        "Adding the member with the same score",
+			op:          func() (interface{}, error) { return storage.ZAdd("board", "amy", 20) },
+			want:        true,
+			wantMembers: []ScoredMember{{"amy", 20}, {"bob", 20}},
+		},
+		{
+			name:        "Updating the score",
+			op:          func() (interface{}, error) { return storage.ZAdd("board", "bob", 5) },
+			want:        false,
+			wantMembers: []ScoredMember{{"bob", 5}, {"amy", 20}},
+		},
+		{
+			name:        "Incrementing missing member",
+			op:          func() (interface{}, error) { return storage.ZIncrBy("board", "cat", 7.5) },
+			want:        7.5,
+			wantMembers: []ScoredMember{{"bob", 5}, {"cat", 7.5}, {"amy", 20}},
+		},
+		{
+			name:        "Incrementing the score",
+			op:          func() (interface{}, error) { return storage.ZIncrBy("board", "bob", 30) },
+			want:        35.0,
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"amy", 20}, {"bob", 35}},
+		},
+		{
+			name: "Range by rank",
+			op: func() (interface{}, error) {
+				members, _, err := storage.ZRange("board", -2, -1)
+				return members, err
+			},
+			want:        []ScoredMember{{"amy", 20}, {"bob", 35}},
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"amy", 20}, {"bob", 35}},
+		},
+		{
+			name: "Range by score",
+			op: func() (interface{}, error) {
+				members, _, err := storage.ZRangeByScore("board", 7.5, 20)
+				return members, err
+			},
+			want:        []ScoredMember{{"cat", 7.5}, {"amy", 20}},
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"amy", 20}, {"bob", 35}},
+		},
+		{
+			name: "Empty range by score",
+			op: func() (interface{}, error) {
+				members, _, err := storage.ZRangeByScore("board", 100, math.Inf(1))
+				return members, err
+			},
+			want:        []ScoredMember{},
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"amy", 20}, {"bob", 35}},
+		},
+		{
+			name:        "Score is not a number",
+			op:          func() (interface{}, error) { return storage.ZAdd("board", "amy", math.NaN()) },
+			want:        false,
+			wantErr:     ErrNotFloat,
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"amy", 20}, {"bob", 35}},
+		},
+		{
+			name:        "Memory quota is exceeded",
+			op:          func() (interface{}, error) { return storage.ZAdd("board", string(make([]byte, 64)), 1) },
+			want:        false,
+			wantErr:     ErrNoMemory,
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"amy", 20}, {"bob", 35}},
+		},
+		{
+			name:        "Sorted set operation on the string",
+			op:          func() (interface{}, error) { return storage.ZIncrBy("string", "amy", 1) },
+			want:        0.0,
+			wantErr:     ErrWrongType,
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"amy", 20}, {"bob", 35}},
+		},
+		{
+			name:        "Set operation on the sorted set",
+			op:          func() (interface{}, error) { return storage.SAdd("board", "amy") },
+			want:        0,
+			wantErr:     ErrWrongType,
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"amy", 20}, {"bob", 35}},
+		},
+		{
+			name:        "Removing the members",
+			op:          func() (interface{}, error) { return storage.ZRem("board", "amy", "dan") },
+			want:        1,
+			wantMembers: []ScoredMember{{"cat", 7.5}, {"bob", 35}},
+		},
+		{
+			name:        "Removing the last members removes the sorted set",
+			op:          func() (interface{}, error) { return storage.ZRem("board", "bob", "cat") },
+			want:        2,
+			wantMembers: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if err != tt.wantErr {
+				t.Errorf("KVStorage error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KVStorage = %v, want %v", got, tt.want)
+			}
+			members, found, err := storage.ZRange("board", 0, -1)
+			if err != nil || found != (tt.wantMembers != nil) || !reflect.DeepEqual(members, tt.wantMembers) {
+				t.Errorf("KVStorage.ZRange() = %v, %v, %v, want %v", members, found, err, tt.wantMembers)
+			}
+		})
+	}
+	// only the string is left in the storage
+	if memory, _ := storage.MemoryUsage(); memory != uint64(len("string"+KEYVALUE)) {
+		t.Errorf("KVStorage.MemoryUsage() = %v, want %v", memory, len("string"+KEYVALUE))
+	}
+}
@@ -2,55 +2,157 @@ package kvstorage
 
 import (
 	"container/list"
+	"context"
 	"errors"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/proway2/kvserver/clock"
 	"github.com/proway2/kvserver/element"
 )
 
+var (
+	// ErrNoMemory - the element does not fit into the storage's memory quota
+	ErrNoMemory = errors.New("set: memory quota is exceeded")
+	// ErrWrongType - the operation is applied to the element holding the wrong kind of value
+	ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+	// ErrKeyExists - the element is created only, but the key is in the storage already
+	ErrKeyExists = errors.New("set: key already exists")
+	// ErrKeyNotFound - the element is updated only, but the key is not in the storage
+	ErrKeyNotFound = errors.New("set: key is not found")
+)
+
+// SetMode - the condition the element is written on by Set
+type SetMode int
+
+const (
+	// Upsert - the element is either created or updated (default)
+	Upsert SetMode = iota
+	// IfAbsent - the element is created only, the same as Redis' NX
+	IfAbsent
+	// IfExists - the element is updated only, the same as Redis' XX
+	IfExists
+)
+
 // KVStorage - Структура с методами, описывающая хранилище
 type KVStorage struct {
-	kvstorage   map[string]*element.Element
-	mux         *sync.Mutex
-	queue       *list.List // LIFO - the oldest element is always at the front!!!
+	*store
+	// the operations of the view made by WithContext are traced as the children of the span in ctx
+	ctx         context.Context
 	initialized bool
 }
 
+// store - the state of the storage shared by its views, see WithContext
+type store struct {
+	kvstorage map[string]*element.Element
+	mux       *sync.Mutex
+	queue     *list.List   // LIFO - the oldest element is always at the front!!!
+	wheel     *timingWheel // elements with absolute expiration time
+	wheelTick time.Duration
+	clock     clock.Clock
+	revision  uint64 // incremented on every update, never goes back
+	mode      ExpirationMode
+	ttl       time.Duration // element's lifetime, zero if unknown
+	maxMemory uint64        // memory quota for keys and values, bytes, zero means no quota
+	memory    uint64        // memory taken by keys and values, bytes
+	// expired elements are removed on access instead of waiting for the cleaner
+	deleteOnRead bool
+	// clients waiting for the values to be pushed to the lists, see BLPop
+	waiters map[string]*list.List
+	// gets every change of the keys, see WithNotifier
+	notifier func(key string, version uint64)
+	// gets the keys purged by the cleaner, see WithPurgeNotifier
+	purgeNotifier func(key string)
+	// gets the timings of the operations of the views, see WithTracer
+	tracer Tracer
+}
+
 // NewStorage returns an initialized key-value storage
-func NewStorage() *KVStorage {
-	return &KVStorage{
-		kvstorage:   make(map[string]*element.Element),
-		mux:         &sync.Mutex{},
+func NewStorage(opts ...Option) *KVStorage {
+	kv := &KVStorage{
+		store: &store{
+			kvstorage: make(map[string]*element.Element),
+			mux:       &sync.Mutex{},
+			queue:     list.New(),
+			wheelTick: defaultWheelTick,
+			clock:     clock.Real{},
+		},
 		initialized: true,
-		queue:       list.New(),
 	}
+	for _, opt := range opts {
+		opt(kv)
+	}
+	kv.wheel = newTimingWheel(kv.kvstorage, kv.wheelTick, kv.clock.Now())
+	return kv
 }
 
-// Set adds new or updates existing element into the storage
-func (kv *KVStorage) Set(key, value string) error {
+// WithContext returns the view of the storage which operations are traced within ctx, see WithTracer.
+// The view shares the elements and the settings with the storage.
+func (kv *KVStorage) WithContext(ctx context.Context) *KVStorage {
+	return &KVStorage{store: kv.store, ctx: ctx, initialized: kv.initialized}
+}
+
+// Set adds new or updates existing element into the storage.
+// The optional mode makes the write conditional, it returns either ErrKeyExists or ErrKeyNotFound
+// if the condition is not met. Expired element is not in the storage for the condition.
+// The lock which is still held is not replaced, it returns ErrLocked.
+func (kv *KVStorage) Set(key, value string, mode ...SetMode) error {
 	if !kv.initialized || len(key) == 0 {
 		return errors.New("set: Storage is not initialized or key is empty")
 	}
-	kv.mux.Lock()
-	defer kv.mux.Unlock()
+	defer kv.lock("Set")()
 
+	now := kv.clock.Now()
+	elem, found := kv.kvstorage[key]
+	if err := kv.condition(mode, found && !kv.expired(elem, now)); err != nil {
+		return err
+	}
+	if found && kv.locked(elem, now) {
+		return ErrLocked
+	}
+	var freed uint64
+	if found {
+		// the element of any kind is replaced by the string
+		freed = elem.Size
+	}
+	if !kv.fits(freed, uint64(len(key)+len(value))) {
+		return ErrNoMemory
+	}
+	kv.set(key, value, now)
+	return nil
+}
+
+// set adds new or replaces existing element of any kind by the string.
+// The memory quota MUST be checked before.
+// MUST be called within critical section.
+func (kv *KVStorage) set(key, value string, now time.Time) {
+	created := now
+	size, freed := uint64(len(key)+len(value)), uint64(0)
 	// проверяем есть ли у нас такой ключ в карте
 	if elem, found := kv.kvstorage[key]; found {
+		freed = elem.Size
 		// для поддержания порядка очереди LIFO,
 		// надо удалить найденный элемент из очереди
-		// вместо него будет новый с таким же ключом
-		kv.queue.Remove(elem.QueueElement)
+		// вместо него будет новый с таким же ключом.
+		// Update also drops element's own expiration time, it expires by the storage's TTL again
+		if !kv.expired(elem, now) {
+			created = elem.Created
+		}
+		kv.detach(elem)
 	}
+	kv.memory = kv.memory - freed + size
+	kv.revision++
 	// in order to maintain LIFO new elements pushed back
-	elem := &element.Element{
+	kv.kvstorage[key] = &element.Element{
 		Val:          value,
-		Timestamp:    time.Now(),
+		Size:         size,
+		Timestamp:    now,
+		Created:      created,
+		Version:      kv.revision,
 		QueueElement: kv.queue.PushBack(key),
 	}
-	kv.kvstorage[key] = elem
-
-	return nil
+	kv.notify(key, kv.revision)
 }
 
 // Get returns value by it's key
@@ -58,23 +160,176 @@ func (kv *KVStorage) Get(key string) ([]byte, error) {
 	if !kv.initialized || len(key) == 0 {
 		return nil, errors.New("get: Storage is not initialized or key is empty")
 	}
-	kv.mux.Lock()
-	defer kv.mux.Unlock()
-	elem, ok := kv.kvstorage[key]
+	defer kv.lock("Get")()
+	elem, ok, err := kv.readable(key, element.String, kv.clock.Now())
+	if err != nil {
+		return nil, err
+	}
 	if ok {
+		kv.accessed(elem)
 		return []byte(elem.Val), nil
 	}
 	// element with the key is not found, but this is not an error
 	return nil, nil
 }
 
+// Lookup returns a copy of the element stored by the key.
+// The second value reports whether the key is in the storage.
+func (kv *KVStorage) Lookup(key string) (element.Element, bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return element.Element{}, false, errors.New("lookup: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("Lookup")()
+	elem, ok := kv.lookup(key, kv.clock.Now())
+	if !ok {
+		return element.Element{}, false, nil
+	}
+	kv.accessed(elem)
+	// positions in the queue and the wheel are internal, they must not leak outside the storage,
+	// the same is for the values of other kinds than string which are changed in place
+	found := *elem
+	found.Hash, found.List = nil, nil
+	found.Members, found.Scores, found.Ranking = nil, nil, nil
+	found.QueueElement = nil
+	found.WheelSlot, found.WheelElement = nil, nil
+	return found, true, nil
+}
+
+// Touch resets element's lifetime without changing its value.
+// Elements with absolute expiration time and persistent ones are left as is.
+// It returns false if the key is not in the storage and ErrLocked for the lock, see Renew.
+func (kv *KVStorage) Touch(key string) (bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return false, errors.New("touch: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("Touch")()
+	elem, ok := kv.lookup(key, kv.clock.Now())
+	if ok && elem.Kind == element.Lock {
+		return false, ErrLocked
+	}
+	if ok {
+		kv.refresh(elem)
+	}
+	return ok, nil
+}
+
+// ExpireAt sets absolute expiration time for the element, the storage's TTL is not applied to it anymore.
+// The element is removed right away if the time is in the past.
+// It returns false if the key is not in the storage and ErrLocked for the lock, see Renew.
+func (kv *KVStorage) ExpireAt(key string, expires time.Time) (bool, error) {
+	if !kv.initialized || len(key) == 0 || expires.IsZero() {
+		return false, errors.New("expireat: Storage is not initialized, key is empty or no time provided")
+	}
+	defer kv.lock("ExpireAt")()
+	now := kv.clock.Now()
+	elem, ok := kv.lookup(key, now)
+	if !ok {
+		return false, nil
+	}
+	if elem.Kind == element.Lock {
+		return false, ErrLocked
+	}
+	if !expires.After(now) {
+		kv.purgeElement(key)
+		return true, nil
+	}
+	kv.detach(elem)
+	elem.Expires = expires
+	kv.wheel.add(key, elem)
+	kv.changed(key, elem)
+	return true, nil
+}
+
+// Persist makes the element never expire.
+// It returns false if the key is not in the storage and ErrLocked for the lock, see Renew.
+func (kv *KVStorage) Persist(key string) (bool, error) {
+	if !kv.initialized || len(key) == 0 {
+		return false, errors.New("persist: Storage is not initialized or key is empty")
+	}
+	defer kv.lock("Persist")()
+	elem, ok := kv.lookup(key, kv.clock.Now())
+	if ok && elem.Kind == element.Lock {
+		return false, ErrLocked
+	}
+	if ok {
+		// the element is in neither queue nor timing wheel, so the cleaner never sees it
+		kv.detach(elem)
+		elem.Persistent = true
+		kv.changed(key, elem)
+	}
+	return ok, nil
+}
+
+// Keys returns all keys in the storage, the oldest element goes first
+func (kv *KVStorage) Keys() ([]string, error) {
+	if !kv.initialized {
+		return nil, errors.New("keys: Storage is not initialized")
+	}
+	defer kv.lock("Keys")()
+	now := kv.clock.Now()
+	keys := make([]string, 0, len(kv.kvstorage))
+	for key, elem := range kv.kvstorage {
+		if !kv.expired(elem, now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ti, tj := kv.kvstorage[keys[i]].Timestamp, kv.kvstorage[keys[j]].Timestamp
+		if ti.Equal(tj) {
+			return keys[i] < keys[j]
+		}
+		return ti.Before(tj)
+	})
+	return keys, nil
+}
+
+// MemoryUsage returns the memory taken by keys and values, bytes
+func (kv *KVStorage) MemoryUsage() (uint64, error) {
+	if !kv.initialized {
+		return 0, errors.New("memoryusage: Storage is not initialized")
+	}
+	defer kv.lock("MemoryUsage")()
+	return kv.memory, nil
+}
+
+// TTL returns element's lifetime, zero if unknown, see WithTTL
+func (kv *KVStorage) TTL() time.Duration {
+	if !kv.initialized {
+		return 0
+	}
+	defer kv.lock("TTL")()
+	return kv.ttl
+}
+
+// SetTTL changes element's lifetime at runtime, see WithTTL. The lifetime of the elements in the storage
+// is counted by the new TTL right away, i.e. shorter TTL expires the old elements at once.
+func (kv *KVStorage) SetTTL(ttl time.Duration) error {
+	if !kv.initialized || ttl <= 0 {
+		return errors.New("setttl: Storage is not initialized or TTL = 0")
+	}
+	defer kv.lock("SetTTL")()
+	kv.ttl = ttl
+	return nil
+}
+
+// SetMaxMemory changes the memory quota at runtime, see WithMaxMemory.
+// The elements which are in the storage already are kept even if they don't fit into the new quota,
+// the writes fail with ErrNoMemory until enough memory is freed.
+func (kv *KVStorage) SetMaxMemory(bytes uint64) error {
+	if !kv.initialized {
+		return errors.New("setmaxmemory: Storage is not initialized")
+	}
+	defer kv.lock("SetMaxMemory")()
+	kv.maxMemory = bytes
+	return nil
+}
+
 // OldestElementTime - получить метку времени старейшего элемента
 func (kv *KVStorage) OldestElementTime() (time.Time, error) {
 	if !kv.initialized {
 		return time.Time{}, errors.New("oldestelementtime: Storage is not initialized")
 	}
-	kv.mux.Lock()
-	defer kv.mux.Unlock()
+	defer kv.lock("OldestElementTime")()
 
 	oldestElemInQueue := kv.queue.Front()
 	if oldestElemInQueue == nil {
@@ -90,15 +345,20 @@ func (kv *KVStorage) OldestElementTime() (time.Time, error) {
 	panic("element is in the queue, but not in the map")
 }
 
-// Delete removes element from storage by its key
+// Delete removes element from storage by its key.
+// The lock which is still held is not removed, it returns ErrLocked, see Release.
 func (kv *KVStorage) Delete(key string) (bool, error) {
 	if !kv.initialized || len(key) == 0 {
 		return false, errors.New("delete: Storage is not initialized or key is empty")
 	}
-	kv.mux.Lock()
-	defer kv.mux.Unlock()
-	_, ok := kv.kvstorage[key]
+	defer kv.lock("Delete")()
+	elem, ok := kv.kvstorage[key]
+	if ok && kv.locked(elem, kv.clock.Now()) {
+		return false, ErrLocked
+	}
 	if ok {
+		// expired element is removed anyway, but for the caller it has been already gone
+		ok = !kv.expired(elem, kv.clock.Now())
 		kv.purgeElement(key)
 	}
 	return ok, nil
@@ -109,8 +369,7 @@ func (kv *KVStorage) DeleteFrontIfOlder(ctxTime time.Time) (bool, error) {
 	if !kv.initialized {
 		return false, errors.New("deletefrontifolder: Storage is not initialized")
 	}
-	kv.mux.Lock()
-	defer kv.mux.Unlock()
+	defer kv.lock("DeleteFrontIfOlder")()
 
 	// first need to check if there is something in the queue
 	oldestElemInQueue := kv.queue.Front()
@@ -131,12 +390,282 @@ func (kv *KVStorage) DeleteFrontIfOlder(ctxTime time.Time) (bool, error) {
 	return false, nil
 }
 
+// DeleteFrontOlder removes front elements older than ctxTime.
+// The work is bounded by limit elements and budget time (zero budget means no time limit),
+// so the lock is not held for too long. It returns the number of removed elements
+// and whether there are more elements to remove, so the caller may repeat.
+func (kv *KVStorage) DeleteFrontOlder(ctxTime time.Time, limit int, budget time.Duration) (int, bool, error) {
+	if !kv.initialized || limit < 1 {
+		return 0, false, errors.New("deletefrontolder: Storage is not initialized or limit < 1")
+	}
+	unlock := kv.lock("DeleteFrontOlder")
+	b := newBatch(limit, budget)
+	var purged []string
+	for b.next() && kv.frontOlder(ctxTime) {
+		purged = kv.purgeExpired(kv.queue.Front().Value.(string), purged)
+		b.done++
+	}
+	more := kv.frontOlder(ctxTime)
+	unlock()
+	kv.notifyPurged(purged)
+	return b.done, more, nil
+}
+
+// frontOlder reports whether the front element of the queue is older than ctxTime.
+// MUST be called within critical section.
+func (kv *KVStorage) frontOlder(ctxTime time.Time) bool {
+	front := kv.queue.Front()
+	return front != nil && kv.kvstorage[front.Value.(string)].Timestamp.Before(ctxTime)
+}
+
+// lookup returns the element by its key unless it has expired by now.
+// Expired element is removed if the storage deletes on read, otherwise it's left for the cleaner.
+// MUST be called within critical section.
+func (kv *KVStorage) lookup(key string, now time.Time) (*element.Element, bool) {
+	elem, ok := kv.kvstorage[key]
+	if !ok {
+		return nil, false
+	}
+	if kv.expired(elem, now) {
+		if kv.deleteOnRead {
+			kv.purgeElement(key)
+		}
+		return nil, false
+	}
+	return elem, true
+}
+
+// expired reports whether the element has outlived either its absolute expiration time or the storage's TTL.
+// The rules are the same the cleaner uses to remove the elements.
+func (kv *KVStorage) expired(elem *element.Element, now time.Time) bool {
+	if !elem.Expires.IsZero() {
+		return !elem.Expires.After(now)
+	}
+	if elem.QueueElement == nil || kv.ttl == 0 {
+		// persistent element or lifetime is unknown
+		return false
+	}
+	return elem.Timestamp.Before(now.Add(-kv.ttl))
+}
+
+// accessed extends element's lifetime on read if the storage is in sliding expiration mode.
+// MUST be called within critical section.
+func (kv *KVStorage) accessed(elem *element.Element) {
+	if kv.mode == ExpireAfterAccess {
+		kv.refresh(elem)
+	}
+}
+
+// refresh resets element's lifetime, the element becomes the youngest one in the queue.
+// Elements with absolute expiration time and persistent ones are not affected.
+// MUST be called within critical section.
+func (kv *KVStorage) refresh(elem *element.Element) {
+	if elem.QueueElement == nil {
+		return
+	}
+	elem.Timestamp = kv.clock.Now()
+	kv.queue.MoveToBack(elem.QueueElement)
+}
+
+// EarliestExpiration returns the time the elements with absolute expiration time must be checked at,
+// none of them expires earlier
+func (kv *KVStorage) EarliestExpiration() (time.Time, error) {
+	if !kv.initialized {
+		return time.Time{}, errors.New("earliestexpiration: Storage is not initialized")
+	}
+	defer kv.lock("EarliestExpiration")()
+	expires, ok := kv.wheel.nextExpiration()
+	if !ok {
+		return time.Time{}, errors.New("earliestexpiration: Element is not found in storage")
+	}
+	return expires, nil
+}
+
+// DeleteExpired removes elements with absolute expiration time expired by ctxTime.
+// The work is bounded by limit elements and budget time (zero budget means no time limit),
+// so the lock is not held for too long. It returns the number of removed elements
+// and whether there are more expired elements, so the caller may repeat.
+func (kv *KVStorage) DeleteExpired(ctxTime time.Time, limit int, budget time.Duration) (int, bool, error) {
+	if !kv.initialized || limit < 1 {
+		return 0, false, errors.New("deleteexpired: Storage is not initialized or limit < 1")
+	}
+	unlock := kv.lock("DeleteExpired")
+	b := newBatch(limit, budget)
+	var purged []string
+	for b.next() && kv.expiredPending(ctxTime) {
+		purged = kv.purgeExpired(kv.wheel.due.Front().Value.(string), purged)
+		b.done++
+	}
+	more := kv.expiredPending(ctxTime)
+	unlock()
+	kv.notifyPurged(purged)
+	return b.done, more, nil
+}
+
+// purgeExpired removes the expired element on behalf of the cleaner, its key is appended to purged
+// if there is the notifier of WithPurgeNotifier.
+// MUST be called within critical section.
+func (kv *KVStorage) purgeExpired(key string, purged []string) []string {
+	kv.purgeElement(key)
+	if kv.purgeNotifier != nil {
+		purged = append(purged, key)
+	}
+	return purged
+}
+
+// notifyPurged tells the notifier of WithPurgeNotifier the keys purged by the cleaner.
+// MUST be called outside of critical section.
+func (kv *KVStorage) notifyPurged(purged []string) {
+	for _, key := range purged {
+		kv.purgeNotifier(key)
+	}
+}
+
+// expiredPending reports whether there are elements expired by ctxTime in the wheel's due list.
+// MUST be called within critical section.
+func (kv *KVStorage) expiredPending(ctxTime time.Time) bool {
+	if kv.wheel.due.Len() == 0 {
+		kv.wheel.advance(ctxTime)
+	}
+	return kv.wheel.due.Len() != 0
+}
+
+// detach removes the element from both the queue and the timing wheel, so it never expires.
+// MUST be called within critical section.
+func (kv *KVStorage) detach(elem *element.Element) {
+	if elem.QueueElement != nil {
+		kv.queue.Remove(elem.QueueElement)
+		elem.QueueElement = nil
+	}
+	if elem.WheelSlot != nil {
+		kv.wheel.remove(elem)
+		elem.Expires = time.Time{}
+	}
+	elem.Persistent = false
+}
+
 func (kv *KVStorage) purgeElement(key string) {
 	// THIS IS NOT THREAD SAFE FUNCTION !!!
 	// INTERNAL USE ONLY !!!
 	// CALL THIS FUNCTION WITHIN CRITICAL SECTION
 	// WHEN THREAD IS LOCKED !!!
 	// NOT INTENDED FOR SEPARATE USE !!!
-	kv.queue.Remove(kv.kvstorage[key].QueueElement)
+	elem := kv.kvstorage[key]
+	kv.detach(elem)
+	kv.memory -= elem.Size
 	delete(kv.kvstorage, key)
+	kv.notify(key, 0)
+}
+
+// condition checks the mode of Set against the key's presence in the storage.
+func (kv *KVStorage) condition(mode []SetMode, exists bool) error {
+	for _, m := range mode {
+		switch {
+		case m == IfAbsent && exists:
+			return ErrKeyExists
+		case m == IfExists && !exists:
+			return ErrKeyNotFound
+		}
+	}
+	return nil
+}
+
+// fits reports whether the memory quota allows to replace freed bytes by size bytes.
+// Only keys and values are counted, the storage's own overhead is not.
+// MUST be called within critical section.
+func (kv *KVStorage) fits(freed, size uint64) bool {
+	return kv.maxMemory == 0 || kv.memory-freed+size <= kv.maxMemory
+}
+
+// resize replaces freed bytes of the element by size bytes.
+// MUST be called within critical section.
+func (kv *KVStorage) resize(elem *element.Element, freed, size uint64) {
+	elem.Size = elem.Size - freed + size
+	kv.memory = kv.memory - freed + size
+}
+
+// readable returns the element of the kind unless it has expired by now, see lookup.
+// MUST be called within critical section.
+func (kv *KVStorage) readable(key string, kind element.Kind, now time.Time) (*element.Element, bool, error) {
+	elem, ok := kv.lookup(key, now)
+	if ok && elem.Kind != kind {
+		return nil, false, ErrWrongType
+	}
+	return elem, ok, nil
+}
+
+// writable returns the element of the kind to be changed in place.
+// Expired element is removed right away, it's going to be replaced by the new one.
+// MUST be called within critical section.
+func (kv *KVStorage) writable(key string, kind element.Kind, now time.Time) (*element.Element, bool, error) {
+	elem, ok := kv.kvstorage[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if kv.expired(elem, now) {
+		kv.purgeElement(key)
+		return nil, false, nil
+	}
+	if elem.Kind != kind {
+		return nil, false, ErrWrongType
+	}
+	return elem, true, nil
+}
+
+// create adds new empty element of the kind, it expires by the storage's TTL.
+// The memory taken by the key MUST be checked against the quota before.
+// MUST be called within critical section.
+func (kv *KVStorage) create(key string, kind element.Kind, now time.Time) *element.Element {
+	kv.revision++
+	elem := &element.Element{
+		Kind:         kind,
+		Timestamp:    now,
+		Created:      now,
+		Version:      kv.revision,
+		QueueElement: kv.queue.PushBack(key),
+	}
+	kv.resize(elem, 0, uint64(len(key)))
+	kv.kvstorage[key] = elem
+	kv.notify(key, elem.Version)
+	return elem
+}
+
+// updated marks the element changed in place as the new version, its lifetime starts over.
+// MUST be called within critical section.
+func (kv *KVStorage) updated(key string, elem *element.Element) {
+	kv.changed(key, elem)
+	kv.refresh(elem)
+}
+
+// changed marks the element as the new version, so the clients watching it see the change.
+// MUST be called within critical section.
+func (kv *KVStorage) changed(key string, elem *element.Element) {
+	kv.revision++
+	elem.Version = kv.revision
+	kv.notify(key, elem.Version)
+}
+
+// notify tells the notifier the key has the new version, zero version means the key is removed.
+// MUST be called within critical section.
+func (kv *KVStorage) notify(key string, version uint64) {
+	if kv.notifier != nil {
+		kv.notifier(key, version)
+	}
+}
+
+// lock locks the storage for the operation and returns the function unlocking it.
+// The operation of the view made by WithContext is traced, see WithTracer.
+func (kv *KVStorage) lock(op string) func() {
+	if kv.tracer == nil || kv.ctx == nil {
+		kv.mux.Lock()
+		return kv.mux.Unlock
+	}
+	// the lock is measured by the real clock, not by the storage's one
+	start := time.Now()
+	kv.mux.Lock()
+	locked := time.Now()
+	return func() {
+		kv.mux.Unlock()
+		kv.tracer(kv.ctx, op, start, locked, time.Now())
+	}
 }
@@ -2,11 +2,15 @@ package kvstorage
 
 import (
 	"container/list"
+	"context"
 	"reflect"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/proway2/kvserver/clock"
+	"github.com/proway2/kvserver/clock/clocktest"
 	"github.com/proway2/kvserver/element"
 )
 
@@ -21,16 +25,26 @@ func TestNewStorage(t *testing.T) {
 		{
 			name: "Normal run",
 			want: &KVStorage{
-				kvstorage:   make(map[string]*element.Element),
-				mux:         &sync.Mutex{},
+				store: &store{
+					kvstorage: make(map[string]*element.Element),
+					mux:       &sync.Mutex{},
+					queue:     list.New(),
+					wheelTick: defaultWheelTick,
+					clock:     clock.Real{},
+				},
 				initialized: true,
-				queue:       list.New(),
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewStorage(); !reflect.DeepEqual(got, tt.want) {
+			got := NewStorage()
+			// the wheel depends on the current time, it's checked separately
+			if got.wheel == nil || got.wheel.tick != defaultWheelTick || got.wheel.count != 0 {
+				t.Errorf("NewStorage() wheel = %v", got.wheel)
+			}
+			got.wheel = nil
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewStorage() = %v, want %v", got, tt.want)
 			}
 		})
@@ -94,6 +108,89 @@ func TestKVStorage_Set(t *testing.T) {
 	}
 }
 
+func TestKVStorage_Set_version(t *testing.T) {
+	storage := NewStorage()
+	check(storage.Set("key1", KEYVALUE), t)
+	created := storage.kvstorage["key1"].Created
+	check(storage.Set("key2", KEYVALUE), t)
+	check(storage.Set("key1", "new "+KEYVALUE), t)
+
+	elem := storage.kvstorage["key1"]
+	if elem.Version != 3 {
+		t.Errorf("KVStorage.Set() version = %v, want %v", elem.Version, 3)
+	}
+	if !elem.Created.Equal(created) || elem.Timestamp.Before(created) {
+		t.Errorf("KVStorage.Set() created = %v, updated = %v, want created %v", elem.Created, elem.Timestamp, created)
+	}
+}
+
+func TestKVStorage_Set_mode(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	storage := NewStorage(WithTTL(time.Minute), WithClock(clk))
+	check(storage.Set("expired", KEYVALUE), t)
+	clk.Advance(2 * time.Minute)
+	check(storage.Set("key", KEYVALUE), t)
+
+	tests := []struct {
+		name      string
+		key       string
+		mode      SetMode
+		wantErr   error
+		wantValue string
+	}{
+		{name: "Creating existing key", key: "key", mode: IfAbsent, wantErr: ErrKeyExists, wantValue: KEYVALUE},
+		{name: "Updating existing key", key: "key", mode: IfExists, wantValue: "new"},
+		{name: "Updating missing key", key: "missing", mode: IfExists, wantErr: ErrKeyNotFound},
+		{name: "Creating missing key", key: "missing", mode: IfAbsent, wantValue: "new"},
+		{name: "Updating expired key", key: "expired", mode: IfExists, wantErr: ErrKeyNotFound},
+		{name: "Creating expired key", key: "expired", mode: IfAbsent, wantValue: "new"},
+		{name: "Upserting existing key", key: "key", mode: Upsert, wantValue: "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := storage.Set(tt.key, "new", tt.mode); err != tt.wantErr {
+				t.Errorf("KVStorage.Set() error = %v, want %v", err, tt.wantErr)
+			}
+			if value, _ := storage.Get(tt.key); string(value) != tt.wantValue {
+				t.Errorf("KVStorage.Get() = %q, want %q", value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestKVStorage_WithNotifier(t *testing.T) {
+	type change struct {
+		key     string
+		version uint64
+	}
+	var changes []change
+	clk := clocktest.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage := NewStorage(WithTTL(time.Minute), WithClock(clk), WithNotifier(func(key string, version uint64) {
+		changes = append(changes, change{key, version})
+	}))
+
+	check(storage.Set("key", KEYVALUE), t)
+	_, err := storage.HSet("hash", "field", KEYVALUE)
+	check(err, t)
+	_, err = storage.Get("key")
+	check(err, t)
+	_, err = storage.Persist("hash")
+	check(err, t)
+	_, err = storage.Delete("key")
+	check(err, t)
+	check(storage.Set("expiring", KEYVALUE), t)
+	clk.Advance(2 * time.Minute)
+	_, _, err = storage.DeleteFrontOlder(clk.Now().Add(-time.Minute), 10, 0)
+	check(err, t)
+
+	// reading is not a change, removed and expired keys have version 0
+	want := []change{{"key", 1}, {"hash", 2}, {"hash", 3}, {"key", 0}, {"expiring", 4}, {"expiring", 0}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("notified changes = %v, want %v", changes, want)
+	}
+}
+
 func TestKVStorage_Get(t *testing.T) {
 	// because we need to test the case when key-value pair already in the storage - one storage will be in use by all testcases.
 	goodStorage := NewStorage()
@@ -366,6 +463,869 @@ func TestKVStorage_DeleteFrontIfOlder(t *testing.T) {
 	}
 }
 
+func TestKVStorage_Lookup(t *testing.T) {
+	goodStorage := NewStorage()
+	err := goodStorage.Set(KEYNAME, KEYVALUE)
+	check(err, t)
+	elementTime := goodStorage.kvstorage[KEYNAME].Timestamp
+
+	badStorage := NewStorage()
+	badStorage.initialized = false
+
+	tests := []struct {
+		name      string
+		fields    *KVStorage
+		key       string
+		want      element.Element
+		wantFound bool
+		wantErr   bool
+	}{
+		{
+			name:    "Storage is not initialized",
+			fields:  badStorage,
+			key:     KEYNAME,
+			wantErr: true,
+		},
+		{
+			name:    "Empty key",
+			fields:  goodStorage,
+			key:     "",
+			wantErr: true,
+		},
+		{
+			name:      "Key is not in the storage",
+			fields:    goodStorage,
+			key:       KEYNAME + "xxx",
+			wantFound: false,
+		},
+		{
+			name:      "Key is in the storage",
+			fields:    goodStorage,
+			key:       KEYNAME,
+			want:      element.Element{Val: KEYVALUE, Size: uint64(len(KEYNAME + KEYVALUE)), Timestamp: elementTime, Created: elementTime, Version: 1},
+			wantFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found, err := tt.fields.Lookup(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KVStorage.Lookup() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if found != tt.wantFound {
+				t.Errorf("KVStorage.Lookup() found = %v, want %v", found, tt.wantFound)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KVStorage.Lookup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKVStorage_Keys(t *testing.T) {
+	goodStorage := NewStorage()
+	check(goodStorage.Set("key1", KEYVALUE), t)
+	check(goodStorage.Set("key2", KEYVALUE), t)
+	// updated element goes to the end of the queue
+	check(goodStorage.Set("key1", KEYVALUE), t)
+
+	badStorage := NewStorage()
+	badStorage.initialized = false
+
+	tests := []struct {
+		name    string
+		fields  *KVStorage
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "Storage is not initialized",
+			fields:  badStorage,
+			wantErr: true,
+		},
+		{
+			name:   "Empty storage",
+			fields: NewStorage(),
+			want:   []string{},
+		},
+		{
+			name:   "Oldest element goes first",
+			fields: goodStorage,
+			want:   []string{"key2", "key1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fields.Keys()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KVStorage.Keys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KVStorage.Keys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKVStorage_Touch(t *testing.T) {
+	goodStorage := NewStorage()
+	check(goodStorage.Set("key1", KEYVALUE), t)
+	check(goodStorage.Set("key2", KEYVALUE), t)
+
+	badStorage := NewStorage()
+	badStorage.initialized = false
+
+	tests := []struct {
+		name      string
+		fields    *KVStorage
+		key       string
+		want      bool
+		wantErr   bool
+		wantFront string // the oldest key after the touch
+	}{
+		{
+			name:    "Storage is not initialized",
+			fields:  badStorage,
+			key:     "key1",
+			wantErr: true,
+		},
+		{
+			name:    "Empty key",
+			fields:  goodStorage,
+			key:     "",
+			wantErr: true,
+		},
+		{
+			name:      "Key is not in the storage",
+			fields:    goodStorage,
+			key:       "key3",
+			want:      false,
+			wantFront: "key1",
+		},
+		{
+			name:      "The oldest key becomes the youngest",
+			fields:    goodStorage,
+			key:       "key1",
+			want:      true,
+			wantFront: "key2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fields.Touch(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KVStorage.Touch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("KVStorage.Touch() = %v, want %v", got, tt.want)
+			}
+			if front := tt.fields.queue.Front().Value.(string); front != tt.wantFront {
+				t.Errorf("KVStorage.Touch() front = %v, want %v", front, tt.wantFront)
+			}
+		})
+	}
+}
+
+func TestKVStorage_ExpireAfterAccess(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      ExpirationMode
+		wantFront string // the oldest key after reading key1
+	}{
+		{
+			name:      "Reads don't extend lifetime",
+			mode:      ExpireAfterWrite,
+			wantFront: "key1",
+		},
+		{
+			name:      "Reads extend lifetime",
+			mode:      ExpireAfterAccess,
+			wantFront: "key2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewStorage(WithExpirationMode(tt.mode))
+			check(storage.Set("key1", KEYVALUE), t)
+			check(storage.Set("key2", KEYVALUE), t)
+			written := storage.kvstorage["key1"].Timestamp
+			version := storage.kvstorage["key1"].Version
+
+			_, err := storage.Get("key1")
+			check(err, t)
+			if front := storage.queue.Front().Value.(string); front != tt.wantFront {
+				t.Errorf("KVStorage.Get() front = %v, want %v", front, tt.wantFront)
+			}
+			elem := storage.kvstorage["key1"]
+			if tt.mode == ExpireAfterWrite && !elem.Timestamp.Equal(written) {
+				t.Errorf("KVStorage.Get() timestamp = %v, want %v", elem.Timestamp, written)
+			}
+			if elem.Version != version {
+				t.Errorf("KVStorage.Get() version = %v, want %v", elem.Version, version)
+			}
+		})
+	}
+}
+
+func TestKVStorage_expiredOnRead(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		opts    []Option
+		prepare func(kv *KVStorage, t *testing.T) // runs right after the key is set
+		advance time.Duration
+		want    bool // whether the key is still served
+		wantLen int  // number of elements left in the storage after reading
+	}{
+		{
+			name:    "TTL is not exceeded",
+			opts:    []Option{WithTTL(time.Minute)},
+			advance: time.Minute,
+			want:    true,
+			wantLen: 1,
+		},
+		{
+			name:    "TTL is exceeded",
+			opts:    []Option{WithTTL(time.Minute)},
+			advance: time.Minute + time.Nanosecond,
+			want:    false,
+			wantLen: 1,
+		},
+		{
+			name:    "TTL is exceeded, deleted on read",
+			opts:    []Option{WithTTL(time.Minute), WithDeleteOnRead()},
+			advance: time.Minute + time.Nanosecond,
+			want:    false,
+			wantLen: 0,
+		},
+		{
+			name:    "TTL is unknown",
+			advance: time.Hour,
+			want:    true,
+			wantLen: 1,
+		},
+		{
+			name: "Absolute expiration time is reached",
+			opts: []Option{WithTTL(time.Hour), WithDeleteOnRead()},
+			prepare: func(kv *KVStorage, t *testing.T) {
+				_, err := kv.ExpireAt(KEYNAME, start.Add(time.Second))
+				check(err, t)
+			},
+			advance: time.Second,
+			want:    false,
+			wantLen: 0,
+		},
+		{
+			name: "Persistent element",
+			opts: []Option{WithTTL(time.Minute), WithDeleteOnRead()},
+			prepare: func(kv *KVStorage, t *testing.T) {
+				_, err := kv.Persist(KEYNAME)
+				check(err, t)
+			},
+			advance: time.Hour,
+			want:    true,
+			wantLen: 1,
+		},
+	}
+	reads := []struct {
+		name string
+		read func(kv *KVStorage) (bool, error)
+	}{
+		{"Get", func(kv *KVStorage) (bool, error) {
+			val, err := kv.Get(KEYNAME)
+			return val != nil, err
+		}},
+		{"Lookup", func(kv *KVStorage) (bool, error) {
+			_, found, err := kv.Lookup(KEYNAME)
+			return found, err
+		}},
+		{"Touch", func(kv *KVStorage) (bool, error) { return kv.Touch(KEYNAME) }},
+		{"Persist", func(kv *KVStorage) (bool, error) { return kv.Persist(KEYNAME) }},
+		{"Keys", func(kv *KVStorage) (bool, error) {
+			keys, err := kv.Keys()
+			return len(keys) != 0, err
+		}},
+	}
+	for _, tt := range tests {
+		for _, rd := range reads {
+			t.Run(tt.name+"/"+rd.name, func(t *testing.T) {
+				clk := clocktest.NewFake(start)
+				storage := NewStorage(append(tt.opts, WithClock(clk))...)
+				check(storage.Set(KEYNAME, KEYVALUE), t)
+				if tt.prepare != nil {
+					tt.prepare(storage, t)
+				}
+				clk.Advance(tt.advance)
+				got, err := rd.read(storage)
+				check(err, t)
+				if got != tt.want {
+					t.Errorf("KVStorage.%v() found = %v, want %v", rd.name, got, tt.want)
+				}
+				// Keys never deletes, it only hides expired elements
+				if rd.name != "Keys" && len(storage.kvstorage) != tt.wantLen {
+					t.Errorf("KVStorage.%v() len = %v, want %v", rd.name, len(storage.kvstorage), tt.wantLen)
+				}
+			})
+		}
+	}
+}
+
+func TestKVStorage_Delete_expired(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	storage := NewStorage(WithTTL(time.Minute), WithClock(clk))
+	check(storage.Set(KEYNAME, KEYVALUE), t)
+	check(storage.Set("key2", KEYVALUE), t)
+	clk.Advance(time.Hour)
+
+	// expired element is removed, but it's reported as not found
+	got, err := storage.Delete(KEYNAME)
+	if err != nil || got {
+		t.Errorf("KVStorage.Delete() = %v, %v, want false, nil", got, err)
+	}
+	if _, ok := storage.kvstorage[KEYNAME]; ok {
+		t.Errorf("KVStorage.Delete() expired element is still in the storage")
+	}
+	// setting expired element creates a new one
+	check(storage.Set("key2", KEYVALUE), t)
+	if created := storage.kvstorage["key2"].Created; !created.Equal(clk.Now()) {
+		t.Errorf("KVStorage.Set() created = %v, want %v", created, clk.Now())
+	}
+}
+
+func TestKVStorage_MaxMemory(t *testing.T) {
+	storage := NewStorage(WithMaxMemory(10))
+	tests := []struct {
+		name       string
+		op         func() error
+		wantErr    error
+		wantMemory uint64
+	}{
+		{
+			name:       "Element fits into the quota",
+			op:         func() error { return storage.Set("k1", "value") },
+			wantMemory: 7,
+		},
+		{
+			name:       "Element does not fit into the quota",
+			op:         func() error { return storage.Set("k2", "value") },
+			wantErr:    ErrNoMemory,
+			wantMemory: 7,
+		},
+		{
+			name:       "Updated element does not fit into the quota",
+			op:         func() error { return storage.Set("k1", "new value") },
+			wantErr:    ErrNoMemory,
+			wantMemory: 7,
+		},
+		{
+			name:       "Update to the smaller value",
+			op:         func() error { return storage.Set("k1", "v") },
+			wantMemory: 3,
+		},
+		{
+			name:       "Another element fits now",
+			op:         func() error { return storage.Set("k2", "value") },
+			wantMemory: 10,
+		},
+		{
+			name: "Deleted element frees the memory",
+			op: func() error {
+				_, err := storage.Delete("k2")
+				return err
+			},
+			wantMemory: 3,
+		},
+		{
+			name:       "Quota is lowered below the memory taken, the element is kept",
+			op:         func() error { return storage.SetMaxMemory(2) },
+			wantMemory: 3,
+		},
+		{
+			name:       "Nothing fits into the lowered quota",
+			op:         func() error { return storage.Set("k", "v") },
+			wantErr:    ErrNoMemory,
+			wantMemory: 3,
+		},
+		{
+			name:       "No quota",
+			op:         func() error { return storage.SetMaxMemory(0) },
+			wantMemory: 3,
+		},
+		{
+			name:       "Element fits without quota",
+			op:         func() error { return storage.Set("k2", "value") },
+			wantMemory: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); err != tt.wantErr {
+				t.Errorf("KVStorage error = %v, want %v", err, tt.wantErr)
+			}
+			if got, err := storage.MemoryUsage(); err != nil || got != tt.wantMemory {
+				t.Errorf("KVStorage.MemoryUsage() = %v, %v, want %v", got, err, tt.wantMemory)
+			}
+		})
+	}
+}
+
+func TestKVStorage_SetTTL(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clocktest.NewFake(start)
+	storage := NewStorage(WithClock(clk), WithTTL(time.Minute))
+	if err := storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(30 * time.Second)
+	if err := storage.SetTTL(0); err == nil {
+		t.Error("KVStorage.SetTTL(0) error = nil, want error")
+	}
+	if _, found, _ := storage.Lookup("key"); !found {
+		t.Error("KVStorage.Lookup() found = false before TTL is changed, want true")
+	}
+	// the element outlived the new TTL already
+	if err := storage.SetTTL(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.TTL(); got != 10*time.Second {
+		t.Errorf("KVStorage.TTL() = %v, want %v", got, 10*time.Second)
+	}
+	if _, found, _ := storage.Lookup("key"); found {
+		t.Error("KVStorage.Lookup() found = true after TTL is shortened, want false")
+	}
+}
+
+func TestKVStorage_ExpireAt(t *testing.T) {
+	goodStorage := NewStorage()
+	check(goodStorage.Set("key1", KEYVALUE), t)
+	check(goodStorage.Set("key2", KEYVALUE), t)
+	check(goodStorage.Set("key3", KEYVALUE), t)
+
+	badStorage := NewStorage()
+	badStorage.initialized = false
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		fields    *KVStorage
+		key       string
+		expires   time.Time
+		want      bool
+		wantErr   bool
+		wantQueue int // number of elements in the queue
+		wantHeap  int // number of elements in the heap
+	}{
+		{
+			name:    "Storage is not initialized",
+			fields:  badStorage,
+			key:     "key1",
+			expires: now.Add(time.Hour),
+			wantErr: true,
+		},
+		{
+			name:    "No time provided",
+			fields:  goodStorage,
+			key:     "key1",
+			wantErr: true,
+		},
+		{
+			name:      "Key is not in the storage",
+			fields:    goodStorage,
+			key:       "key4",
+			expires:   now.Add(time.Hour),
+			want:      false,
+			wantQueue: 3,
+		},
+		{
+			name:      "Element is moved from the queue to the heap",
+			fields:    goodStorage,
+			key:       "key1",
+			expires:   now.Add(time.Hour),
+			want:      true,
+			wantQueue: 2,
+			wantHeap:  1,
+		},
+		{
+			name:      "Expiration time is updated",
+			fields:    goodStorage,
+			key:       "key1",
+			expires:   now.Add(2 * time.Hour),
+			want:      true,
+			wantQueue: 2,
+			wantHeap:  1,
+		},
+		{
+			name:      "Second element with expiration time",
+			fields:    goodStorage,
+			key:       "key2",
+			expires:   now.Add(time.Minute),
+			want:      true,
+			wantQueue: 1,
+			wantHeap:  2,
+		},
+		{
+			name:      "Time in the past removes the element",
+			fields:    goodStorage,
+			key:       "key3",
+			expires:   now.Add(-time.Minute),
+			want:      true,
+			wantQueue: 0,
+			wantHeap:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.fields.ExpireAt(tt.key, tt.expires)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KVStorage.ExpireAt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("KVStorage.ExpireAt() = %v, want %v", got, tt.want)
+			}
+			if tt.fields.queue.Len() != tt.wantQueue || tt.fields.wheel.count != tt.wantHeap {
+				t.Errorf("KVStorage.ExpireAt() queue = %v, heap = %v, want %v, %v",
+					tt.fields.queue.Len(), tt.fields.wheel.count, tt.wantQueue, tt.wantHeap)
+			}
+		})
+	}
+	// key2 expires first, higher levels of the wheel are cascaded earlier than the element expires
+	if got, _ := goodStorage.EarliestExpiration(); !got.After(now) || got.After(now.Add(time.Minute+defaultWheelTick)) {
+		t.Errorf("KVStorage.EarliestExpiration() = %v, want not after %v", got, now.Add(time.Minute))
+	}
+	// updating the value drops the expiration time
+	check(goodStorage.Set("key2", KEYVALUE), t)
+	if goodStorage.queue.Len() != 1 || goodStorage.wheel.count != 1 {
+		t.Errorf("KVStorage.Set() queue = %v, heap = %v, want 1, 1", goodStorage.queue.Len(), goodStorage.wheel.count)
+	}
+	if got, _ := goodStorage.EarliestExpiration(); got.After(now.Add(2*time.Hour + defaultWheelTick)) {
+		t.Errorf("KVStorage.EarliestExpiration() = %v, want not after %v", got, now.Add(2*time.Hour))
+	}
+}
+
+func TestKVStorage_Persist(t *testing.T) {
+	storage := NewStorage()
+	check(storage.Set("key1", KEYVALUE), t)
+	check(storage.Set("key2", KEYVALUE), t)
+	_, err := storage.ExpireAt("key2", time.Now().Add(time.Hour))
+	check(err, t)
+
+	for _, key := range []string{"key1", "key2"} {
+		got, err := storage.Persist(key)
+		if err != nil || !got {
+			t.Errorf("KVStorage.Persist(%v) = %v, %v, want true", key, got, err)
+		}
+	}
+	if got, _ := storage.Persist("key3"); got {
+		t.Errorf("KVStorage.Persist() = %v for missing key", got)
+	}
+	if storage.queue.Len() != 0 || storage.wheel.count != 0 {
+		t.Errorf("KVStorage.Persist() queue = %v, heap = %v, want 0, 0", storage.queue.Len(), storage.wheel.count)
+	}
+	// the cleaner has nothing to purge, but the elements are still there
+	if _, err := storage.OldestElementTime(); err == nil {
+		t.Errorf("KVStorage.OldestElementTime() error = nil for persistent elements")
+	}
+	if keys, _ := storage.Keys(); len(keys) != 2 {
+		t.Errorf("KVStorage.Keys() = %v, want 2 keys", keys)
+	}
+	elem, _, _ := storage.Lookup("key1")
+	if !elem.Persistent {
+		t.Errorf("KVStorage.Lookup() persistent = %v, want true", elem.Persistent)
+	}
+}
+
+func TestKVStorage_DeleteExpired(t *testing.T) {
+	now := time.Now()
+	goodStorage := NewStorage()
+	for _, key := range []string{"key1", "key2", "key3", "key4"} {
+		check(goodStorage.Set(key, KEYVALUE), t)
+	}
+	_, err := goodStorage.ExpireAt("key1", now.Add(2*time.Minute))
+	check(err, t)
+	_, err = goodStorage.ExpireAt("key2", now.Add(time.Minute))
+	check(err, t)
+	_, err = goodStorage.ExpireAt("key3", now.Add(time.Minute))
+	check(err, t)
+	_, err = goodStorage.ExpireAt("key4", now.Add(time.Hour))
+	check(err, t)
+
+	badStorage := NewStorage()
+	badStorage.initialized = false
+
+	tests := []struct {
+		name     string
+		fields   *KVStorage
+		ctxTime  time.Time
+		limit    int
+		want     int
+		wantMore bool
+		wantErr  bool
+		wantLen  int // number of elements in the storage
+	}{
+		{
+			name:    "Storage is not initialized",
+			fields:  badStorage,
+			limit:   10,
+			wantErr: true,
+		},
+		{
+			name:    "Wrong limit",
+			fields:  goodStorage,
+			ctxTime: now,
+			wantErr: true,
+			wantLen: 4,
+		},
+		{
+			name:    "Empty storage",
+			fields:  NewStorage(),
+			ctxTime: now,
+			limit:   10,
+		},
+		{
+			name:    "Nothing is expired",
+			fields:  goodStorage,
+			ctxTime: now,
+			limit:   10,
+			wantLen: 4,
+		},
+		{
+			name:     "Two elements expired, but the limit is one",
+			fields:   goodStorage,
+			ctxTime:  now.Add(90 * time.Second),
+			limit:    1,
+			want:     1,
+			wantMore: true,
+			wantLen:  3,
+		},
+		{
+			name:    "The rest of expired elements",
+			fields:  goodStorage,
+			ctxTime: now.Add(90 * time.Second),
+			limit:   10,
+			want:    1,
+			wantLen: 2,
+		},
+		{
+			name:    "The next element is expired",
+			fields:  goodStorage,
+			ctxTime: now.Add(3 * time.Minute),
+			limit:   10,
+			want:    1,
+			wantLen: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, more, err := tt.fields.DeleteExpired(tt.ctxTime, tt.limit, 0)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KVStorage.DeleteExpired() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want || more != tt.wantMore || len(tt.fields.kvstorage) != tt.wantLen {
+				t.Errorf("KVStorage.DeleteExpired() = %v, %v, len = %v, want %v, %v, %v",
+					got, more, len(tt.fields.kvstorage), tt.want, tt.wantMore, tt.wantLen)
+			}
+		})
+	}
+	if _, found, _ := goodStorage.Lookup("key4"); !found {
+		t.Errorf("KVStorage.DeleteExpired() key4 is removed too early")
+	}
+}
+
+func TestKVStorage_DeleteFrontOlder(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clocktest.NewFake(start)
+	goodStorage := NewStorage(WithClock(clk))
+	for i := 0; i < 5; i++ {
+		check(goodStorage.Set("key"+strconv.Itoa(i), KEYVALUE), t)
+		clk.Advance(time.Second)
+	}
+
+	badStorage := NewStorage()
+	badStorage.initialized = false
+
+	tests := []struct {
+		name     string
+		fields   *KVStorage
+		ctxTime  time.Time
+		limit    int
+		want     int
+		wantMore bool
+		wantErr  bool
+		wantLen  int
+	}{
+		{
+			name:    "Storage is not initialized",
+			fields:  badStorage,
+			limit:   10,
+			wantErr: true,
+		},
+		{
+			name:    "Wrong limit",
+			fields:  goodStorage,
+			ctxTime: start,
+			wantErr: true,
+			wantLen: 5,
+		},
+		{
+			name:    "Empty storage",
+			fields:  NewStorage(),
+			ctxTime: start,
+			limit:   10,
+		},
+		{
+			name:    "Nothing is older",
+			fields:  goodStorage,
+			ctxTime: start,
+			limit:   10,
+			wantLen: 5,
+		},
+		{
+			name:     "Three elements are older, but the limit is two",
+			fields:   goodStorage,
+			ctxTime:  start.Add(2500 * time.Millisecond),
+			limit:    2,
+			want:     2,
+			wantMore: true,
+			wantLen:  3,
+		},
+		{
+			name:    "The rest of older elements",
+			fields:  goodStorage,
+			ctxTime: start.Add(2500 * time.Millisecond),
+			limit:   2,
+			want:    1,
+			wantLen: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, more, err := tt.fields.DeleteFrontOlder(tt.ctxTime, tt.limit, time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KVStorage.DeleteFrontOlder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want || more != tt.wantMore || len(tt.fields.kvstorage) != tt.wantLen {
+				t.Errorf("KVStorage.DeleteFrontOlder() = %v, %v, len = %v, want %v, %v, %v",
+					got, more, len(tt.fields.kvstorage), tt.want, tt.wantMore, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestKVStorage_WithPurgeNotifier(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clocktest.NewFake(start)
+	var purged []string
+	var storage *KVStorage
+	storage = NewStorage(WithClock(clk), WithPurgeNotifier(func(key string) {
+		// the storage is unlocked already, otherwise it's a deadlock
+		if _, found, _ := storage.Lookup(key); found {
+			t.Errorf("purged key %v is still in the storage", key)
+		}
+		purged = append(purged, key)
+	}))
+	for _, key := range []string{"ttl", "expireat", "deleted"} {
+		if err := storage.Set(key, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := storage.ExpireAt("expireat", start.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// the keys removed by the clients are not purged
+	if _, err := storage.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Minute)
+	if _, _, err := storage.DeleteExpired(clk.Now(), 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storage.DeleteFrontOlder(clk.Now(), 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"expireat", "ttl"}; !reflect.DeepEqual(purged, want) {
+		t.Errorf("purged keys = %v, want %v", purged, want)
+	}
+}
+
+func TestKVStorage_WithTracer(t *testing.T) {
+	type traced struct {
+		ctx context.Context
+		op  string
+	}
+	var got []traced
+	storage := NewStorage(WithTracer(func(ctx context.Context, op string, start, locked, unlocked time.Time) {
+		if locked.Before(start) || unlocked.Before(locked) {
+			t.Errorf("%v timings are out of order: %v, %v, %v", op, start, locked, unlocked)
+		}
+		got = append(got, traced{ctx: ctx, op: op})
+	}))
+	// the storage itself is not traced
+	if err := storage.Set(KEYNAME, KEYVALUE); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), traced{}, "request")
+	view := storage.WithContext(ctx)
+	if value, _ := view.Get(KEYNAME); string(value) != KEYVALUE {
+		t.Fatal("the view doesn't share the elements with the storage")
+	}
+	if _, err := view.LPush("list", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := view.BRPop(ctx, "list", 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []traced{{ctx: ctx, op: "Get"}, {ctx: ctx, op: "LPush"}, {ctx: ctx, op: "BRPop"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("traced operations = %v, want %v", got, want)
+	}
+	if _, found, _ := storage.Lookup("list"); found {
+		t.Error("the view's changes are not in the storage, the list is still there")
+	}
+}
+
+func Test_batch(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		budget time.Duration
+		want   int // number of elements processed
+	}{
+		{name: "Count limit", limit: 10, want: 10},
+		{name: "Count limit within time budget", limit: 100, budget: time.Hour, want: 100},
+		{name: "Time budget is spent", limit: 1000, budget: time.Nanosecond, want: batchCheckPeriod},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBatch(tt.limit, tt.budget)
+			for b.next() {
+				if b.done == batchCheckPeriod {
+					// makes sure the budget is spent before it's checked
+					time.Sleep(time.Millisecond)
+				}
+				b.done++
+			}
+			if b.done != tt.want {
+				t.Errorf("batch processed %v elements, want %v", b.done, tt.want)
+			}
+		})
+	}
+}
+
 func check(e error, t *testing.T) {
 	if e != nil {
 		t.Error("Something is wrong with tests")
@@ -0,0 +1,201 @@
+package kvstorage
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/proway2/kvserver/element"
+)
+
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits // number of slots in every level of the wheel
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4
+	// defaultWheelTick - the wheel's resolution, elements never expire earlier than their time,
+	// but may expire up to one tick later
+	defaultWheelTick = 10 * time.Millisecond
+)
+
+// timingWheel - hierarchical timing wheel of the elements with absolute expiration time.
+// Slots of the level 0 span one tick each, slots of every next level span the whole previous level.
+// Adding and removing an element take constant time. Elements are moved to the lower levels
+// (cascaded) as the wheel advances, each element is cascaded at most wheelLevels times.
+// Elements are stored by their keys, each element keeps the slot it's in.
+type timingWheel struct {
+	tick     time.Duration
+	next     int64 // number of the next tick to process
+	levels   [wheelLevels][wheelSize]*list.List
+	overflow *list.List                  // elements beyond the span of the top level
+	due      *list.List                  // elements which are expired and waiting to be purged
+	count    int                         // number of elements in levels and overflow
+	elems    map[string]*element.Element // storage's map
+}
+
+func newTimingWheel(elems map[string]*element.Element, tick time.Duration, now time.Time) *timingWheel {
+	w := &timingWheel{
+		tick:     tick,
+		overflow: list.New(),
+		due:      list.New(),
+		elems:    elems,
+	}
+	for level := range w.levels {
+		for slot := range w.levels[level] {
+			w.levels[level][slot] = list.New()
+		}
+	}
+	w.next = w.tickOf(now) + 1
+	return w
+}
+
+// tickOf returns the number of the tick the time belongs to
+func (w *timingWheel) tickOf(t time.Time) int64 {
+	return t.UnixNano() / int64(w.tick)
+}
+
+// deadlineOf returns the number of the tick the element with the expiration time expires at.
+// It's rounded up, so the element never expires earlier.
+func (w *timingWheel) deadlineOf(expires time.Time) int64 {
+	ns := expires.UnixNano()
+	deadline := ns / int64(w.tick)
+	if ns%int64(w.tick) != 0 {
+		deadline++
+	}
+	return deadline
+}
+
+// timeOf returns the time the tick starts at
+func (w *timingWheel) timeOf(tick int64) time.Time {
+	return time.Unix(0, tick*int64(w.tick))
+}
+
+// add puts the element to the wheel according to its expiration time
+func (w *timingWheel) add(key string, elem *element.Element) {
+	deadline := w.deadlineOf(elem.Expires)
+	if deadline < w.next {
+		// the tick is processed already
+		elem.WheelSlot = w.due
+		elem.WheelElement = w.due.PushBack(key)
+		return
+	}
+	elem.WheelSlot = w.slotFor(deadline)
+	elem.WheelElement = elem.WheelSlot.PushBack(key)
+	w.count++
+}
+
+// slotFor returns the slot for the deadline, the deadline MUST NOT be earlier than the next tick
+func (w *timingWheel) slotFor(deadline int64) *list.List {
+	delta := deadline - w.next
+	for level := 0; level < wheelLevels; level++ {
+		if delta < 1<<(wheelBits*(level+1)) {
+			return w.levels[level][(deadline>>(wheelBits*level))&wheelMask]
+		}
+	}
+	return w.overflow
+}
+
+// remove takes the element off the wheel
+func (w *timingWheel) remove(elem *element.Element) {
+	if elem.WheelSlot != w.due {
+		w.count--
+	}
+	elem.WheelSlot.Remove(elem.WheelElement)
+	elem.WheelSlot, elem.WheelElement = nil, nil
+}
+
+// advance processes the ticks up to the current one, all expired elements are moved to the due list.
+// It stops as soon as the due list is not empty, so the caller can purge the elements in batches.
+func (w *timingWheel) advance(now time.Time) {
+	current := w.tickOf(now)
+	for w.due.Len() == 0 && w.next <= current {
+		tick, ok := w.nextEvent()
+		if !ok || tick > current {
+			// nothing happens till the current tick, it's safe to skip empty ticks
+			w.next = current + 1
+			return
+		}
+		w.next = tick
+		w.processTick()
+	}
+}
+
+// processTick cascades the higher levels if needed and moves expired elements to the due list
+func (w *timingWheel) processTick() {
+	tick := w.next
+	for level := 1; level < wheelLevels; level++ {
+		if tick&(1<<(wheelBits*level)-1) != 0 {
+			break
+		}
+		w.cascade(w.levels[level][(tick>>(wheelBits*level))&wheelMask])
+	}
+	if tick&(1<<(wheelBits*wheelLevels)-1) == 0 {
+		w.cascade(w.overflow)
+	}
+	slot := w.levels[0][tick&wheelMask]
+	for e := slot.Front(); e != nil; e = slot.Front() {
+		key := slot.Remove(e).(string)
+		elem := w.elems[key]
+		elem.WheelSlot = w.due
+		elem.WheelElement = w.due.PushBack(key)
+		w.count--
+	}
+	w.next++
+}
+
+// cascade puts all elements of the slot to the wheel again relative to the next tick.
+// Elements of the overflow may get back to it, these are not processed twice.
+func (w *timingWheel) cascade(slot *list.List) {
+	for n := slot.Len(); n > 0; n-- {
+		key := slot.Remove(slot.Front()).(string)
+		w.count--
+		w.add(key, w.elems[key])
+	}
+}
+
+// nextEvent returns the earliest tick which either expires elements or cascades a higher level
+func (w *timingWheel) nextEvent() (int64, bool) {
+	if w.count == 0 {
+		return 0, false
+	}
+	best, found := int64(0), false
+	for i := int64(0); i < wheelSize; i++ {
+		if w.levels[0][(w.next+i)&wheelMask].Len() != 0 {
+			best, found = w.next+i, true
+			break
+		}
+	}
+	for level := 1; level < wheelLevels; level++ {
+		shift := uint(wheelBits * level)
+		// the slot is cascaded at the first tick of its span, the span of the next tick's slot
+		// is skipped unless the next tick is the first one in it, see slotFor
+		base := (w.next + 1<<shift - 1) >> shift
+		for i := int64(0); i < wheelSize; i++ {
+			if w.levels[level][(base+i)&wheelMask].Len() != 0 {
+				if tick := (base + i) << shift; !found || tick < best {
+					best, found = tick, true
+				}
+				break
+			}
+		}
+	}
+	if w.overflow.Len() != 0 {
+		shift := uint(wheelBits * wheelLevels)
+		if tick := (w.next + 1<<shift - 1) >> shift << shift; !found || tick < best {
+			best, found = tick, true
+		}
+	}
+	return best, found
+}
+
+// nextExpiration returns the time the wheel must be advanced at,
+// no element expires earlier than that
+func (w *timingWheel) nextExpiration() (time.Time, bool) {
+	if w.due.Len() != 0 {
+		return w.timeOf(w.next - 1), true
+	}
+	tick, ok := w.nextEvent()
+	if !ok {
+		return time.Time{}, false
+	}
+	return w.timeOf(tick), true
+}
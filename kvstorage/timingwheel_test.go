@@ -0,0 +1,106 @@
+package kvstorage
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/element"
+)
+
+func Test_timingWheel_advance(t *testing.T) {
+	tick := time.Millisecond
+	start := time.Unix(1000, 0)
+	// the spans of all levels and the overflow are covered
+	offsets := []time.Duration{
+		0, tick / 2, tick, 63 * tick, 64 * tick, 65 * tick,
+		4095 * tick, 4096 * tick, 5000 * tick,
+		time.Duration(1<<18) * tick, time.Duration(1<<24) * tick, time.Duration(1<<24+1) * tick,
+		time.Duration(3<<24) * tick,
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		offsets = append(offsets, time.Duration(rnd.Int63n(int64(1<<25)*int64(tick))))
+	}
+
+	tests := []struct {
+		name string
+		step time.Duration // how far the wheel is advanced at once, zero means to the next expiration
+	}{
+		{name: "Jumping to the next expiration", step: 0},
+		{name: "Advancing by short steps", step: 3*time.Second + 7*time.Millisecond},
+		{name: "Advancing by long steps", step: 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elems := make(map[string]*element.Element)
+			wheel := newTimingWheel(elems, tick, start)
+			for i, offset := range offsets {
+				key := strconv.Itoa(i)
+				elems[key] = &element.Element{Expires: start.Add(offset)}
+				wheel.add(key, elems[key])
+			}
+
+			expired := 0
+			for now := start; expired < len(offsets); {
+				next, ok := wheel.nextExpiration()
+				if !ok {
+					t.Fatalf("nextExpiration() found nothing, %v elements are left", len(offsets)-expired)
+				}
+				if tt.step == 0 {
+					// jumping straight to the next event must not miss anything
+					now = next
+				} else {
+					now = now.Add(tt.step)
+				}
+				// the wheel stops at every tick with expired elements, these are purged in between
+				for wheel.advance(now); wheel.due.Len() != 0; wheel.advance(now) {
+					for e := wheel.due.Front(); e != nil; e = wheel.due.Front() {
+						elem := elems[e.Value.(string)]
+						if elem.Expires.After(now) {
+							t.Fatalf("element expiring at %v is expired at %v", elem.Expires, now)
+						}
+						if now.Sub(elem.Expires) >= tick+tt.step {
+							t.Fatalf("element expiring at %v is expired too late at %v", elem.Expires, now)
+						}
+						wheel.remove(elem)
+						expired++
+					}
+				}
+			}
+			if wheel.count != 0 {
+				t.Errorf("timingWheel.count = %v, want 0", wheel.count)
+			}
+		})
+	}
+}
+
+func Test_timingWheel_remove(t *testing.T) {
+	tick := time.Millisecond
+	start := time.Unix(1000, 0)
+	elems := make(map[string]*element.Element)
+	wheel := newTimingWheel(elems, tick, start)
+	for i, offset := range []time.Duration{time.Second, time.Hour, 100 * time.Hour} {
+		key := strconv.Itoa(i)
+		elems[key] = &element.Element{Expires: start.Add(offset)}
+		wheel.add(key, elems[key])
+	}
+	for _, elem := range elems {
+		wheel.remove(elem)
+		if elem.WheelSlot != nil || elem.WheelElement != nil {
+			t.Errorf("timingWheel.remove() element is still in the slot")
+		}
+	}
+	if wheel.count != 0 {
+		t.Errorf("timingWheel.count = %v, want 0", wheel.count)
+	}
+	if _, ok := wheel.nextExpiration(); ok {
+		t.Errorf("timingWheel.nextExpiration() found an element in the empty wheel")
+	}
+	// idle wheel skips the time at once
+	wheel.advance(start.Add(1000 * time.Hour))
+	if want := wheel.tickOf(start.Add(1000*time.Hour)) + 1; wheel.next != want {
+		t.Errorf("timingWheel.next = %v, want %v", wheel.next, want)
+	}
+}
@@ -0,0 +1,184 @@
+package kvstorage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/proway2/kvserver/element"
+)
+
+// ErrVersionMismatch - the element's version is not the one the operation expects
+var ErrVersionMismatch = errors.New("element's version doesn't match")
+
+// TxOpKind - the kind of the transaction's operation
+type TxOpKind int
+
+const (
+	// TxGet returns the string value of the key
+	TxGet TxOpKind = iota
+	// TxSet stores the string value of the key, the same as Set
+	TxSet
+	// TxDelete removes the key, the same as Delete
+	TxDelete
+)
+
+// TxOp - the operation of the transaction along with its preconditions
+type TxOp struct {
+	Kind  TxOpKind
+	Key   string
+	Value string // the value to store, TxSet only
+	// preconditions checked right before the operation, i.e. after the previous operations are done
+	Mode    SetMode // the key must either be or not be in the storage, Upsert means no check
+	Version uint64  // the element's version must be this, zero means no check
+}
+
+// TxResult - the result of the transaction's operation
+type TxResult struct {
+	Key     string
+	Value   string // the value of the key, TxGet only
+	Found   bool   // whether the key was in the storage before the operation
+	Version uint64 // the element's version after the operation, zero if the key is not in the storage
+}
+
+// TxError - the error of the operation which failed the transaction
+type TxError struct {
+	Index int // index of the operation
+	Err   error
+}
+
+func (e *TxError) Error() string {
+	return fmt.Sprintf("operation %v: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error of the operation
+func (e *TxError) Unwrap() error {
+	return e.Err
+}
+
+// staged - the element as the transaction's operations see it before they are applied
+type staged struct {
+	exists  bool // false if the element is not in the storage or it has expired
+	kind    element.Kind
+	value   string
+	version uint64
+	size    uint64 // memory taken by the element, expired one counts until it's purged
+	locked  bool
+}
+
+// Transaction applies the operations in order atomically, no other operation on the storage sees them
+// partially done. Either all operations are applied or none, if any precondition or operation fails
+// it returns *TxError telling which one. There is no results in that case.
+func (kv *KVStorage) Transaction(ops []TxOp) ([]TxResult, error) {
+	return kv.Exec(nil, ops)
+}
+
+// Exec is the same as Transaction, but it's applied only if none of the watched keys has changed,
+// i.e. they all still have the versions returned by Watch. Otherwise it returns *WatchError.
+func (kv *KVStorage) Exec(watched map[string]uint64, ops []TxOp) ([]TxResult, error) {
+	if !kv.initialized || len(ops) == 0 {
+		return nil, errors.New("transaction: Storage is not initialized or no operations provided")
+	}
+	for i, op := range ops {
+		if len(op.Key) == 0 || op.Kind < TxGet || op.Kind > TxDelete {
+			return nil, &TxError{Index: i, Err: errors.New("key is empty or unknown operation")}
+		}
+	}
+	defer kv.lock("Exec")()
+	now := kv.clock.Now()
+	if err := kv.unchanged(watched, now); err != nil {
+		return nil, err
+	}
+	// the operations are tried out first, so nothing has to be rolled back once they are applied
+	results, err := kv.try(ops, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		switch op.Kind {
+		case TxGet:
+			if elem, ok := kv.lookup(op.Key, now); ok {
+				kv.accessed(elem)
+			}
+		case TxSet:
+			kv.set(op.Key, op.Value, now)
+		case TxDelete:
+			if _, ok := kv.kvstorage[op.Key]; ok {
+				kv.purgeElement(op.Key)
+			}
+		}
+	}
+	return results, nil
+}
+
+// try checks the preconditions and the results of the operations without applying them.
+// Versions are predicted, since every set increments the storage's revision once.
+// MUST be called within critical section.
+func (kv *KVStorage) try(ops []TxOp, now time.Time) ([]TxResult, error) {
+	view := make(map[string]*staged)
+	revision, memory := kv.revision, kv.memory
+	results := make([]TxResult, 0, len(ops))
+	for i, op := range ops {
+		s, ok := view[op.Key]
+		if !ok {
+			s = kv.stage(op.Key, now)
+			view[op.Key] = s
+		}
+		if err := kv.condition([]SetMode{op.Mode}, s.exists); err != nil {
+			return nil, &TxError{Index: i, Err: err}
+		}
+		if op.Version != 0 && (!s.exists || s.version != op.Version) {
+			return nil, &TxError{Index: i, Err: ErrVersionMismatch}
+		}
+		result := TxResult{Key: op.Key, Found: s.exists, Version: s.version}
+		switch op.Kind {
+		case TxGet:
+			if s.exists && s.kind != element.String {
+				return nil, &TxError{Index: i, Err: ErrWrongType}
+			}
+			result.Value = s.value
+		case TxSet:
+			if s.locked {
+				return nil, &TxError{Index: i, Err: ErrLocked}
+			}
+			size := uint64(len(op.Key) + len(op.Value))
+			if kv.maxMemory != 0 && memory-s.size+size > kv.maxMemory {
+				return nil, &TxError{Index: i, Err: ErrNoMemory}
+			}
+			memory = memory - s.size + size
+			revision++
+			*s = staged{exists: true, kind: element.String, value: op.Value, version: revision, size: size}
+			result.Version = revision
+		case TxDelete:
+			if s.locked {
+				return nil, &TxError{Index: i, Err: ErrLocked}
+			}
+			memory -= s.size
+			*s = staged{}
+			result.Version = 0
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// stage returns the element as it's in the storage now.
+// MUST be called within critical section.
+func (kv *KVStorage) stage(key string, now time.Time) *staged {
+	s := &staged{}
+	elem, ok := kv.kvstorage[key]
+	if !ok {
+		return s
+	}
+	s.size = elem.Size
+	if kv.expired(elem, now) {
+		return s
+	}
+	s.exists, s.kind, s.value, s.version = true, elem.Kind, elem.Val, elem.Version
+	s.locked = elem.Kind == element.Lock
+	if s.kind != element.String {
+		// the value of other kinds is not a string
+		s.value = ""
+	}
+	return s
+}
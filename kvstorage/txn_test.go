@@ -0,0 +1,120 @@
+package kvstorage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKVStorage_Transaction(t *testing.T) {
+	storage := NewStorage(WithMaxMemory(64))
+	check(storage.Set("from", "100"), t)
+	check(storage.Set("to", "0"), t)
+	from, _, _ := storage.Lookup("from")
+
+	tests := []struct {
+		name       string
+		ops        []TxOp
+		want       []TxResult
+		wantErr    error
+		wantIndex  int
+		wantValues map[string]string // values of the keys after the transaction, empty string if there is no key
+	}{
+		{
+			name: "Moving the value",
+			ops: []TxOp{
+				{Kind: TxGet, Key: "from", Version: from.Version},
+				{Kind: TxSet, Key: "to", Value: "100", Mode: IfExists},
+				{Kind: TxDelete, Key: "from"},
+				{Kind: TxGet, Key: "from"},
+			},
+			want: []TxResult{
+				{Key: "from", Value: "100", Found: true, Version: from.Version},
+				{Key: "to", Found: true, Version: 3},
+				{Key: "from", Found: true},
+				{Key: "from"},
+			},
+			wantValues: map[string]string{"from": "", "to": "100"},
+		},
+		{
+			name: "Version doesn't match",
+			ops: []TxOp{
+				{Kind: TxSet, Key: "from", Value: "1"},
+				{Kind: TxSet, Key: "to", Value: "1", Version: 2},
+			},
+			wantErr:    ErrVersionMismatch,
+			wantIndex:  1,
+			wantValues: map[string]string{"from": "", "to": "100"},
+		},
+		{
+			name: "Key already exists",
+			ops: []TxOp{
+				{Kind: TxSet, Key: "from", Value: "1", Mode: IfAbsent},
+				{Kind: TxSet, Key: "from", Value: "2", Mode: IfAbsent},
+			},
+			wantErr:    ErrKeyExists,
+			wantIndex:  1,
+			wantValues: map[string]string{"from": "", "to": "100"},
+		},
+		{
+			name: "Precondition sees previous operations",
+			ops: []TxOp{
+				{Kind: TxSet, Key: "from", Value: "1", Mode: IfAbsent},
+				{Kind: TxSet, Key: "from", Value: "2", Version: 4},
+			},
+			want: []TxResult{
+				{Key: "from", Version: 4},
+				{Key: "from", Found: true, Version: 5},
+			},
+			wantValues: map[string]string{"from": "2", "to": "100"},
+		},
+		{
+			name: "Memory quota is exceeded",
+			ops: []TxOp{
+				{Kind: TxDelete, Key: "to"},
+				{Kind: TxSet, Key: "big", Value: string(make([]byte, 60))},
+			},
+			wantErr:    ErrNoMemory,
+			wantIndex:  1,
+			wantValues: map[string]string{"from": "2", "to": "100"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storage.Transaction(tt.ops)
+			var txErr *TxError
+			if tt.wantErr != nil && (!errors.As(err, &txErr) || txErr.Err != tt.wantErr || txErr.Index != tt.wantIndex) {
+				t.Errorf("KVStorage.Transaction() error = %v, want %v at %v", err, tt.wantErr, tt.wantIndex)
+			}
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("KVStorage.Transaction() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KVStorage.Transaction() = %+v, want %+v", got, tt.want)
+			}
+			for key, want := range tt.wantValues {
+				if value, _ := storage.Get(key); string(value) != want {
+					t.Errorf("KVStorage.Get(%q) = %q, want %q", key, value, want)
+				}
+			}
+		})
+	}
+	if memory, _ := storage.MemoryUsage(); memory != uint64(len("from2to100")) {
+		t.Errorf("KVStorage.MemoryUsage() = %v, want %v", memory, len("from2to100"))
+	}
+}
+
+func TestKVStorage_Transaction_locked(t *testing.T) {
+	storage := NewStorage()
+	_, err := storage.Acquire("lock", time.Minute)
+	check(err, t)
+	_, err = storage.Transaction([]TxOp{{Kind: TxGet, Key: "lock"}})
+	if !errors.Is(err, ErrWrongType) {
+		t.Errorf("KVStorage.Transaction() error = %v, want %v", err, ErrWrongType)
+	}
+	_, err = storage.Transaction([]TxOp{{Kind: TxDelete, Key: "lock"}})
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("KVStorage.Transaction() error = %v, want %v", err, ErrLocked)
+	}
+}
@@ -0,0 +1,69 @@
+package kvstorage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrWatchedChanged - the watched key has changed since it's been watched
+var ErrWatchedChanged = errors.New("watched key has changed")
+
+// WatchError - the error of the watched key which failed the transaction
+type WatchError struct {
+	Key string
+}
+
+func (e *WatchError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Key, ErrWatchedChanged)
+}
+
+// Unwrap returns ErrWatchedChanged
+func (e *WatchError) Unwrap() error {
+	return ErrWatchedChanged
+}
+
+// Watch returns the versions of the elements, zero for the key which is not in the storage.
+// The versions are passed to Exec which fails if any element is changed, deleted, expired
+// or created in between. Elements of any kind may be watched.
+func (kv *KVStorage) Watch(keys ...string) (map[string]uint64, error) {
+	if !kv.initialized || len(keys) == 0 {
+		return nil, errors.New("watch: Storage is not initialized or no keys provided")
+	}
+	defer kv.lock("Watch")()
+	now := kv.clock.Now()
+	versions := make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		versions[key] = kv.version(key, now)
+	}
+	return versions, nil
+}
+
+// unchanged checks the watched elements still have their versions.
+// MUST be called within critical section.
+func (kv *KVStorage) unchanged(watched map[string]uint64, now time.Time) error {
+	keys := make([]string, 0, len(watched))
+	for key := range watched {
+		keys = append(keys, key)
+	}
+	// the same key is reported every time if several ones have changed
+	sort.Strings(keys)
+	for _, key := range keys {
+		if kv.version(key, now) != watched[key] {
+			return &WatchError{Key: key}
+		}
+	}
+	return nil
+}
+
+// version returns element's version, zero if the key is not in the storage or the element has expired.
+// Versions are never reused, so the element which is deleted and created again has another one.
+// MUST be called within critical section.
+func (kv *KVStorage) version(key string, now time.Time) uint64 {
+	elem, ok := kv.kvstorage[key]
+	if !ok || kv.expired(elem, now) {
+		return 0
+	}
+	return elem.Version
+}
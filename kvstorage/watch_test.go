@@ -0,0 +1,94 @@
+package kvstorage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/clock/clocktest"
+)
+
+func TestKVStorage_Exec(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage := NewStorage(WithTTL(time.Minute), WithClock(clk))
+	incr := []TxOp{{Kind: TxSet, Key: "counter", Value: "2"}}
+
+	tests := []struct {
+		name    string
+		key     string
+		between func() // changes made by other clients after the key is watched
+		wantKey string // the key which has changed, empty if the transaction is applied
+	}{
+		{
+			name:    "Nothing has changed",
+			key:     "counter",
+			between: func() {},
+		},
+		{
+			name:    "Watched key is changed",
+			key:     "counter",
+			between: func() { check(storage.Set("counter", "5"), t) },
+			wantKey: "counter",
+		},
+		{
+			name:    "Watched key is changed back",
+			key:     "counter",
+			between: func() { check(storage.Set("counter", "2"), t) },
+			wantKey: "counter",
+		},
+		{
+			name: "Watched key is deleted and created again",
+			key:  "counter",
+			between: func() {
+				_, err := storage.Delete("counter")
+				check(err, t)
+				check(storage.Set("counter", "2"), t)
+			},
+			wantKey: "counter",
+		},
+		{
+			name: "Watched key's expiration time is changed",
+			key:  "counter",
+			between: func() {
+				_, err := storage.Persist("counter")
+				check(err, t)
+			},
+			wantKey: "counter",
+		},
+		{
+			name:    "Missing key is created",
+			key:     "missing",
+			between: func() { check(storage.Set("missing", "1"), t) },
+			wantKey: "missing",
+		},
+		{
+			name:    "Reading the key is not a change",
+			key:     "counter",
+			between: func() { _, _ = storage.Get("counter") },
+		},
+		{
+			name:    "Watched key expires",
+			key:     "other",
+			between: func() { clk.Advance(2 * time.Minute) },
+			wantKey: "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check(storage.Set("other", "1"), t)
+			watched, err := storage.Watch(tt.key, "other")
+			check(err, t)
+			tt.between()
+			_, err = storage.Exec(watched, incr)
+			var watchErr *WatchError
+			switch {
+			case tt.wantKey == "" && err != nil:
+				t.Errorf("KVStorage.Exec() error = %v", err)
+			case tt.wantKey != "" && (!errors.As(err, &watchErr) || watchErr.Key != tt.wantKey):
+				t.Errorf("KVStorage.Exec() error = %v, want the key %q has changed", err, tt.wantKey)
+			case tt.wantKey != "" && !errors.Is(err, ErrWatchedChanged):
+				t.Errorf("KVStorage.Exec() error = %v, want %v", err, ErrWatchedChanged)
+			}
+		})
+	}
+}
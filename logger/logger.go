@@ -0,0 +1,292 @@
+// Package logger - leveled structured logger writing either logfmt or JSON lines
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/proway2/kvserver/clock"
+)
+
+// Level - severity of the record, the records below the logger's level are dropped
+type Level int32
+
+const (
+	// Debug - details of the server's work, e.g. the cleaner's sleep periods
+	Debug Level = iota
+	// Info - the served requests and the changes of the server's state
+	Info
+	// Warn - something went wrong, but the server keeps working as usual
+	Warn
+	// Error - the server cannot do what it's been asked for
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the level's name as it's written to the log
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level by its name: debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(l), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level '%v', it must be debug, info, warn or error", name)
+}
+
+// Format - the way the records are written
+type Format int
+
+const (
+	// Logfmt - key=value pairs, one record per line
+	Logfmt Format = iota
+	// JSON - JSON object, one record per line
+	JSON
+)
+
+// ParseFormat returns the format by its name: logfmt or json
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "logfmt":
+		return Logfmt, nil
+	case "json":
+		return JSON, nil
+	}
+	return Logfmt, fmt.Errorf("unknown log format '%v', it must be logfmt or json", name)
+}
+
+// output - the destination shared by the logger and the ones derived from it with With
+type output struct {
+	mux    sync.Mutex // the records of concurrent goroutines are never mixed up
+	w      io.Writer
+	format Format
+	level  int32 // Level, it may be changed while the logger is used
+	clock  clock.Clock
+}
+
+// Logger - writes the records with the message and the key-value pairs like
+// time=2006-01-02T15:04:05.000Z level=info msg="request is served" key=a status=200.
+// It's safe for concurrent use.
+type Logger struct {
+	out *output
+	// key-value pairs added to every record, see With
+	fields []interface{}
+}
+
+// Option configures the logger, see NewLogger
+type Option func(*output)
+
+// WithLevel sets the lowest level of the records written, Info by default
+func WithLevel(level Level) Option {
+	return func(o *output) {
+		o.level = int32(level)
+	}
+}
+
+// WithFormat sets the format of the records, Logfmt by default
+func WithFormat(format Format) Option {
+	return func(o *output) {
+		o.format = format
+	}
+}
+
+// WithClock sets the source of the records' time, the real clock is used by default
+func WithClock(c clock.Clock) Option {
+	return func(o *output) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
+
+// NewLogger returns the logger writing the records to w
+func NewLogger(w io.Writer, opts ...Option) *Logger {
+	out := &output{w: w, level: int32(Info), clock: clock.Real{}}
+	for _, opt := range opts {
+		opt(out)
+	}
+	return &Logger{out: out}
+}
+
+// Discard returns the logger which writes nothing
+func Discard() *Logger {
+	return NewLogger(io.Discard, WithLevel(Error+1))
+}
+
+// With returns the logger adding the key-value pairs to every record, e.g. the server's component.
+// The loggers share the destination and the level.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	return &Logger{out: l.out, fields: append(fields, keyvals...)}
+}
+
+// SetLevel changes the lowest level of the records written, it's applied to the derived loggers as well
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.out.level, int32(level))
+}
+
+// Enabled reports whether the records of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&l.out.level))
+}
+
+// Debug writes the record of Debug level, keyvals are key-value pairs like "key", "a", "status", 200
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(Debug, msg, keyvals)
+}
+
+// Info writes the record of Info level, see Debug
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(Info, msg, keyvals)
+}
+
+// Warn writes the record of Warn level, see Debug
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(Warn, msg, keyvals)
+}
+
+// Error writes the record of Error level, see Debug
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(Error, msg, keyvals)
+}
+
+// Fatal writes the record of Error level and exits, the same as log.Fatal
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(Error, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	rec := record{format: l.out.format}
+	rec.add("time", l.out.clock.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+	rec.add("level", level.String())
+	rec.add("msg", msg)
+	rec.addPairs(l.fields)
+	rec.addPairs(keyvals)
+	rec.end()
+
+	l.out.mux.Lock()
+	defer l.out.mux.Unlock()
+	_, _ = l.out.w.Write(rec.buf.Bytes())
+}
+
+// record - the line written to the log
+type record struct {
+	format Format
+	buf    bytes.Buffer
+}
+
+// addPairs adds the key-value pairs, the key without the value gets "(MISSING)"
+func (r *record) addPairs(keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		r.add(fmt.Sprint(keyvals[i]), value)
+	}
+}
+
+func (r *record) add(key string, value interface{}) {
+	if r.format == JSON {
+		if r.buf.Len() == 0 {
+			r.buf.WriteByte('{')
+		} else {
+			r.buf.WriteByte(',')
+		}
+		r.buf.Write(jsonValue(key))
+		r.buf.WriteByte(':')
+		r.buf.Write(jsonValue(value))
+		return
+	}
+	if r.buf.Len() != 0 {
+		r.buf.WriteByte(' ')
+	}
+	r.buf.WriteString(logfmtKey(key))
+	r.buf.WriteByte('=')
+	r.buf.WriteString(logfmtValue(value))
+}
+
+func (r *record) end() {
+	if r.format == JSON {
+		r.buf.WriteByte('}')
+	}
+	r.buf.WriteByte('\n')
+}
+
+// jsonValue returns the value as JSON: numbers and booleans are kept as they are, the rest are strings
+func jsonValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return []byte("null")
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		if data, err := json.Marshal(v); err == nil {
+			return data
+		}
+	}
+	data, _ := json.Marshal(text(value))
+	return data
+}
+
+// logfmtKey returns the key with the characters which break logfmt replaced by _
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue returns the value quoted if it's empty or it has spaces, quotes, = or control characters
+func logfmtValue(value interface{}) string {
+	s := text(value)
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// text returns the value as it's written to the log
+func text(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		// time.Duration is written like 1.5ms
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
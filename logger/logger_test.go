@@ -0,0 +1,92 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/clock/clocktest"
+)
+
+func TestLogger(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 1, 2, 3, 4, 5, 6000000, time.UTC))
+	tests := []struct {
+		name    string
+		format  Format
+		level   Level
+		log     func(l *Logger)
+		wantLog string
+	}{
+		{
+			name:   "Logfmt",
+			format: Logfmt,
+			level:  Info,
+			log: func(l *Logger) {
+				l.With("component", "router").Info("request is served",
+					"key", "a b", "status", 200, "latency", 1500*time.Microsecond, "empty", "")
+			},
+			wantLog: `time=2026-01-02T03:04:05.006Z level=info msg="request is served" component=router key="a b" status=200 latency=1.5ms empty=""` + "\n",
+		},
+		{
+			name:   "JSON",
+			format: JSON,
+			level:  Info,
+			log: func(l *Logger) {
+				l.Warn("cannot reload", "err", errors.New(`bad "ttl"`), "ok", false, "missing")
+			},
+			wantLog: `{"time":"2026-01-02T03:04:05.006Z","level":"warn","msg":"cannot reload","err":"bad \"ttl\"","ok":false,"missing":"(MISSING)"}` + "\n",
+		},
+		{
+			name:   "Records below the level are dropped",
+			format: Logfmt,
+			level:  Warn,
+			log: func(l *Logger) {
+				l.Debug("sleeping")
+				l.Info("purged")
+				l.Error("failed", "key=", "v\n")
+			},
+			wantLog: `time=2026-01-02T03:04:05.006Z level=error msg=failed key_="v\n"` + "\n",
+		},
+		{
+			name:   "Level is changed",
+			format: Logfmt,
+			level:  Info,
+			log: func(l *Logger) {
+				derived := l.With("component", "cleaner")
+				l.SetLevel(Debug)
+				derived.Debug("sleeping", "period", time.Second)
+			},
+			wantLog: `time=2026-01-02T03:04:05.006Z level=debug msg=sleeping component=cleaner period=1s` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(NewLogger(&buf, WithFormat(tt.format), WithLevel(tt.level), WithClock(clk)))
+			if got := buf.String(); got != tt.wantLog {
+				t.Errorf("Logger wrote %q, want %q", got, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{name: "debug", want: Debug},
+		{name: "WARN", want: Warn},
+		{name: "verbose", want: Info, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseLevel() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
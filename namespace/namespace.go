@@ -0,0 +1,116 @@
+package namespace
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/router"
+	"github.com/proway2/kvserver/vacuum"
+)
+
+// namespace's name is used in the URL's path, so it's restricted
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// namespace - the storage with its own cleaner and HTTP handlers
+type namespace struct {
+	storage  *kvstorage.KVStorage
+	cleaner  *vacuum.Vacuum
+	handlers router.Handlers
+}
+
+// Registry - named namespaces, each one has its own storage, TTL, memory quota and cleaner
+type Registry struct {
+	mux         sync.RWMutex
+	namespaces  map[string]*namespace
+	storageOpts []kvstorage.Option
+	cleanerOpts []vacuum.Option
+	routerOpts  []router.Option
+}
+
+// NewRegistry returns an empty registry, the options are applied to every namespace's storage, cleaner
+// and HTTP handler
+func NewRegistry(storageOpts []kvstorage.Option, cleanerOpts []vacuum.Option, routerOpts []router.Option) *Registry {
+	return &Registry{
+		namespaces:  make(map[string]*namespace),
+		storageOpts: storageOpts,
+		cleanerOpts: cleanerOpts,
+		routerOpts:  routerOpts,
+	}
+}
+
+// Create adds the namespace with element's lifetime of ttl secs and memory quota of maxMemory bytes
+// (zero means no quota), its cleaner is started right away.
+// It returns false if the namespace already exists.
+func (reg *Registry) Create(name string, ttl, maxMemory uint64) (bool, error) {
+	if !validName.MatchString(name) || ttl == 0 {
+		return false, errors.New("create: namespace's name is not valid or TTL = 0")
+	}
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	if _, ok := reg.namespaces[name]; ok {
+		return false, nil
+	}
+	opts := append([]kvstorage.Option{}, reg.storageOpts...)
+	opts = append(opts,
+		kvstorage.WithTTL(time.Duration(ttl)*time.Second),
+		kvstorage.WithMaxMemory(maxMemory),
+	)
+	storage := kvstorage.NewStorage(opts...)
+	cleaner, err := vacuum.NewCleaner(storage, ttl, reg.cleanerOpts...)
+	if err != nil {
+		return false, err
+	}
+	reg.namespaces[name] = &namespace{
+		storage: storage,
+		cleaner: cleaner,
+		handlers: router.Handlers{
+			Key:  router.GetURLrouter(storage, ttl, reg.routerOpts...),
+			Keys: router.GetKeysRouter(storage),
+			Txn:  router.GetTxnRouter(storage, reg.routerOpts...),
+		},
+	}
+	go cleaner.Run()
+	return true, nil
+}
+
+// Drop removes the namespace with all its elements and stops its cleaner.
+// It returns false if there is no such namespace.
+func (reg *Registry) Drop(name string) (bool, error) {
+	reg.mux.Lock()
+	defer reg.mux.Unlock()
+	ns, ok := reg.namespaces[name]
+	if !ok {
+		return false, nil
+	}
+	ns.cleaner.Stop()
+	delete(reg.namespaces, name)
+	return true, nil
+}
+
+// Handlers returns HTTP handlers for the elements of the namespace, for listing its keys and for the transactions.
+// The second value reports whether the namespace exists.
+func (reg *Registry) Handlers(name string) (router.Handlers, bool) {
+	reg.mux.RLock()
+	defer reg.mux.RUnlock()
+	ns, ok := reg.namespaces[name]
+	if !ok {
+		return router.Handlers{}, false
+	}
+	return ns.handlers, true
+}
+
+// Names returns the names of all namespaces in alphabetical order
+func (reg *Registry) Names() []string {
+	reg.mux.RLock()
+	defer reg.mux.RUnlock()
+	names := make([]string, 0, len(reg.namespaces))
+	for name := range reg.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,113 @@
+package namespace
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry(nil, nil, nil)
+	tests := []struct {
+		name      string
+		op        func() (bool, error)
+		want      bool
+		wantErr   bool
+		wantNames []string
+	}{
+		{
+			name:      "Creating the namespace",
+			op:        func() (bool, error) { return reg.Create("team1", 60, 0) },
+			want:      true,
+			wantNames: []string{"team1"},
+		},
+		{
+			name:      "Creating existing namespace",
+			op:        func() (bool, error) { return reg.Create("team1", 30, 0) },
+			want:      false,
+			wantNames: []string{"team1"},
+		},
+		{
+			name:      "Name is not valid",
+			op:        func() (bool, error) { return reg.Create("team/2", 60, 0) },
+			wantErr:   true,
+			wantNames: []string{"team1"},
+		},
+		{
+			name:      "TTL is zero",
+			op:        func() (bool, error) { return reg.Create("team2", 0, 0) },
+			wantErr:   true,
+			wantNames: []string{"team1"},
+		},
+		{
+			name:      "Creating another namespace",
+			op:        func() (bool, error) { return reg.Create("team0", 60, 100) },
+			want:      true,
+			wantNames: []string{"team0", "team1"},
+		},
+		{
+			name:      "Dropping the namespace",
+			op:        func() (bool, error) { return reg.Drop("team1") },
+			want:      true,
+			wantNames: []string{"team0"},
+		},
+		{
+			name:      "Dropping missing namespace",
+			op:        func() (bool, error) { return reg.Drop("team1") },
+			want:      false,
+			wantNames: []string{"team0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Registry error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Registry = %v, want %v", got, tt.want)
+			}
+			if names := reg.Names(); !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("Registry.Names() = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestRegistry_Handlers(t *testing.T) {
+	reg := NewRegistry(nil, nil, nil)
+	for _, name := range []string{"team1", "team2"} {
+		if _, err := reg.Create(name, 60, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer reg.Drop("team1")
+	defer reg.Drop("team2")
+
+	handlers1, ok := reg.Handlers("team1")
+	if !ok {
+		t.Fatal("Registry.Handlers() namespace is not found")
+	}
+	handlers2, _ := reg.Handlers("team2")
+	form := url.Values{"value": {"team1 value"}}
+	req := httptest.NewRequest("POST", "/ns/team1/key/key1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handlers1.Key(httptest.NewRecorder(), req)
+
+	// namespaces don't share the keys
+	rec := httptest.NewRecorder()
+	handlers2.Key(rec, httptest.NewRequest("GET", "/ns/team2/key/key1", nil))
+	if rec.Code != 404 {
+		t.Errorf("team2 code = %v, want 404", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handlers1.Keys(rec, httptest.NewRequest("GET", "/ns/team1/keys", nil))
+	if rec.Code != 200 || rec.Body.String() != "key1\n" {
+		t.Errorf("team1 keys = %v, %q, want 200, %q", rec.Code, rec.Body.String(), "key1\n")
+	}
+	if _, ok := reg.Handlers("team3"); ok {
+		t.Errorf("Registry.Handlers() missing namespace is found")
+	}
+}
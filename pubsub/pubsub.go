@@ -0,0 +1,288 @@
+package pubsub
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// DefaultBufferSize - messages a subscriber may fall behind by before it's disconnected
+const DefaultBufferSize = 100
+
+// Message - the message published to the channel
+type Message struct {
+	Channel string
+	Pattern string // the pattern the channel matched, empty if the subscriber subscribed to the channel itself
+	Payload string
+}
+
+// Bus - channels to publish messages to, subscribers receive the messages of the channels
+// and of the patterns they subscribed to. Messages are not stored, only current subscribers get them.
+type Bus struct {
+	mux  sync.Mutex
+	subs map[*Subscription]struct{}
+	// the subscribers of the channels by the channels, so the channel is found without looking at the others
+	channels map[string]map[*Subscription]struct{}
+	// the subscribers of the patterns, they are checked one by one
+	patterned map[*Subscription]struct{}
+	// the number of the patterns which may match the keyspace channels, see KeyspaceNotifier
+	keyspacePatterns int
+	bufferSize       int
+}
+
+// Option configures the bus, see NewBus
+type Option func(*Bus)
+
+// WithBufferSize sets the number of messages the subscriber may fall behind by
+func WithBufferSize(size int) Option {
+	return func(b *Bus) {
+		if size > 0 {
+			b.bufferSize = size
+		}
+	}
+}
+
+// NewBus returns the bus without subscribers
+func NewBus(opts ...Option) *Bus {
+	b := &Bus{
+		subs:       make(map[*Subscription]struct{}),
+		channels:   make(map[string]map[*Subscription]struct{}),
+		patterned:  make(map[*Subscription]struct{}),
+		bufferSize: DefaultBufferSize,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Subscription - the subscriber of the bus, it receives the messages from C
+type Subscription struct {
+	// C receives the messages, it's closed once the subscription is over
+	C        <-chan Message
+	c        chan Message
+	bus      *Bus
+	channels map[string]struct{}
+	patterns []string
+	// the number of the patterns which may match the keyspace channels
+	keyspacePatterns int
+	// the subscriber has not read the messages in time, so it's been disconnected
+	overflowed bool
+	closed     bool
+}
+
+// Subscribe returns the subscription to the channels and to the channels matching the patterns.
+// Patterns are like the ones of Redis' PSUBSCRIBE: * matches any characters, ? matches any single
+// character and \ escapes the next one.
+// The subscriber which doesn't read the messages in time is disconnected, i.e. C is closed and
+// Overflowed reports true, so the publishers are never blocked by the slow subscriber.
+func (b *Bus) Subscribe(channels, patterns []string) (*Subscription, error) {
+	if len(channels) == 0 && len(patterns) == 0 {
+		return nil, errors.New("subscribe: no channels or patterns provided")
+	}
+	c := make(chan Message, b.bufferSize)
+	s := &Subscription{
+		C:        c,
+		c:        c,
+		bus:      b,
+		channels: make(map[string]struct{}, len(channels)),
+		patterns: patterns,
+	}
+	for _, channel := range channels {
+		s.channels[channel] = struct{}{}
+	}
+	for _, pattern := range patterns {
+		if matchPrefix(pattern, KeyspacePrefix) {
+			s.keyspacePatterns++
+		}
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.subs[s] = struct{}{}
+	for channel := range s.channels {
+		subs, ok := b.channels[channel]
+		if !ok {
+			subs = make(map[*Subscription]struct{})
+			b.channels[channel] = subs
+		}
+		subs[s] = struct{}{}
+	}
+	if len(patterns) != 0 {
+		b.patterned[s] = struct{}{}
+		b.keyspacePatterns += s.keyspacePatterns
+	}
+	return s, nil
+}
+
+// Publish sends the message to the subscribers of the channel and returns the number of the subscribers
+// which have got it. The subscriber matching several patterns gets the message once per pattern,
+// the same as Redis does.
+func (b *Bus) Publish(channel, payload string) int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.publish(channel, payload)
+}
+
+// publish sends the message to the subscribers of the channel, the ones of the channel itself are found
+// right away, the patterns are checked one by one.
+// MUST be called within critical section.
+func (b *Bus) publish(channel, payload string) int {
+	received := 0
+	for s := range b.channels[channel] {
+		received += b.deliver(s, Message{Channel: channel, Payload: payload})
+	}
+	for s := range b.patterned {
+		for _, pattern := range s.patterns {
+			if match(pattern, channel) {
+				received += b.deliver(s, Message{Channel: channel, Pattern: pattern, Payload: payload})
+			}
+		}
+	}
+	return received
+}
+
+// Subscribers returns the number of the subscribers
+func (b *Bus) Subscribers() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.subs)
+}
+
+// deliver sends the message to the subscriber, the subscriber is disconnected if its buffer is full.
+// It returns 1 if the message is sent.
+// MUST be called within critical section.
+func (b *Bus) deliver(s *Subscription, msg Message) int {
+	if s.closed {
+		return 0
+	}
+	select {
+	case s.c <- msg:
+		return 1
+	default:
+		s.overflowed = true
+		b.unsubscribe(s)
+		return 0
+	}
+}
+
+// unsubscribe removes the subscriber and closes its channel.
+// MUST be called within critical section.
+func (b *Bus) unsubscribe(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(b.subs, s)
+	for channel := range s.channels {
+		delete(b.channels[channel], s)
+		if len(b.channels[channel]) == 0 {
+			delete(b.channels, channel)
+		}
+	}
+	if _, ok := b.patterned[s]; ok {
+		delete(b.patterned, s)
+		b.keyspacePatterns -= s.keyspacePatterns
+	}
+	close(s.c)
+}
+
+// Close ends the subscription, C is closed. It's safe to call it more than once.
+func (s *Subscription) Close() {
+	s.bus.mux.Lock()
+	defer s.bus.mux.Unlock()
+	s.bus.unsubscribe(s)
+}
+
+// Overflowed reports whether the subscription is over since the subscriber has not read the messages in time
+func (s *Subscription) Overflowed() bool {
+	s.bus.mux.Lock()
+	defer s.bus.mux.Unlock()
+	return s.overflowed
+}
+
+// match reports whether the name matches the glob pattern
+func match(pattern, name string) bool {
+	// the position to go back to if the rest doesn't match the last *
+	star, next := -1, 0
+	p, n := 0, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, n
+			p++
+			continue
+		case p < len(pattern) && pattern[p] == '?':
+			p++
+			n++
+			continue
+		case p+1 < len(pattern) && pattern[p] == '\\' && pattern[p+1] == name[n]:
+			p += 2
+			n++
+			continue
+		case p < len(pattern) && pattern[p] != '\\' && pattern[p] == name[n]:
+			p++
+			n++
+			continue
+		case star >= 0:
+			// * takes one more character
+			next++
+			p, n = star+1, next
+			continue
+		}
+		return false
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchPrefix reports whether the glob pattern may match some name starting with the prefix.
+// It may report true for the pattern which matches nothing at all, e.g. the one ending with \.
+func matchPrefix(pattern, prefix string) bool {
+	p := 0
+	for n := 0; n < len(prefix); n++ {
+		switch {
+		case p == len(pattern):
+			return false
+		case pattern[p] == '*':
+			// * takes the rest of the prefix
+			return true
+		case pattern[p] == '?':
+			p++
+		case pattern[p] == '\\':
+			if p+1 == len(pattern) || pattern[p+1] != prefix[n] {
+				return false
+			}
+			p += 2
+		case pattern[p] == prefix[n]:
+			p++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// KeyspacePrefix - the changes of the key are published to the channel named like __keyspace__:<key>
+const KeyspacePrefix = "__keyspace__:"
+
+// KeyspaceNotifier returns the function which publishes the key's new version to the key's channel,
+// version 0 means the key is removed. It never blocks, so it fits kvstorage.WithNotifier.
+// Unless there are the patterns matching the keyspace channels it takes O(1) for the key nobody watches,
+// no matter how many keys are watched.
+func (b *Bus) KeyspaceNotifier() func(key string, version uint64) {
+	return func(key string, version uint64) {
+		channel := KeyspacePrefix + key
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		if b.keyspacePatterns == 0 {
+			// the other patterns don't match the channel
+			for s := range b.channels[channel] {
+				b.deliver(s, Message{Channel: channel, Payload: strconv.FormatUint(version, 10)})
+			}
+			return
+		}
+		b.publish(channel, strconv.FormatUint(version, 10))
+	}
+}
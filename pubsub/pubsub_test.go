@@ -0,0 +1,195 @@
+package pubsub
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestBus_Publish(t *testing.T) {
+	bus := NewBus()
+	news, err := bus.Subscribe([]string{"news"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := bus.Subscribe(nil, []string{"*", "n?ws"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := bus.Publish("news", "hello"); got != 3 {
+		t.Errorf("Bus.Publish() = %v, want %v", got, 3)
+	}
+	if got := bus.Publish("sport", "goal"); got != 1 {
+		t.Errorf("Bus.Publish() = %v, want %v", got, 1)
+	}
+	want := []Message{{Channel: "news", Payload: "hello"}}
+	if got := receive(news); !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscription.C = %v, want %v", got, want)
+	}
+	// the order of the patterns is the order they were subscribed in
+	want = []Message{
+		{Channel: "news", Pattern: "*", Payload: "hello"},
+		{Channel: "news", Pattern: "n?ws", Payload: "hello"},
+		{Channel: "sport", Pattern: "*", Payload: "goal"},
+	}
+	if got := receive(all); !reflect.DeepEqual(got, want) {
+		t.Errorf("Subscription.C = %v, want %v", got, want)
+	}
+
+	news.Close()
+	news.Close()
+	if got := bus.Publish("news", "bye"); got != 2 {
+		t.Errorf("Bus.Publish() after Close() = %v, want %v", got, 2)
+	}
+	if _, ok := <-news.C; ok {
+		t.Error("Subscription.C is not closed after Close()")
+	}
+	if _, err := bus.Subscribe(nil, nil); err == nil {
+		t.Error("Bus.Subscribe() without channels error = nil")
+	}
+}
+
+func TestBus_Publish_overflow(t *testing.T) {
+	bus := NewBus(WithBufferSize(2))
+	slow, err := bus.Subscribe([]string{"news"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int{1, 1, 0, 0} {
+		if got := bus.Publish("news", "hello"); got != want {
+			t.Errorf("Bus.Publish() #%v = %v, want %v", i, got, want)
+		}
+	}
+	if !slow.Overflowed() {
+		t.Error("Subscription.Overflowed() = false, want true")
+	}
+	if got := len(receive(slow)); got != 2 {
+		t.Errorf("Subscription.C got %v messages, want %v", got, 2)
+	}
+	if got := bus.Subscribers(); got != 0 {
+		t.Errorf("Bus.Subscribers() = %v, want %v", got, 0)
+	}
+}
+
+func Test_match(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "news", name: "news", want: true},
+		{pattern: "news", name: "new", want: false},
+		{pattern: "*", name: "", want: true},
+		{pattern: "*", name: "anything", want: true},
+		{pattern: "news.*", name: "news.sport", want: true},
+		{pattern: "news.*", name: "news", want: false},
+		{pattern: "*.sport", name: "news.world.sport", want: true},
+		{pattern: "a*b*c", name: "aXbYbZc", want: true},
+		{pattern: "a*b*c", name: "aXbYc!", want: false},
+		{pattern: "h?llo", name: "hello", want: true},
+		{pattern: "h?llo", name: "hllo", want: false},
+		{pattern: `h\*llo`, name: "h*llo", want: true},
+		{pattern: `h\*llo`, name: "hello", want: false},
+		{pattern: `h\?`, name: "h?", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			if got := match(tt.pattern, tt.name); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchPrefix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{pattern: "*", want: true},
+		{pattern: "__keyspace__:user:*", want: true},
+		{pattern: "__key*", want: true},
+		{pattern: "__keyspace__?", want: true},
+		{pattern: `\_\_keyspace__:*`, want: true},
+		{pattern: "__keyspace__", want: false},
+		{pattern: "news.*", want: false},
+		{pattern: "?", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := matchPrefix(tt.pattern, KeyspacePrefix); got != tt.want {
+				t.Errorf("matchPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBus_KeyspaceNotifier(t *testing.T) {
+	bus := NewBus()
+	notify := bus.KeyspaceNotifier()
+	// every watch is the subscription of its own, like the ones of /ws
+	watches := make([]*Subscription, 10000)
+	for i := range watches {
+		s, err := bus.Subscribe([]string{KeyspacePrefix + strconv.Itoa(i)}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		watches[i] = s
+	}
+	news, err := bus.Subscribe(nil, []string{"news.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bus.keyspacePatterns != 0 {
+		t.Fatalf("Bus.keyspacePatterns = %v, want 0", bus.keyspacePatterns)
+	}
+	notify("42", 1)
+	notify("missing", 2)
+	for i, s := range watches {
+		got := receive(s)
+		if i != 42 && len(got) != 0 {
+			t.Fatalf("watch of %v got %v", i, got)
+		}
+		if want := []Message{{Channel: KeyspacePrefix + "42", Payload: "1"}}; i == 42 && !reflect.DeepEqual(got, want) {
+			t.Fatalf("watch of %v got %v, want %v", i, got, want)
+		}
+	}
+	if got := receive(news); len(got) != 0 {
+		t.Errorf("news.* got %v", got)
+	}
+
+	user, err := bus.Subscribe(nil, []string{KeyspacePrefix + "user:*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify("user:1", 0)
+	want := []Message{{Channel: KeyspacePrefix + "user:1", Pattern: KeyspacePrefix + "user:*", Payload: "0"}}
+	if got := receive(user); !reflect.DeepEqual(got, want) {
+		t.Errorf("%v got %v, want %v", KeyspacePrefix+"user:*", got, want)
+	}
+	user.Close()
+	for _, s := range watches {
+		s.Close()
+	}
+	if bus.keyspacePatterns != 0 || len(bus.channels) != 0 || len(bus.patterned) != 1 {
+		t.Errorf("Bus after Close() has %v keyspace patterns, %v channels, %v pattern subscribers, want 0, 0, 1",
+			bus.keyspacePatterns, len(bus.channels), len(bus.patterned))
+	}
+}
+
+// receive returns the messages the subscriber has got so far
+func receive(s *Subscription) []Message {
+	var msgs []Message
+	for {
+		select {
+		case msg, ok := <-s.C:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/proway2/kvserver/logger"
+)
+
+// runtimeStorage - the storage which settings are changed at runtime
+type runtimeStorage interface {
+	SetTTL(ttl time.Duration) error
+	SetMaxMemory(bytes uint64) error
+}
+
+// runtimeCleaner - the cleaner which TTL is changed at runtime
+type runtimeCleaner interface {
+	SetTTL(ttl uint64) error
+}
+
+// runtimeLogger - the log which level is changed at runtime
+type runtimeLogger interface {
+	SetLevel(level logger.Level)
+}
+
+// reloader re-reads the settings the same way they are read on start, see loadOptions,
+// and applies the changed ones which may be changed at runtime. These are the settings
+// of the default namespace, the others require the restart.
+type reloader struct {
+	mux     sync.Mutex // reloads are applied one by one
+	args    []string
+	environ []string
+	// the settings in effect, the ones which require the restart are kept as they were on start
+	settings map[string]interface{}
+	// appliers of the settings which may be changed at runtime by their names,
+	// they check the setting and return the function which applies it
+	appliers map[string]func(opts options) (func() error, error)
+}
+
+// newReloader returns the reloader of the settings read from fs on start with the command line
+// args and the environment
+func newReloader(fs *flag.FlagSet, args, environ []string,
+	stor runtimeStorage, cleaner runtimeCleaner, log runtimeLogger,
+) *reloader {
+	return &reloader{
+		args:     args,
+		environ:  environ,
+		settings: effectiveSettings(fs),
+		appliers: map[string]func(opts options) (func() error, error){
+			"ttl": func(opts options) (func() error, error) {
+				if opts.ttl == 0 {
+					return nil, errors.New("ttl: must be positive")
+				}
+				return func() error {
+					// the storage hides the expired elements, the cleaner purges them, both must know the new TTL
+					if err := stor.SetTTL(time.Duration(opts.ttl) * time.Second); err != nil {
+						return err
+					}
+					return cleaner.SetTTL(opts.ttl)
+				}, nil
+			},
+			"max-memory": func(opts options) (func() error, error) {
+				return func() error { return stor.SetMaxMemory(opts.maxMemory) }, nil
+			},
+			"log-level": func(opts options) (func() error, error) {
+				level, err := logger.ParseLevel(opts.logLevel)
+				if err != nil {
+					return nil, fmt.Errorf("log-level: %w", err)
+				}
+				return func() error {
+					log.SetLevel(level)
+					return nil
+				}, nil
+			},
+		},
+	}
+}
+
+// Reload re-reads the settings and applies the changed ones. It returns the names of the applied settings
+// and the ones which require the restart. Every changed setting is checked before any of them is applied,
+// so nothing is applied if any setting is not valid.
+func (rl *reloader) Reload() ([]string, []string, error) {
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts, err := loadOptions(fs, rl.args, rl.environ)
+	if err != nil {
+		return nil, nil, err
+	}
+	var changed, restart, problems []string
+	settings := effectiveSettings(fs)
+	applies := make(map[string]func() error)
+	for _, name := range sortedNames(settings) {
+		if settings[name] == rl.settings[name] {
+			continue
+		}
+		check, ok := rl.appliers[name]
+		if !ok {
+			restart = append(restart, name)
+			continue
+		}
+		apply, err := check(opts)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		changed = append(changed, name)
+		applies[name] = apply
+	}
+	if len(problems) != 0 {
+		return nil, nil, errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	// the checked settings don't fail, unless the storage or the cleaner is broken;
+	// the rest is applied anyway
+	var applied []string
+	for _, name := range changed {
+		if err := applies[name](); err != nil {
+			problems = append(problems, name+": "+err.Error())
+			continue
+		}
+		rl.settings[name] = settings[name]
+		applied = append(applied, name)
+	}
+	if len(problems) != 0 {
+		return applied, restart, errors.New("settings are not applied:\n  " + strings.Join(problems, "\n  "))
+	}
+	return applied, restart, nil
+}
+
+// reloadOnSignal reloads the settings every time the server gets SIGHUP, the result is logged
+func reloadOnSignal(rl *reloader, log *logger.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			applied, restart, err := rl.Reload()
+			if err != nil {
+				log.Error("Configuration is not reloaded", "err", err)
+				continue
+			}
+			log.Info("Configuration is reloaded",
+				"applied", strings.Join(applied, ","), "restart", strings.Join(restart, ","))
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/logger"
+	"github.com/proway2/kvserver/vacuum"
+)
+
+func Test_reloader_Reload(t *testing.T) {
+	path := writeConfig(t, "kvserver.yaml", "ttl: 60\nport: 9000\n")
+	args := []string{"-config", path, "-addr", "127.0.0.2"}
+	fs := newFlagSet()
+	opts, err := loadOptions(fs, args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := kvstorage.NewStorage(kvstorage.WithTTL(time.Duration(opts.ttl) * time.Second))
+	cleaner, err := vacuum.NewCleaner(storage, opts.ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg := logger.Discard()
+	rl := newReloader(fs, args, nil, storage, cleaner, lg)
+
+	tests := []struct {
+		name        string
+		config      string
+		wantApplied []string
+		wantRestart []string
+		wantErr     bool
+		wantTTL     time.Duration
+		wantDebug   bool
+	}{
+		{
+			name:    "Nothing is changed",
+			config:  "ttl: 60\nport: 9000\n",
+			wantTTL: time.Minute,
+		},
+		{
+			name: "Settings are changed",
+			// addr is given on the command line, it overrides the config file
+			config:      "ttl: 120\nport: 9001\nmax-memory: 1024\naddr: 127.0.0.3\nlog-level: debug\n",
+			wantApplied: []string{"log-level", "max-memory", "ttl"},
+			wantRestart: []string{"port"},
+			wantTTL:     2 * time.Minute,
+			wantDebug:   true,
+		},
+		{
+			name:        "Restart is still required",
+			config:      "ttl: 120\nport: 9001\nmax-memory: 1024\nlog-level: debug\n",
+			wantRestart: []string{"port"},
+			wantTTL:     2 * time.Minute,
+			wantDebug:   true,
+		},
+		{
+			// log-level goes before ttl, it's valid but must not be applied either
+			name:      "Later invalid setting",
+			config:    "ttl: 0\nlog-level: warn\n",
+			wantErr:   true,
+			wantTTL:   2 * time.Minute,
+			wantDebug: true,
+		},
+		{
+			name:      "Invalid settings are not applied",
+			config:    "ttl: 30\npurge-batch: 0\n",
+			wantErr:   true,
+			wantTTL:   2 * time.Minute,
+			wantDebug: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			applied, restart, err := rl.Reload()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reloader.Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(applied, tt.wantApplied) || !reflect.DeepEqual(restart, tt.wantRestart) {
+				t.Errorf("reloader.Reload() = %v, %v, want %v, %v", applied, restart, tt.wantApplied, tt.wantRestart)
+			}
+			if got := storage.TTL(); got != tt.wantTTL {
+				t.Errorf("storage's TTL = %v, want %v", got, tt.wantTTL)
+			}
+			if got := cleaner.TTL(); got != uint64(tt.wantTTL/time.Second) {
+				t.Errorf("cleaner's TTL = %v, want %v", got, tt.wantTTL)
+			}
+			if got := lg.Enabled(logger.Debug); got != tt.wantDebug {
+				t.Errorf("debug log enabled = %v, want %v", got, tt.wantDebug)
+			}
+		})
+	}
+}
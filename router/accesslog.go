@@ -0,0 +1,59 @@
+package router
+
+import (
+	"net/http"
+	"time"
+)
+
+// accessLogger - the log of the served requests
+type accessLogger interface {
+	Info(msg string, keyvals ...interface{})
+}
+
+// statusRecorder remembers the HTTP code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+// withAccessLog returns the handler which logs every request served by next, nothing is logged if log is nil
+func withAccessLog(log accessLogger, next http.HandlerFunc) http.HandlerFunc {
+	if log == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// the key is logged as it is, even if it's not valid
+		key, _ := getKeyFromRequest(r)
+		keyvals := []interface{}{
+			"method", r.Method,
+			"key", key,
+			"status", rec.status,
+			"latency", time.Since(start),
+			"client", r.RemoteAddr,
+		}
+		if name, _ := splitNamespace(r.URL.EscapedPath()); name != "" {
+			keyvals = append(keyvals, "namespace", name)
+		}
+		log.Info("request is served", keyvals...)
+	}
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+// recordingLogger keeps the records without the latency, it's different every time
+type recordingLogger struct {
+	records []string
+}
+
+func (l *recordingLogger) Info(msg string, keyvals ...interface{}) {
+	fields := []string{msg}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if _, ok := keyvals[i+1].(time.Duration); ok {
+			continue
+		}
+		fields = append(fields, fmt.Sprintf("%v=%v", keyvals[i], keyvals[i+1]))
+	}
+	l.records = append(l.records, strings.Join(fields, " "))
+}
+
+func TestWithAccessLog(t *testing.T) {
+	log := &recordingLogger{}
+	handler := GetURLrouter(kvstorage.NewStorage(), 60, WithAccessLog(log))
+	requests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: "POST", target: "/key/a", body: "value=1"},
+		{method: "GET", target: "/ns/team/key/?key=b"},
+		{method: "GET", target: "/key/"},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, req.target, strings.NewReader(req.body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "192.0.2.1:1234"
+		handler(httptest.NewRecorder(), r)
+	}
+	want := []string{
+		"request is served method=POST key=a status=200 client=192.0.2.1:1234",
+		"request is served method=GET key=b status=404 client=192.0.2.1:1234 namespace=team",
+		"request is served method=GET key= status=400 client=192.0.2.1:1234",
+	}
+	if !reflect.DeepEqual(log.records, want) {
+		t.Errorf("access log = %q, want %q", log.records, want)
+	}
+}
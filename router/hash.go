@@ -0,0 +1,152 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type hasher interface {
+	HSet(key, field, value string) (bool, error)
+	HGet(key, field string) (string, bool, error)
+	HGetAll(key string) (map[string]string, bool, error)
+	HDel(key string, fields ...string) (int, error)
+	HIncrBy(key, field string, incr int64) (int64, error)
+}
+
+const (
+	// POST form field name, the field of the hash to set, the value is in valueFormFieldName
+	hsetFormFieldName = "hset"
+	// POST form field name, the field of the hash to remove, may be repeated
+	hdelFormFieldName = "hdel"
+	// POST form field name, the field of the hash to increment by valueFormFieldName
+	hincrbyFormFieldName = "hincrby"
+	// GET query parameter name, the field of the hash to return
+	hgetQueryName = "hget"
+	// GET query parameter name, its presence returns all fields of the hash
+	hgetallQueryName = "hgetall"
+)
+
+// messages for the errors which are more specific than httpStatusCodeMessages
+const (
+	wrongTypeMessage     = "409 Key '%v' holds the wrong kind of value.\n"
+	notIntegerMessage    = "400 Value is not an integer or out of range.\n"
+	fieldNotFoundMessage = "404 There is no such field in the hash '%v'.\n"
+)
+
+// hashQueryFactory returns the function for the GET request to the hash, false if it's not such a request
+func hashQueryFactory(query url.Values) (typeRequest, bool) {
+	if _, ok := query[hgetQueryName]; ok {
+		return hgetRequest, true
+	}
+	if _, ok := query[hgetallQueryName]; ok {
+		return hgetallRequest, true
+	}
+	return nil, false
+}
+
+// hashFormFactory returns the function for the POST request to the hash, false if it's not such a request
+func hashFormFactory(form url.Values) (typeRequest, bool) {
+	if _, ok := form[hsetFormFieldName]; ok {
+		return hsetRequest, true
+	}
+	if _, ok := form[hdelFormFieldName]; ok {
+		return hdelRequest, true
+	}
+	if _, ok := form[hincrbyFormFieldName]; ok {
+		return hincrbyRequest, true
+	}
+	return nil, false
+}
+
+// hgetRequest returns the value of the hash's field
+func hgetRequest(req *request, query url.Values) (*reply, int) {
+	field := query.Get(hgetQueryName)
+	value, found, err := req.stor.HGet(req.key, field)
+	if err != nil {
+		return storageError(err)
+	}
+	if !found {
+		return &reply{text: fieldNotFoundMessage}, 404
+	}
+	return &reply{
+		text: value,
+		json: map[string]string{"key": req.key, "field": field, "value": value},
+	}, 200
+}
+
+// hgetallRequest returns all fields of the hash, one field and its value per line
+func hgetallRequest(req *request, query url.Values) (*reply, int) {
+	fields, found, err := req.stor.HGetAll(req.key)
+	if err != nil {
+		return storageError(err)
+	}
+	if !found {
+		return nil, 404
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var text strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&text, "%v\t%v\n", name, fields[name])
+	}
+	return &reply{
+		text: text.String(),
+		json: map[string]interface{}{"key": req.key, "fields": fields},
+	}, 200
+}
+
+// hsetRequest sets the field of the hash, the reply tells whether the field is new
+func hsetRequest(req *request, form url.Values) (*reply, int) {
+	field := form.Get(hsetFormFieldName)
+	created, err := req.stor.HSet(req.key, field, form.Get(valueFormFieldName))
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: boolText(created),
+		json: map[string]interface{}{"key": req.key, "field": field, "created": created},
+	}, 200
+}
+
+// hdelRequest removes the fields of the hash, the reply is the number of removed fields
+func hdelRequest(req *request, form url.Values) (*reply, int) {
+	deleted, err := req.stor.HDel(req.key, form[hdelFormFieldName]...)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: strconv.Itoa(deleted),
+		json: map[string]interface{}{"key": req.key, "deleted": deleted},
+	}, 200
+}
+
+// hincrbyRequest increments the field of the hash, the reply is the new value
+func hincrbyRequest(req *request, form url.Values) (*reply, int) {
+	field := form.Get(hincrbyFormFieldName)
+	incr, err := strconv.ParseInt(form.Get(valueFormFieldName), 10, 64)
+	if err != nil {
+		return &reply{text: notIntegerMessage}, 400
+	}
+	value, err := req.stor.HIncrBy(req.key, field, incr)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: strconv.FormatInt(value, 10),
+		json: map[string]interface{}{"key": req.key, "field": field, "value": value},
+	}, 200
+}
+
+// boolText returns "1" for true and "0" for false, the same as Redis does
+func boolText(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
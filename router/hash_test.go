@@ -0,0 +1,138 @@
+package router
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+func Test_closure_hash(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("string", correctValue); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		form     url.Values
+		json     bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Setting the field",
+			method:   "POST",
+			target:   "/key/user",
+			form:     url.Values{hsetFormFieldName: {"name"}, valueFormFieldName: {"John"}},
+			wantCode: 200,
+			wantBody: "1",
+		},
+		{
+			name:     "Updating the field",
+			method:   "POST",
+			target:   "/key/user",
+			form:     url.Values{hsetFormFieldName: {"name"}, valueFormFieldName: {"Jane"}},
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"created":false,"field":"name","key":"user"}` + "\n",
+		},
+		{
+			name:     "Incrementing the field",
+			method:   "POST",
+			target:   "/key/user",
+			form:     url.Values{hincrbyFormFieldName: {"visits"}, valueFormFieldName: {"3"}},
+			wantCode: 200,
+			wantBody: "3",
+		},
+		{
+			name:     "Increment is not an integer",
+			method:   "POST",
+			target:   "/key/user",
+			form:     url.Values{hincrbyFormFieldName: {"visits"}, valueFormFieldName: {"three"}},
+			wantCode: 400,
+			wantBody: notIntegerMessage,
+		},
+		{
+			name:     "Getting the field",
+			method:   "GET",
+			target:   "/key/user?hget=name",
+			wantCode: 200,
+			wantBody: "Jane",
+		},
+		{
+			name:     "Getting missing field",
+			method:   "GET",
+			target:   "/key/user?hget=age",
+			wantCode: 404,
+			wantBody: "404 There is no such field in the hash 'user'.\n",
+		},
+		{
+			name:     "Getting all fields",
+			method:   "GET",
+			target:   "/key/user?hgetall",
+			wantCode: 200,
+			wantBody: "name\tJane\nvisits\t3\n",
+		},
+		{
+			name:     "Getting all fields as JSON",
+			method:   "GET",
+			target:   "/key/user?hgetall",
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"fields":{"name":"Jane","visits":"3"},"key":"user"}` + "\n",
+		},
+		{
+			name:     "Getting the hash as string",
+			method:   "GET",
+			target:   "/key/user",
+			wantCode: 409,
+			wantBody: "409 Key 'user' holds the wrong kind of value.\n",
+		},
+		{
+			name:     "Hash operation on the string",
+			method:   "GET",
+			target:   "/key/string?hgetall",
+			json:     true,
+			wantCode: 409,
+			wantBody: `{"error":{"status":409,"code":"wrong_type","message":"Key 'string' holds the wrong kind of value."}}` + "\n",
+		},
+		{
+			name:     "Deleting the fields",
+			method:   "POST",
+			target:   "/key/user",
+			form:     url.Values{hdelFormFieldName: {"name", "visits", "age"}},
+			wantCode: 200,
+			wantBody: "2",
+		},
+		{
+			name:     "The hash is removed along with its last field",
+			method:   "GET",
+			target:   "/key/user?hgetall",
+			wantCode: 404,
+			wantBody: "404 There is no record in the storage for key 'user'.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.json {
+				r.Header.Set("Accept", jsonContentType)
+			}
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("urlHandler() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package router
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type queuer interface {
+	LPush(key string, values ...string) (int, error)
+	RPush(key string, values ...string) (int, error)
+	LPop(key string) (string, bool, error)
+	RPop(key string) (string, bool, error)
+	BLPop(ctx context.Context, key string, timeout time.Duration) (string, bool, error)
+	BRPop(ctx context.Context, key string, timeout time.Duration) (string, bool, error)
+	LRange(key string, start, stop int) ([]string, bool, error)
+	LTrim(key string, start, stop int) (bool, error)
+}
+
+const (
+	// POST form field name, the value to insert at the head of the list, may be repeated
+	lpushFormFieldName = "lpush"
+	// POST form field name, the value to append to the tail of the list, may be repeated
+	rpushFormFieldName = "rpush"
+	// POST form field name, its presence removes and returns the first value of the list
+	lpopFormFieldName = "lpop"
+	// POST form field name, its presence removes and returns the last value of the list
+	rpopFormFieldName = "rpop"
+	// POST form field name, secs to wait for the value to pop if the list is empty, 0 - no timeout
+	timeoutFormFieldName = "timeout"
+	// POST form field name, its presence keeps the values from start to stop only
+	ltrimFormFieldName = "ltrim"
+	// GET query parameter name, its presence returns the values from start to stop
+	lrangeQueryName = "lrange"
+	// the range of the list, the first and the last index, both inclusive, may be negative
+	startFormFieldName = "start"
+	stopFormFieldName  = "stop"
+)
+
+const badRangeMessage = "400 List's range must be integer start and stop indexes.\n"
+
+// listQueryFactory returns the function for the GET request to the list, false if it's not such a request
+func listQueryFactory(query url.Values) (typeRequest, bool) {
+	if _, ok := query[lrangeQueryName]; ok {
+		return lrangeRequest, true
+	}
+	return nil, false
+}
+
+// listFormFactory returns the function for the POST request to the list, false if it's not such a request
+func listFormFactory(form url.Values) (typeRequest, bool) {
+	if _, ok := form[lpushFormFieldName]; ok {
+		return lpushRequest, true
+	}
+	if _, ok := form[rpushFormFieldName]; ok {
+		return rpushRequest, true
+	}
+	if _, ok := form[lpopFormFieldName]; ok {
+		return lpopRequest, true
+	}
+	if _, ok := form[rpopFormFieldName]; ok {
+		return rpopRequest, true
+	}
+	if _, ok := form[ltrimFormFieldName]; ok {
+		return ltrimRequest, true
+	}
+	return nil, false
+}
+
+// lpushRequest inserts the values at the head of the list, the reply is the length of the list
+func lpushRequest(req *request, form url.Values) (*reply, int) {
+	return pushReply(req.key)(req.stor.LPush(req.key, form[lpushFormFieldName]...))
+}
+
+// rpushRequest appends the values to the tail of the list, the reply is the length of the list
+func rpushRequest(req *request, form url.Values) (*reply, int) {
+	return pushReply(req.key)(req.stor.RPush(req.key, form[rpushFormFieldName]...))
+}
+
+// lpopRequest removes and returns the first value of the list, it waits for the value if timeout is given
+func lpopRequest(req *request, form url.Values) (*reply, int) {
+	if _, ok := form[timeoutFormFieldName]; !ok {
+		return popReply(req.key)(req.stor.LPop(req.key))
+	}
+	timeout, ok := parseTimeout(form.Get(timeoutFormFieldName))
+	if !ok {
+		return nil, 400
+	}
+	return popReply(req.key)(req.stor.BLPop(req.r.Context(), req.key, timeout))
+}
+
+// rpopRequest removes and returns the last value of the list, it waits for the value if timeout is given
+func rpopRequest(req *request, form url.Values) (*reply, int) {
+	if _, ok := form[timeoutFormFieldName]; !ok {
+		return popReply(req.key)(req.stor.RPop(req.key))
+	}
+	timeout, ok := parseTimeout(form.Get(timeoutFormFieldName))
+	if !ok {
+		return nil, 400
+	}
+	return popReply(req.key)(req.stor.BRPop(req.r.Context(), req.key, timeout))
+}
+
+// lrangeRequest returns the values of the list from start to stop, one value per line
+func lrangeRequest(req *request, query url.Values) (*reply, int) {
+	start, stop, ok := parseRange(query)
+	if !ok {
+		return &reply{text: badRangeMessage}, 400
+	}
+	values, found, err := req.stor.LRange(req.key, start, stop)
+	if err != nil {
+		return storageError(err)
+	}
+	if !found {
+		return nil, 404
+	}
+	var text strings.Builder
+	for _, value := range values {
+		text.WriteString(value + "\n")
+	}
+	return &reply{
+		text: text.String(),
+		json: map[string]interface{}{"key": req.key, "values": values},
+	}, 200
+}
+
+// ltrimRequest keeps the values of the list from start to stop only
+func ltrimRequest(req *request, form url.Values) (*reply, int) {
+	start, stop, ok := parseRange(form)
+	if !ok {
+		return &reply{text: badRangeMessage}, 400
+	}
+	found, err := req.stor.LTrim(req.key, start, stop)
+	if err != nil {
+		return storageError(err)
+	}
+	return nil, foundCode(found, nil)
+}
+
+// pushReply returns the function making the reply for the push to the list
+func pushReply(key string) func(int, error) (*reply, int) {
+	return func(length int, err error) (*reply, int) {
+		if err != nil {
+			return storageError(err)
+		}
+		return &reply{
+			text: strconv.Itoa(length),
+			json: map[string]interface{}{"key": key, "length": length},
+		}, 200
+	}
+}
+
+// popReply returns the function making the reply for the pop from the list
+func popReply(key string) func(string, bool, error) (*reply, int) {
+	return func(value string, found bool, err error) (*reply, int) {
+		if err != nil {
+			return storageError(err)
+		}
+		if !found {
+			// the list is empty or the timeout has expired
+			return nil, 404
+		}
+		return &reply{
+			text: value,
+			json: map[string]string{"key": key, "value": value},
+		}, 200
+	}
+}
+
+// parseRange returns start and stop indexes of the list, the whole list by default
+func parseRange(form url.Values) (int, int, bool) {
+	start, stop := 0, -1
+	var err error
+	if v := form.Get(startFormFieldName); v != "" {
+		if start, err = strconv.Atoi(v); err != nil {
+			return 0, 0, false
+		}
+	}
+	if v := form.Get(stopFormFieldName); v != "" {
+		if stop, err = strconv.Atoi(v); err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, stop, true
+}
+
+// parseTimeout parses non-negative timeout, secs, fractions are allowed
+func parseTimeout(s string) (time.Duration, bool) {
+	secs, err := strconv.ParseFloat(s, 64)
+	// NaN is neither negative nor too long
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) || secs < 0 || secs > float64(1<<63-1)/float64(time.Second) {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
@@ -0,0 +1,176 @@
+package router
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+func Test_closure_list(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("string", correctValue); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		form     url.Values
+		json     bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Pushing to the tail",
+			method:   "POST",
+			target:   "/key/queue",
+			form:     url.Values{rpushFormFieldName: {"b", "c"}},
+			wantCode: 200,
+			wantBody: "2",
+		},
+		{
+			name:     "Pushing to the head",
+			method:   "POST",
+			target:   "/key/queue",
+			form:     url.Values{lpushFormFieldName: {"a"}},
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"key":"queue","length":3}` + "\n",
+		},
+		{
+			name:     "Getting the range",
+			method:   "GET",
+			target:   "/key/queue?lrange&start=1",
+			wantCode: 200,
+			wantBody: "b\nc\n",
+		},
+		{
+			name:     "Getting the range as JSON",
+			method:   "GET",
+			target:   "/key/queue?lrange&stop=-2",
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"key":"queue","values":["a","b"]}` + "\n",
+		},
+		{
+			name:     "Malformed range",
+			method:   "GET",
+			target:   "/key/queue?lrange&start=first",
+			wantCode: 400,
+			wantBody: badRangeMessage,
+		},
+		{
+			name:     "Popping from the head",
+			method:   "POST",
+			target:   "/key/queue",
+			form:     url.Values{lpopFormFieldName: {""}},
+			wantCode: 200,
+			wantBody: "a",
+		},
+		{
+			name:     "Popping from the tail",
+			method:   "POST",
+			target:   "/key/queue",
+			form:     url.Values{rpopFormFieldName: {""}},
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"key":"queue","value":"c"}` + "\n",
+		},
+		{
+			name:     "Negative timeout",
+			method:   "POST",
+			target:   "/key/queue",
+			form:     url.Values{lpopFormFieldName: {""}, timeoutFormFieldName: {"-1"}},
+			wantCode: 400,
+			wantBody: "400 Malformed request.\n",
+		},
+		{
+			name:     "NaN timeout",
+			method:   "POST",
+			target:   "/key/queue",
+			form:     url.Values{rpopFormFieldName: {""}, timeoutFormFieldName: {"NaN"}},
+			wantCode: 400,
+			wantBody: "400 Malformed request.\n",
+		},
+		{
+			name:     "Getting the list as string",
+			method:   "GET",
+			target:   "/key/queue",
+			wantCode: 409,
+			wantBody: "409 Key 'queue' holds the wrong kind of value.\n",
+		},
+		{
+			name:     "List operation on the string",
+			method:   "POST",
+			target:   "/key/string",
+			form:     url.Values{rpushFormFieldName: {"a"}},
+			wantCode: 409,
+			wantBody: "409 Key 'string' holds the wrong kind of value.\n",
+		},
+		{
+			name:     "Trimming the list away",
+			method:   "POST",
+			target:   "/key/queue",
+			form:     url.Values{ltrimFormFieldName: {""}, startFormFieldName: {"1"}},
+			wantCode: 200,
+			wantBody: "",
+		},
+		{
+			name:     "Popping from missing list",
+			method:   "POST",
+			target:   "/key/queue",
+			form:     url.Values{lpopFormFieldName: {""}, timeoutFormFieldName: {"0.01"}},
+			wantCode: 404,
+			wantBody: "404 There is no record in the storage for key 'queue'.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.json {
+				r.Header.Set("Accept", jsonContentType)
+			}
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("urlHandler() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_parseTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "Seconds", value: "5", want: 5 * time.Second, wantOK: true},
+		{name: "Fractions of second", value: "0.25", want: 250 * time.Millisecond, wantOK: true},
+		{name: "No timeout", value: "0", want: 0, wantOK: true},
+		{name: "Negative", value: "-1"},
+		{name: "Too long", value: "1e20"},
+		{name: "Not a number", value: "soon"},
+		{name: "NaN", value: "NaN"},
+		{name: "Infinity", value: "+Inf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseTimeout(tt.value)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseTimeout() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+type locker interface {
+	Acquire(key string, lease time.Duration) (kvstorage.Lease, error)
+	Renew(key, token string, lease time.Duration) (kvstorage.Lease, bool, error)
+	Release(key, token string) (bool, error)
+}
+
+const (
+	// POST form field name, its presence acquires the lock for leaseFormFieldName secs
+	lockFormFieldName = "lock"
+	// POST form field name, the owner's token of the lock to extend for leaseFormFieldName secs
+	renewFormFieldName = "renew"
+	// POST form field name, the owner's token of the lock to release
+	releaseFormFieldName = "release"
+	// POST form field name, the lock's lease, secs, fractions are allowed
+	leaseFormFieldName = "lease"
+)
+
+// messages for the errors of the locks
+const (
+	lockedMessage   = "409 Lock '%v' is held by another owner.\n"
+	notOwnerMessage = "409 Token doesn't match the owner of the lock '%v'.\n"
+	badLeaseMessage = "400 Lock's lease must be positive number of secs.\n"
+)
+
+// lockFormFactory returns the function for the POST request to the lock, false if it's not such a request
+func lockFormFactory(form url.Values) (typeRequest, bool) {
+	if _, ok := form[lockFormFieldName]; ok {
+		return lockRequest, true
+	}
+	if _, ok := form[renewFormFieldName]; ok {
+		return renewRequest, true
+	}
+	if _, ok := form[releaseFormFieldName]; ok {
+		return releaseRequest, true
+	}
+	return nil, false
+}
+
+// lockRequest acquires the lock if the key is not in the storage, the reply is the owner's token and fencing token
+func lockRequest(req *request, form url.Values) (*reply, int) {
+	lease, ok := parseLease(form.Get(leaseFormFieldName))
+	if !ok {
+		return &reply{text: badLeaseMessage}, 400
+	}
+	l, err := req.stor.Acquire(req.key, lease)
+	if err != nil {
+		return storageError(err)
+	}
+	return leaseReply(req, l), 200
+}
+
+// renewRequest extends the lease of the lock held by the owner of the token
+func renewRequest(req *request, form url.Values) (*reply, int) {
+	lease, ok := parseLease(form.Get(leaseFormFieldName))
+	if !ok {
+		return &reply{text: badLeaseMessage}, 400
+	}
+	l, found, err := req.stor.Renew(req.key, form.Get(renewFormFieldName), lease)
+	if err != nil {
+		return storageError(err)
+	}
+	if !found {
+		return nil, 404
+	}
+	return leaseReply(req, l), 200
+}
+
+// releaseRequest removes the lock held by the owner of the token
+func releaseRequest(req *request, form url.Values) (*reply, int) {
+	return foundReply(req.stor.Release(req.key, form.Get(releaseFormFieldName)))
+}
+
+// leaseReply returns the reply with the owner's token and fencing token separated by tab,
+// the Expires header tells when the lease is over
+func leaseReply(req *request, l kvstorage.Lease) *reply {
+	req.w.Header().Set("Expires", l.Expires.UTC().Format(http.TimeFormat))
+	return &reply{
+		text: fmt.Sprintf("%v\t%v", l.Token, l.Fence),
+		json: map[string]interface{}{
+			"key":     req.key,
+			"token":   l.Token,
+			"fence":   l.Fence,
+			"expires": l.Expires.UTC().Format(time.RFC3339Nano),
+		},
+	}
+}
+
+// parseLease parses positive lease, secs
+func parseLease(s string) (time.Duration, bool) {
+	lease, ok := parseTimeout(s)
+	return lease, ok && lease > 0
+}
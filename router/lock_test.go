@@ -0,0 +1,95 @@
+package router
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+func Test_closure_lock(t *testing.T) {
+	handler := GetURLrouter(kvstorage.NewStorage(), 60)
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/key/job", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler(rec, r)
+		return rec
+	}
+
+	rec := post(url.Values{lockFormFieldName: {""}, leaseFormFieldName: {"30"}})
+	parts := strings.Split(rec.Body.String(), "\t")
+	if rec.Code != 200 || len(parts) != 2 || rec.Header().Get("Expires") == "" {
+		t.Fatalf("lock code = %v, body = %q", rec.Code, rec.Body.String())
+	}
+	token := parts[0]
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Lease is missing",
+			form:     url.Values{lockFormFieldName: {""}},
+			wantCode: 400,
+			wantBody: badLeaseMessage,
+		},
+		{
+			name:     "The lock is held",
+			form:     url.Values{lockFormFieldName: {""}, leaseFormFieldName: {"30"}},
+			wantCode: 409,
+			wantBody: "409 Lock 'job' is held by another owner.\n",
+		},
+		{
+			name:     "Overwriting the lock",
+			form:     url.Values{valueFormFieldName: {correctValue}},
+			wantCode: 409,
+			wantBody: "409 Lock 'job' is held by another owner.\n",
+		},
+		{
+			name:     "Deleting the lock",
+			form:     url.Values{},
+			wantCode: 409,
+			wantBody: "409 Lock 'job' is held by another owner.\n",
+		},
+		{
+			name:     "Renewing with wrong token",
+			form:     url.Values{renewFormFieldName: {"stranger"}, leaseFormFieldName: {"30"}},
+			wantCode: 409,
+			wantBody: "409 Token doesn't match the owner of the lock 'job'.\n",
+		},
+		{
+			name:     "Renewing the lock",
+			form:     url.Values{renewFormFieldName: {token}, leaseFormFieldName: {"60"}},
+			wantCode: 200,
+			wantBody: rec.Body.String(),
+		},
+		{
+			name:     "Releasing the lock",
+			form:     url.Values{releaseFormFieldName: {token}},
+			wantCode: 200,
+			wantBody: "",
+		},
+		{
+			name:     "Releasing missing lock",
+			form:     url.Values{releaseFormFieldName: {token}},
+			wantCode: 404,
+			wantBody: "404 There is no record in the storage for key 'job'.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(tt.form)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("urlHandler() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
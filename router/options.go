@@ -0,0 +1,73 @@
+package router
+
+import (
+	"github.com/proway2/kvserver/clock"
+	"github.com/proway2/kvserver/tracing"
+)
+
+// default maximum length of the key, bytes
+const defaultMaxKeyLength = 1024
+
+// config - settings of the handlers returned by GetURLrouter
+type config struct {
+	maxKeyLength int
+	// origins of the pages allowed to open WebSocket connections besides the server's own one
+	allowedOrigins []string
+	// log of the served requests, nil if they are not logged
+	accessLog accessLogger
+	// tracer of the served requests and their storage operations, nil if they are not traced
+	tracer *tracing.Tracer
+	// the remaining lifetime of the elements is counted by it, it must be the storage's one
+	clock clock.Clock
+}
+
+// Option configures the handler, see GetURLrouter
+type Option func(*config)
+
+// WithMaxKeyLength sets the maximum length of the key, bytes. Requests with longer keys get code 400.
+func WithMaxKeyLength(length int) Option {
+	return func(c *config) {
+		if length > 0 {
+			c.maxKeyLength = length
+		}
+	}
+}
+
+// WithAllowedOrigins allows the pages from the origins like https://dashboard.example.com
+// to open WebSocket connections, "*" allows any origin. Only the server's own origin is allowed by default.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(c *config) {
+		c.allowedOrigins = append(c.allowedOrigins, origins...)
+	}
+}
+
+// WithAccessLog logs every request served by GetURLrouter: method, key, status, latency and client's address
+func WithAccessLog(log accessLogger) Option {
+	return func(c *config) {
+		c.accessLog = log
+	}
+}
+
+// WithTracer traces every request served by GetURLrouter and GetTxnRouter along with its storage operations,
+// the request's span is the child of the caller's one from traceparent header
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(c *config) {
+		c.tracer = tracer
+	}
+}
+
+// WithClock sets the time source the remaining lifetime of the elements is counted by,
+// it must be the same as the storage's one, see kvstorage.WithClock
+func WithClock(c clock.Clock) Option {
+	return func(conf *config) {
+		conf.clock = c
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{maxKeyLength: defaultMaxKeyLength, clock: clock.Real{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
@@ -0,0 +1,172 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/proway2/kvserver/pubsub"
+)
+
+type publisher interface {
+	Publish(channel, payload string) int
+}
+
+type subscriber interface {
+	Subscribe(channels, patterns []string) (*pubsub.Subscription, error)
+}
+
+type publisherSubscriber interface {
+	publisher
+	subscriber
+}
+
+const (
+	// The first part of URL's path for publishing, i.e. /publish/<channel>
+	publishPart = "publish"
+	// URL's path for subscribing
+	subscribePath = "subscribe"
+	// POST form field name, the message to publish
+	messageFormFieldName = "message"
+	// GET query parameter name, the channel to subscribe to, may be repeated
+	channelQueryName = "channel"
+	// GET query parameter name, the pattern of the channels to subscribe to, may be repeated
+	patternQueryName = "pattern"
+)
+
+// maxSubscriptions - channels and patterns of the subscriber, every published message is matched against them
+const maxSubscriptions = 1000
+
+// keepAliveInterval - the subscriber gets the comment if there were no messages for so long,
+// so the proxies don't drop the idle connection
+var keepAliveInterval = 15 * time.Second
+
+const (
+	badChannelMessage   = "400 Channel is missing or longer than %v bytes, URL must be like /publish/<channel>.\n"
+	badSubscribeMessage = "400 Subscription must be like /subscribe?channel=<channel>&pattern=<pattern> " +
+		"with 1 to 1000 channels and patterns.\n"
+	noStreamingMessage = "500 Streaming is not supported.\n"
+	// the changes of the keys are published by the storage only, the clients trust them
+	reservedChannelMessage = "400 Channel '%v' is reserved for the changes of the keys.\n"
+)
+
+// event - the message in the subscriber's stream
+type event struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern,omitempty"`
+	Message string `json:"message"`
+}
+
+// GetPubSubRouter returns HTTP handler which publishes the messages to the channels, POST /publish/<channel>,
+// and streams them to the subscribers as server-sent events, GET /subscribe?channel=<channel>&pattern=<pattern>.
+// The subscriber which doesn't read the messages in time gets the overflow event and is disconnected.
+func GetPubSubRouter(bus publisherSubscriber, opts ...Option) func(
+	w http.ResponseWriter, r *http.Request,
+) {
+	conf := newConfig(opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimLeft(r.URL.EscapedPath(), "/")
+		switch {
+		case r.Method == http.MethodPost && strings.HasPrefix(path, publishPart+"/"):
+			publishRequest(w, r, bus, strings.TrimPrefix(path, publishPart+"/"), conf)
+		case r.Method == http.MethodGet && strings.Trim(path, "/") == subscribePath:
+			subscribeRequest(w, r, bus, conf)
+		default:
+			writeError(w, r, 400, "") // Bad request
+		}
+	}
+}
+
+// publishRequest publishes the message and writes the number of the subscribers which have got it
+func publishRequest(w http.ResponseWriter, r *http.Request, bus publisher, escaped string, conf *config) {
+	channel, err := url.PathUnescape(escaped)
+	if err != nil || len(channel) == 0 || len(channel) > conf.maxKeyLength {
+		writeErrorMessage(w, r, 400, badChannelMessage, strconv.Itoa(conf.maxKeyLength))
+		return
+	}
+	if strings.HasPrefix(channel, pubsub.KeyspacePrefix) {
+		writeErrorMessage(w, r, 400, reservedChannelMessage, channel)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, r, 400, "")
+		return
+	}
+	message, ok := r.PostForm[messageFormFieldName]
+	if !ok {
+		writeError(w, r, 400, "")
+		return
+	}
+	receivers := bus.Publish(channel, message[0])
+	writeReply(w, r, channel, &reply{
+		text: strconv.Itoa(receivers),
+		json: map[string]interface{}{"channel": channel, "receivers": receivers},
+	})
+}
+
+// subscribeRequest streams the messages to the subscriber until it disconnects
+func subscribeRequest(w http.ResponseWriter, r *http.Request, bus subscriber, conf *config) {
+	query := r.URL.Query()
+	channels, patterns := query[channelQueryName], query[patternQueryName]
+	if !validSubscription(channels, patterns, conf) {
+		writeErrorMessage(w, r, 400, badSubscribeMessage, "")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrorMessage(w, r, 500, noStreamingMessage, "")
+		return
+	}
+	sub, err := bus.Subscribe(channels, patterns)
+	if err != nil {
+		writeError(w, r, 500, "")
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	// the client knows it's subscribed once it gets the headers
+	flusher.Flush()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case msg, ok := <-sub.C:
+			if !ok {
+				if sub.Overflowed() {
+					fmt.Fprint(w, "event: overflow\ndata: {}\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			data, _ := json.Marshal(event{Channel: msg.Channel, Pattern: msg.Pattern, Message: msg.Payload})
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// validSubscription reports whether the channels and patterns are fine to subscribe to
+func validSubscription(channels, patterns []string, conf *config) bool {
+	names := append(append([]string{}, channels...), patterns...)
+	if len(names) == 0 || len(names) > maxSubscriptions {
+		return false
+	}
+	for _, name := range names {
+		if len(name) == 0 || len(name) > conf.maxKeyLength {
+			return false
+		}
+	}
+	return true
+}
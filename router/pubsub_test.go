@@ -0,0 +1,180 @@
+package router
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/proway2/kvserver/pubsub"
+)
+
+func TestGetPubSubRouter_publish(t *testing.T) {
+	bus := pubsub.NewBus()
+	if _, err := bus.Subscribe([]string{"news/world"}, []string{"news/*"}); err != nil {
+		t.Fatal(err)
+	}
+	watcher, err := bus.Subscribe([]string{pubsub.KeyspacePrefix + "k"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := GetPubSubRouter(bus, WithMaxKeyLength(16))
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		form     url.Values
+		json     bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Publishing to the subscribers",
+			method:   "POST",
+			target:   "/publish/news%2Fworld",
+			form:     url.Values{messageFormFieldName: {"hello"}},
+			wantCode: 200,
+			wantBody: "2",
+		},
+		{
+			name:     "Publishing without subscribers",
+			method:   "POST",
+			target:   "/publish/sport",
+			form:     url.Values{messageFormFieldName: {"goal"}},
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"channel":"sport","receivers":0}` + "\n",
+		},
+		{
+			name:     "Message is missing",
+			method:   "POST",
+			target:   "/publish/sport",
+			wantCode: 400,
+			wantBody: "400 Malformed request.\n",
+		},
+		{
+			name:     "Channel is missing",
+			method:   "POST",
+			target:   "/publish/",
+			form:     url.Values{messageFormFieldName: {"goal"}},
+			wantCode: 400,
+			wantBody: "400 Channel is missing or longer than 16 bytes, URL must be like /publish/<channel>.\n",
+		},
+		{
+			name:     "Channel is too long",
+			method:   "POST",
+			target:   "/publish/" + strings.Repeat("a", 17),
+			form:     url.Values{messageFormFieldName: {"goal"}},
+			wantCode: 400,
+			wantBody: "400 Channel is missing or longer than 16 bytes, URL must be like /publish/<channel>.\n",
+		},
+		{
+			name:     "Spoofing the change of the key",
+			method:   "POST",
+			target:   "/publish/__keyspace__:k",
+			form:     url.Values{messageFormFieldName: {"0"}},
+			json:     true,
+			wantCode: 400,
+			wantBody: `{"error":{"status":400,"code":"reserved_channel",` +
+				`"message":"Channel '__keyspace__:k' is reserved for the changes of the keys."}}` + "\n",
+		},
+		{
+			name:     "Incorrect HTTP method",
+			method:   "GET",
+			target:   "/publish/sport",
+			wantCode: 400,
+			wantBody: "400 Malformed request.\n",
+		},
+		{
+			name:     "Nothing to subscribe to",
+			method:   "GET",
+			target:   "/subscribe",
+			wantCode: 400,
+			wantBody: badSubscribeMessage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.json {
+				req.Header.Set("Accept", jsonContentType)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if w.Code != tt.wantCode || w.Body.String() != tt.wantBody {
+				t.Errorf("handler() = %v %q, want %v %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+	// the watchers of the key get nothing from the clients
+	select {
+	case msg := <-watcher.C:
+		t.Errorf("the watcher of the key got the spoofed change %+v", msg)
+	default:
+	}
+}
+
+func TestGetPubSubRouter_subscribe(t *testing.T) {
+	bus := pubsub.NewBus(pubsub.WithBufferSize(1))
+	server := httptest.NewServer(http.HandlerFunc(GetPubSubRouter(bus)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/subscribe?channel=news&pattern=sport.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /subscribe = %v %q, want 200 text/event-stream",
+			resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	stream := bufio.NewReader(resp.Body)
+
+	// the subscriber is registered before the headers are sent
+	if got := bus.Publish("news", "line 1\nline 2"); got != 1 {
+		t.Fatalf("Bus.Publish() = %v, want %v", got, 1)
+	}
+	want := "event: message\ndata: {\"channel\":\"news\",\"message\":\"line 1\\nline 2\"}\n\n"
+	if got := readEvent(t, stream); got != want {
+		t.Errorf("event = %q, want %q", got, want)
+	}
+	bus.Publish("sport.football", "goal")
+	want = "event: message\ndata: {\"channel\":\"sport.football\",\"pattern\":\"sport.*\",\"message\":\"goal\"}\n\n"
+	if got := readEvent(t, stream); got != want {
+		t.Errorf("event = %q, want %q", got, want)
+	}
+	bus.Publish("weather", "rain")
+
+	// the messages published faster than the subscriber reads them overflow its buffer
+	for bus.Subscribers() != 0 {
+		bus.Publish("news", "flood")
+	}
+	for {
+		got := readEvent(t, stream)
+		if strings.HasPrefix(got, "event: overflow\n") {
+			break
+		}
+		if !strings.Contains(got, "flood") {
+			t.Fatalf("event = %q, want the message or the overflow", got)
+		}
+	}
+}
+
+// readEvent returns the next event from the stream of server-sent events
+func readEvent(t *testing.T, stream *bufio.Reader) string {
+	var event strings.Builder
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the event: %v", err)
+		}
+		event.WriteString(line)
+		if line == "\n" {
+			return event.String()
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// reloader - the server which re-reads its settings at runtime. It returns the names of the changed settings
+// which are applied and the ones which require the restart.
+type reloader interface {
+	Reload() (applied, restart []string, err error)
+}
+
+const reloadFailedMessage = "400 Configuration is not reloaded: %v\n"
+
+// reloadResult - JSON response of the reload
+type reloadResult struct {
+	Applied []string `json:"applied"`
+	Restart []string `json:"restart"`
+}
+
+// GetReloadAdminRouter returns HTTP handler which reloads the server's settings on POST request.
+// The response lists the changed settings, one per line: either "applied <name>" or "restart <name>"
+// for the ones which are not applied until the server is restarted. Invalid settings are not applied at all.
+func GetReloadAdminRouter(rl reloader) func(
+	w http.ResponseWriter, r *http.Request,
+) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, r, 400, "") // Bad request
+			return
+		}
+		applied, restart, err := rl.Reload()
+		if err != nil {
+			writeErrorMessage(w, r, 400, reloadFailedMessage, err.Error())
+			return
+		}
+		if wantsJSON(r) {
+			res := reloadResult{Applied: applied, Restart: restart}
+			// JSON arrays are never null
+			if res.Applied == nil {
+				res.Applied = []string{}
+			}
+			if res.Restart == nil {
+				res.Restart = []string{}
+			}
+			writeJSON(w, 200, res)
+			return
+		}
+		w.WriteHeader(200)
+		for _, name := range applied {
+			fmt.Fprintln(w, "applied", name)
+		}
+		for _, name := range restart {
+			fmt.Fprintln(w, "restart", name)
+		}
+	}
+}
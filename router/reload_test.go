@@ -0,0 +1,82 @@
+package router
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeReloader returns the same result of every reload
+type fakeReloader struct {
+	applied, restart []string
+	err              error
+}
+
+func (f *fakeReloader) Reload() ([]string, []string, error) {
+	return f.applied, f.restart, f.err
+}
+
+func TestGetReloadAdminRouter(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		reloader *fakeReloader
+		json     bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Changed settings",
+			method:   "POST",
+			reloader: &fakeReloader{applied: []string{"max-memory", "ttl"}, restart: []string{"port"}},
+			wantCode: 200,
+			wantBody: "applied max-memory\napplied ttl\nrestart port\n",
+		},
+		{
+			name:     "Changed settings as JSON",
+			method:   "POST",
+			reloader: &fakeReloader{applied: []string{"ttl"}},
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"applied":["ttl"],"restart":[]}` + "\n",
+		},
+		{
+			name:     "Nothing is changed",
+			method:   "POST",
+			reloader: &fakeReloader{},
+			wantCode: 200,
+			wantBody: "",
+		},
+		{
+			name:     "Invalid configuration",
+			method:   "POST",
+			reloader: &fakeReloader{err: errors.New("ttl: must be positive")},
+			json:     true,
+			wantCode: 400,
+			wantBody: `{"error":{"status":400,"code":"bad_configuration","message":"Configuration is not reloaded: ttl: must be positive"}}` + "\n",
+		},
+		{
+			name:     "Incorrect HTTP method",
+			method:   "GET",
+			reloader: &fakeReloader{},
+			wantCode: 400,
+			wantBody: "400 Malformed request.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/admin/reload", nil)
+			if tt.json {
+				r.Header.Set("Accept", jsonContentType)
+			}
+			GetReloadAdminRouter(tt.reloader)(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("GetReloadAdminRouter() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("GetReloadAdminRouter() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
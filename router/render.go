@@ -0,0 +1,191 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/proway2/kvserver/element"
+)
+
+const jsonContentType = "application/json"
+
+// stable error codes for JSON responses, clients may rely on these
+var httpStatusErrorCodes = map[int]string{
+	400: "bad_request",
+	404: "not_found",
+	409: "conflict",
+	500: "internal_error",
+	507: "insufficient_storage",
+}
+
+// stable error codes of the messages telling what exactly is wrong, the other messages
+// get the code of their HTTP status, see errorCode
+var messageErrorCodes = map[string]string{
+	keyMissingMessage:           "key_missing",
+	keyAmbiguousMessage:         "key_ambiguous",
+	keyTooLongMessage:           "key_too_long",
+	namespaceNotFoundMessage:    "namespace_not_found",
+	versionMismatchMessage:      "version_mismatch",
+	keyExistsMessage:            "key_exists",
+	wrongTypeMessage:            "wrong_type",
+	notIntegerMessage:           "not_integer",
+	notFloatMessage:             "not_float",
+	fieldNotFoundMessage:        "field_not_found",
+	badRangeMessage:             "bad_range",
+	lockedMessage:               "locked",
+	notOwnerMessage:             "not_owner",
+	badLeaseMessage:             "bad_lease",
+	badTxnMessage:               "bad_transaction",
+	badWatchMessage:             "bad_watch",
+	watchedChangedMessage:       "watch_conflict",
+	badChannelMessage:           "bad_channel",
+	badSubscribeMessage:         "bad_subscription",
+	reservedChannelMessage:      "reserved_channel",
+	noStreamingMessage:          "streaming_unsupported",
+	badWebSocketMessage:         "bad_message",
+	webSocketKeyMissingMessage:  "key_missing",
+	tooManyWatchesMessage:       "too_many_watches",
+	badWebSocketSubscription:    "bad_subscription",
+	notSubscribedMessage:        "not_subscribed",
+	tooManySubscriptionsMessage: "too_many_subscriptions",
+	reloadFailedMessage:         "bad_configuration",
+}
+
+// errorCode returns the stable code of the error for JSON responses, msg is the message as it's defined,
+// before anything is substituted into it
+func errorCode(code int, msg string) string {
+	if errCode, ok := messageErrorCodes[msg]; ok {
+		return errCode
+	}
+	return httpStatusErrorCodes[code]
+}
+
+// value - element's representation in JSON response
+type value struct {
+	Key     string     `json:"key"`
+	Value   string     `json:"value"`
+	Created time.Time  `json:"created"`
+	Updated time.Time  `json:"updated"`
+	Expires *time.Time `json:"expires,omitempty"`
+	TTL     *int64     `json:"ttl,omitempty"` // remaining lifetime, secs.
+	Version uint64     `json:"version"`
+}
+
+// reply - successful response, either plain text or JSON. Unsuccessful response has the error message as text.
+type reply struct {
+	text string
+	json interface{}
+}
+
+// errorBody - JSON response for any HTTP code other than 200
+type errorBody struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// newValue returns the element's representation, now is the time its remaining lifetime is counted from
+func newValue(key string, elem element.Element, ttl time.Duration, now time.Time) *value {
+	val := &value{
+		Key:     key,
+		Value:   elem.Val,
+		Created: elem.Created,
+		Updated: elem.Timestamp,
+		Version: elem.Version,
+	}
+	if expires, ok := elem.ExpiresAt(ttl); ok {
+		// remaining lifetime is rounded up, the element is still in the storage
+		remaining := int64((expires.Sub(now) + time.Second - 1) / time.Second)
+		if remaining < 0 {
+			remaining = 0
+		}
+		val.Expires, val.TTL = &expires, &remaining
+	}
+	return val
+}
+
+// wantsJSON reports whether client accepts JSON response, plain text is the default
+func wantsJSON(r *http.Request) bool {
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil || mediaType != jsonContentType {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				// client explicitly refuses JSON
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// valueReply returns the reply with element's value
+func valueReply(val *value) *reply {
+	return &reply{text: val.Value, json: val}
+}
+
+// writeReply writes successful response, rep is nil if there is nothing to return
+func writeReply(w http.ResponseWriter, r *http.Request, key string, rep *reply) {
+	if wantsJSON(r) {
+		if rep == nil {
+			writeJSON(w, 200, map[string]string{"key": key})
+			return
+		}
+		writeJSON(w, 200, rep.json)
+		return
+	}
+	w.WriteHeader(200)
+	if rep != nil {
+		fmt.Fprint(w, rep.text)
+	}
+}
+
+// writeError writes error response for the HTTP code
+func writeError(w http.ResponseWriter, r *http.Request, code int, key string) {
+	writeErrorMessage(w, r, code, httpStatusCodeMessages[code], key)
+}
+
+// writeErrorMessage writes error response with the message, key is substituted into it
+func writeErrorMessage(w http.ResponseWriter, r *http.Request, code int, msg, key string) {
+	writeCodedError(w, r, code, errorCode(code, msg), msg, key)
+}
+
+// writeCodedError writes error response with the message and the stable code of the error, see errorCode.
+// Key is substituted into the message.
+func writeCodedError(w http.ResponseWriter, r *http.Request, code int, errCode, msg, key string) {
+	if strings.Contains(msg, "%v") {
+		msg = fmt.Sprintf(msg, key)
+	}
+	if !wantsJSON(r) {
+		w.WriteHeader(code)
+		fmt.Fprint(w, msg)
+		return
+	}
+	var body errorBody
+	body.Error.Status = code
+	body.Error.Code = errCode
+	body.Error.Message = errorText(code, msg)
+	writeJSON(w, code, body)
+}
+
+// errorText returns the message for JSON response, plain text message starts with the HTTP code,
+// JSON has it in a separate field
+func errorText(code int, msg string) string {
+	return strings.TrimSpace(strings.TrimPrefix(msg, strconv.Itoa(code)))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,164 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/clock/clocktest"
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+func Test_wantsJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		want   bool
+	}{
+		{name: "No Accept header", accept: "", want: false},
+		{name: "Plain text", accept: "text/plain", want: false},
+		{name: "Any media type", accept: "*/*", want: false},
+		{name: "JSON", accept: "application/json", want: true},
+		{name: "JSON among others", accept: "text/html, application/json;q=0.9", want: true},
+		{name: "JSON is refused", accept: "application/json;q=0, text/plain", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/key/"+correctKey, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if got := wantsJSON(r); got != tt.want {
+				t.Errorf("wantsJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_closure_JSON(t *testing.T) {
+	clk := clocktest.NewFake(time.Unix(1000, 0))
+	storage := kvstorage.NewStorage(kvstorage.WithClock(clk), kvstorage.WithTTL(time.Minute))
+	if err := storage.Set(correctKey, correctValue); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60, WithClock(clk))
+
+	t.Run("Value", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/key/"+correctKey, nil)
+		r.Header.Set("Accept", jsonContentType)
+		handler(rec, r)
+		if rec.Code != 200 || rec.Header().Get("Content-Type") != jsonContentType {
+			t.Fatalf("urlHandler() code = %v, Content-Type = %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		var got value
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Key != correctKey || got.Value != correctValue || got.Version != 1 {
+			t.Errorf("urlHandler() = %+v", got)
+		}
+		if got.TTL == nil || *got.TTL != 60 || got.Expires == nil {
+			t.Errorf("urlHandler() ttl = %v, expires = %v, want 60", got.TTL, got.Expires)
+		}
+	})
+
+	t.Run("Remaining lifetime by the storage's clock", func(t *testing.T) {
+		clk.Advance(20*time.Second + time.Millisecond)
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/key/"+correctKey, nil)
+		r.Header.Set("Accept", jsonContentType)
+		handler(rec, r)
+		var got value
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		// the remaining lifetime is rounded up
+		if got.TTL == nil || *got.TTL != 40 {
+			t.Errorf("urlHandler() ttl = %v, want 40", got.TTL)
+		}
+	})
+
+	errTests := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "Key is not found", method: "GET", path: "/key/xxx", wantCode: 404, wantErr: "not_found"},
+		{name: "Malformed URL", method: "GET", path: "/key/", wantCode: 400, wantErr: "key_missing"},
+		{name: "Incorrect HTTP method (verb)", method: "PUT", path: "/key/xxx", wantCode: 400, wantErr: "bad_request"},
+		{name: "Deleting missing key", method: "POST", path: "/key/xxx", wantCode: 404, wantErr: "not_found"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Accept", jsonContentType)
+			handler(rec, r)
+			var got errorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantCode || got.Error.Status != tt.wantCode || got.Error.Code != tt.wantErr || got.Error.Message == "" {
+				t.Errorf("urlHandler() code = %v, body = %+v, want %v %v", rec.Code, got, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_errorCode(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set(correctKey, correctValue); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.HSet("hash", "field", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Acquire("lock", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60, WithMaxKeyLength(16))
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		form     url.Values
+		wantCode int
+		wantErr  string
+	}{
+		{name: "Key is too long", method: "GET", path: "/key/" + strings.Repeat("k", 17), wantCode: 400, wantErr: "key_too_long"},
+		{name: "Key is ambiguous", method: "GET", path: "/key/a?key=b", wantCode: 400, wantErr: "key_ambiguous"},
+		{name: "Wrong kind of value", method: "GET", path: "/key/hash", wantCode: 409, wantErr: "wrong_type"},
+		{name: "Key exists", method: "POST", path: "/key/" + correctKey,
+			form: url.Values{"value": {"1"}, "nx": {""}}, wantCode: 409, wantErr: "key_exists"},
+		{name: "Lock is held", method: "POST", path: "/key/lock", form: url.Values{"value": {"1"}}, wantCode: 409, wantErr: "locked"},
+		{name: "Not the owner of the lock", method: "POST", path: "/key/lock",
+			form: url.Values{"release": {"token"}}, wantCode: 409, wantErr: "not_owner"},
+		{name: "Not an integer", method: "POST", path: "/key/hash",
+			form: url.Values{"hincrby": {"field"}, "value": {"x"}}, wantCode: 400, wantErr: "not_integer"},
+		// the errors without their own code get the one of HTTP status
+		{name: "Both nx and xx", method: "POST", path: "/key/" + correctKey,
+			form: url.Values{"value": {"1"}, "nx": {""}, "xx": {""}}, wantCode: 400, wantErr: "bad_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Accept", jsonContentType)
+			handler(rec, r)
+			var got errorBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantCode || got.Error.Code != tt.wantErr {
+				t.Errorf("urlHandler() code = %v, body = %+v, want %v %v", rec.Code, got, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,78 +1,400 @@
 package router
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/proway2/kvserver/clock"
+	"github.com/proway2/kvserver/element"
+	"github.com/proway2/kvserver/kvstorage"
 )
 
 type writer interface {
-	Set(key, value string) error
+	Set(key, value string, mode ...kvstorage.SetMode) error
 	Delete(key string) (bool, error)
+	Touch(key string) (bool, error)
+	ExpireAt(key string, expires time.Time) (bool, error)
+	Persist(key string) (bool, error)
 }
 
 type reader interface {
-	Get(key string) ([]byte, error)
+	Lookup(key string) (element.Element, bool, error)
+}
+
+// ttler - the storage which tells element's lifetime, it may be changed at runtime
+type ttler interface {
+	TTL() time.Duration
+}
+
+type lister interface {
+	Keys() ([]string, error)
 }
 
 type readerWriter interface {
 	reader
 	writer
+	hasher
+	queuer
+	setter
+	ranker
+	locker
+}
+
+// Handlers - HTTP handlers serving the storage
+type Handlers struct {
+	Key  http.HandlerFunc // the elements, see GetURLrouter
+	Keys http.HandlerFunc // listing the keys, see GetKeysRouter
+	Txn  http.HandlerFunc // the transactions, see GetTxnRouter
+}
+
+// namespaces - named storages, each one is served by its own handlers
+type namespaces interface {
+	Handlers(name string) (Handlers, bool)
+	Create(name string, ttl, maxMemory uint64) (bool, error)
+	Drop(name string) (bool, error)
+	Names() []string
 }
 
 const (
 	// POST form field name (contains data for storing the key)
 	valueFormFieldName = "value"
+	// POST form field name, its presence resets element's lifetime without changing the value
+	touchFormFieldName = "touch"
+	// POST form field name, absolute expiration time of the element: Unix time, secs or RFC 3339
+	expireAtFormFieldName = "expireat"
+	// POST form field name, its presence makes the element never expire
+	persistFormFieldName = "persist"
+	// POST form field name, its presence stores the value only if the key is not in the storage
+	nxFormFieldName = "nx"
+	// POST form field name, its presence stores the value only if the key is in the storage
+	xxFormFieldName = "xx"
 	// The first part of the URL's path must be like
 	firstPart = "key"
+	// URL's query parameter name, the key may be passed in it instead of the path, i.e. /key/?key=<key>
+	keyQueryName = "key"
+	// URL's path for listing the keys
+	keysPath = "keys"
+	// The first part of the URL's path for the namespace, i.e. /ns/<name>/key/<key>
+	namespacePart = "ns"
+	// URL's path for managing the namespaces
+	namespacesAdminPath = "admin/ns"
+	// POST form field name, namespace's element lifetime, secs
+	ttlFormFieldName = "ttl"
+	// POST form field name, namespace's memory quota, bytes
+	maxMemoryFormFieldName = "maxmemory"
+	// POST form field name, its presence drops the namespace with all its elements
+	dropFormFieldName = "drop"
 )
 
+const (
+	namespaceNotFoundMessage = "404 There is no namespace '%v'.\n"
+	versionMismatchMessage   = "409 Version of key '%v' doesn't match.\n"
+	keyExistsMessage         = "409 '%v' already exists.\n"
+)
+
+// messages telling why the key is not valid
+const (
+	keyMissingMessage   = "400 Key is missing, URL must be like /key/<key> or /key/?key=<key>.\n"
+	keyAmbiguousMessage = "400 Key is given in both URL's path and query.\n"
+	keyTooLongMessage   = "400 Key is longer than %v bytes.\n"
+)
+
+// request - everything the HTTP method handler needs to process the request
+type request struct {
+	stor readerWriter
+	key  string
+	ttl  time.Duration // element's lifetime in the storage
+	// the storage's time source, see WithClock
+	clock clock.Clock
+	w     http.ResponseWriter
+	r     *http.Request
+}
+
 var httpStatusCodeMessages = map[int]string{
 	200: "",
 	400: "400 Malformed request.\n",
 	404: "404 There is no record in the storage for key '%v'.\n",
+	409: keyExistsMessage,
 	500: "500 Internal storage error.\n",
+	507: "507 There is no room in the storage for key '%v'.\n",
 }
 
 // GetURLrouter - возвращает функцию маршрутизатор HTTP запросов в зависимости от типа.
-func GetURLrouter(stor readerWriter) func(
+// ttl is the element's lifetime in the storage, secs. It is used to tell clients when the element expires,
+// unless the storage tells its current TTL itself.
+func GetURLrouter(stor readerWriter, ttl uint64, opts ...Option) func(
 	w http.ResponseWriter, r *http.Request,
 ) {
+	conf := newConfig(opts)
 	// замыкание необходимо для оборачивания локальных переменных в обработчик URL
-	return func(w http.ResponseWriter, r *http.Request) {
-		keyName, ok := getKeyFromURL(r.URL.Path)
-		if !ok {
-			w.WriteHeader(400) // Bad request
-			fmt.Fprint(w, httpStatusCodeMessages[400])
+	return withAccessLog(conf.accessLog, withTracing(conf.tracer, keyRoute, func(w http.ResponseWriter, r *http.Request) {
+		keyName, msg := getKeyFromRequest(r)
+		if msg == "" && len(keyName) > conf.maxKeyLength {
+			writeErrorMessage(w, r, 400, keyTooLongMessage, strconv.Itoa(conf.maxKeyLength))
+			return
+		}
+		if msg != "" {
+			writeErrorMessage(w, r, 400, msg, "") // Bad request
 			return
 		}
 		reqHandler, isHandlerExists := requestFactory(r.Method)
 		if !isHandlerExists {
-			w.WriteHeader(400) // Bad request
-			fmt.Fprint(w, httpStatusCodeMessages[400])
+			writeError(w, r, 400, keyName) // Bad request
+			return
+		}
+		var view readerWriter = stor
+		if traced, ok := tracedStorage(stor, r, conf); ok {
+			view = traced
+		}
+		rep, code := reqHandler(&request{
+			stor:  view,
+			key:   keyName,
+			ttl:   lifetime(stor, time.Duration(ttl)*time.Second),
+			clock: conf.clock,
+			w:     w,
+			r:     r,
+		})
+		if code != 200 && rep != nil {
+			writeErrorMessage(w, r, code, rep.text, keyName)
+			return
+		}
+		if code != 200 {
+			writeError(w, r, code, keyName)
 			return
 		}
-		val, code := reqHandler(stor, keyName, r)
-		w.WriteHeader(code)
-		fmt.Fprint(w, val)
+		writeReply(w, r, keyName, rep)
+	}))
+}
+
+// GetKeysRouter returns HTTP handler which lists all keys in the storage, one key per line or JSON array.
+func GetKeysRouter(stor lister) func(
+	w http.ResponseWriter, r *http.Request,
+) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, path := splitNamespace(r.URL.Path)
+		if r.Method != http.MethodGet || strings.Trim(path, "/") != keysPath {
+			writeError(w, r, 400, "") // Bad request
+			return
+		}
+		keys, err := stor.Keys()
+		if err != nil {
+			writeError(w, r, 500, "")
+			return
+		}
+		if wantsJSON(r) {
+			writeJSON(w, 200, keys)
+			return
+		}
+		w.WriteHeader(200)
+		for _, key := range keys {
+			fmt.Fprintln(w, textKey(key))
+		}
+	}
+}
+
+// textKey returns the key for the listing, one key per line: the key which would break the line
+// or looks quoted is quoted as Go string, the others are as they are
+func textKey(key string) string {
+	if strings.HasPrefix(key, `"`) || strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		return strconv.Quote(key)
+	}
+	return key
+}
+
+// GetPrefixRouter returns HTTP handler which passes requests to the handler registered for the URL's path prefix,
+// the longest prefix wins. Other requests go to next.
+// Unlike http.ServeMux it never cleans the path up, so the keys may contain empty, "." and ".." segments.
+func GetPrefixRouter(routes map[string]http.HandlerFunc, next http.Handler) func(
+	w http.ResponseWriter, r *http.Request,
+) {
+	prefixes := make([]string, 0, len(routes))
+	for prefix := range routes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				routes[prefix](w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// GetNamespaceRouter returns HTTP handler which passes requests like /ns/<name>/key/<key>, /ns/<name>/keys
+// and /ns/<name>/txn to the handlers of the namespace.
+func GetNamespaceRouter(nss namespaces) func(
+	w http.ResponseWriter, r *http.Request,
+) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, path := splitNamespace(r.URL.Path)
+		if name == "" {
+			writeError(w, r, 400, "") // Bad request
+			return
+		}
+		handlers, ok := nss.Handlers(name)
+		if !ok {
+			writeErrorMessage(w, r, 404, namespaceNotFoundMessage, name)
+			return
+		}
+		switch strings.Trim(path, "/") {
+		case keysPath:
+			handlers.Keys(w, r)
+		case txnPath:
+			handlers.Txn(w, r)
+		default:
+			handlers.Key(w, r)
+		}
+	}
+}
+
+// GetNamespacesAdminRouter returns HTTP handler which lists, creates and drops the namespaces
+func GetNamespacesAdminRouter(nss namespaces) func(
+	w http.ResponseWriter, r *http.Request,
+) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Trim(strings.TrimPrefix(strings.TrimLeft(r.URL.Path, "/"), namespacesAdminPath), "/")
+		switch {
+		case r.Method == http.MethodGet && name == "":
+			names := nss.Names()
+			if wantsJSON(r) {
+				writeJSON(w, 200, names)
+				return
+			}
+			w.WriteHeader(200)
+			for _, name := range names {
+				fmt.Fprintln(w, name)
+			}
+		case r.Method == http.MethodPost && name != "" && !strings.Contains(name, "/"):
+			code := namespaceRequest(nss, name, r)
+			if code == 404 {
+				writeErrorMessage(w, r, code, namespaceNotFoundMessage, name)
+				return
+			}
+			if code == 409 {
+				writeCodedError(w, r, code, "namespace_exists", httpStatusCodeMessages[code], name)
+				return
+			}
+			if code != 200 {
+				writeError(w, r, code, name)
+				return
+			}
+			writeReply(w, r, name, nil)
+		default:
+			writeError(w, r, 400, name) // Bad request
+		}
+	}
+}
+
+// namespaceRequest creates or drops the namespace and returns HTTP code
+func namespaceRequest(nss namespaces, name string, r *http.Request) int {
+	r.PostFormValue(ttlFormFieldName)
+	if _, ok := r.Form[dropFormFieldName]; ok {
+		return foundCode(nss.Drop(name))
+	}
+	ttl, err := strconv.ParseUint(r.Form.Get(ttlFormFieldName), 10, 64)
+	if err != nil {
+		return 400
+	}
+	var maxMemory uint64
+	if v := r.Form.Get(maxMemoryFormFieldName); v != "" {
+		if maxMemory, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 400
+		}
+	}
+	created, err := nss.Create(name, ttl, maxMemory)
+	if err != nil {
+		// name or settings are not valid
+		return 400
+	}
+	if !created {
+		return 409
+	}
+	return 200
+}
+
+// splitNamespace splits the URL's path like /ns/<name>/key/<key> into the namespace's name
+// and the rest of the path. The name is empty if the path has no namespace.
+func splitNamespace(path string) (string, string) {
+	parts := strings.SplitN(strings.TrimLeft(path, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] != namespacePart {
+		return "", path
+	}
+	if len(parts) == 2 {
+		return parts[1], ""
+	}
+	return parts[1], parts[2]
+}
+
+// getKeyFromRequest returns the key either from URL's path or from its query.
+// The second value is the message telling why the key is not valid, it's empty for the valid key.
+func getKeyFromRequest(r *http.Request) (string, string) {
+	path := r.URL.EscapedPath()
+	query, inQuery := r.URL.Query()[keyQueryName]
+	if !inQuery {
+		if key, ok := getKeyFromURL(path); ok {
+			return key, ""
+		}
+		return "", keyMissingMessage
+	}
+	if _, ok := getKeyFromURL(path); ok {
+		return "", keyAmbiguousMessage
 	}
+	if _, rest := splitNamespace(path); strings.Trim(rest, "/") != firstPart || len(query[0]) == 0 {
+		return "", keyMissingMessage
+	}
+	return query[0], ""
 }
 
+// getKeyFromURL returns the key from the escaped URL's path, either /key/<key> or /ns/<name>/key/<key>.
+// Everything after /key/ is the key, so it may contain slashes and any percent-encoded bytes.
 func getKeyFromURL(path string) (string, bool) {
+	_, path = splitNamespace(path)
 	path = strings.TrimLeft(path, "/")
-	parts := strings.Split(path, "/")
-	if len(parts) != 2 {
+	if !strings.HasPrefix(path, firstPart+"/") {
 		return "", false
 	}
-	key := parts[len(parts)-1]
-	if len(key) == 0 || parts[0] != firstPart {
+	key, err := url.PathUnescape(strings.TrimPrefix(path, firstPart+"/"))
+	if err != nil || len(key) == 0 {
 		return "", false
 	}
 	return key, true
 }
 
+// typeRequest processes HTTP request to the element of other kind than string, e.g. hash,
+// and returns the reply and HTTP code
+type typeRequest func(req *request, form url.Values) (*reply, int)
+
+// queryFactories return the function for GET request to the element of other kind than string,
+// false if it's not such a request
+var queryFactories = []func(query url.Values) (typeRequest, bool){
+	hashQueryFactory,
+	listQueryFactory,
+	setQueryFactory,
+	sortedSetQueryFactory,
+}
+
+// formFactories return the function for POST request to the element of other kind than string,
+// false if it's not such a request
+var formFactories = []func(form url.Values) (typeRequest, bool){
+	hashFormFactory,
+	listFormFactory,
+	setFormFactory,
+	sortedSetFormFactory,
+	lockFormFactory,
+}
+
 // requestFactory returns function which can be use to handle different types of HTTP request (GET or POST)
-func requestFactory(method string) (func(readerWriter, string, *http.Request) (string, int), bool) {
+func requestFactory(method string) (func(*request) (*reply, int), bool) {
 	if method == http.MethodGet {
 		return methodGET, true
 	}
@@ -83,63 +405,186 @@ func requestFactory(method string) (func(readerWriter, string, *http.Request) (s
 }
 
 // methodGET returns value and the HTTP code for the key.
-func methodGET(stor readerWriter, key string, r *http.Request) (string, int) {
-	code := 200
+// Response's Expires header tells when the element is going to be purged from the storage.
+func methodGET(req *request) (*reply, int) {
+	query := req.r.URL.Query()
+	for _, factory := range queryFactories {
+		if typeRequest, ok := factory(query); ok {
+			return typeRequest(req, query)
+		}
+	}
 	// get the value by its key
-	val, err := stor.Get(key)
+	elem, found, err := req.stor.Lookup(req.key)
 	if err != nil {
-		return httpStatusCodeMessages[500], 500
+		return nil, 500
+	}
+	if !found {
+		// key is not found in the storage (code 404)
+		return nil, 404
+	}
+	if elem.Kind != element.String {
+		return storageError(kvstorage.ErrWrongType)
 	}
-	if val == nil {
-		// either error occurred or key is not found in the storage (code 404)
-		code = 404
-		val = []byte(fmt.Sprintf(httpStatusCodeMessages[code], key))
+	val := newValue(req.key, elem, req.ttl, req.clock.Now())
+	if val.Expires != nil {
+		req.w.Header().Set("Expires", val.Expires.UTC().Format(http.TimeFormat))
 	}
-	return string(val), code
+	return valueReply(val), 200
 }
 
 // methodPOST - функция обработчика метода POST
-func methodPOST(stor readerWriter, key string, r *http.Request) (string, int) {
-	value := r.PostFormValue(valueFormFieldName)
-	postProcessingMethod := postMethodFactory(len(r.Form))
-	httpCode := postProcessingMethod(stor, key, value)
-	return httpStatusCodeMessages[httpCode], httpCode
+func methodPOST(req *request) (*reply, int) {
+	// the form is parsed as a side effect, both URL query and request body are in req.r.Form then
+	req.r.PostFormValue(valueFormFieldName)
+	// the key passed in the URL's query is not an element's field
+	delete(req.r.Form, keyQueryName)
+	for _, factory := range formFactories {
+		if typeRequest, ok := factory(req.r.Form); ok {
+			return typeRequest(req, req.r.Form)
+		}
+	}
+	postProcessingMethod := postMethodFactory(req.r.Form)
+	return postProcessingMethod(req, req.r.Form)
 }
 
-func postMethodFactory(formLen int) func(storage readerWriter, key, value string) int {
-	if formLen == 0 {
+func postMethodFactory(form url.Values) typeRequest {
+	if len(form) == 0 {
 		// deleting the element
 		return deleteElementRequest
 	}
+	if _, ok := form[touchFormFieldName]; ok {
+		// resetting element's lifetime
+		return touchElementRequest
+	}
+	if _, ok := form[expireAtFormFieldName]; ok {
+		// setting absolute expiration time
+		return expireAtElementRequest
+	}
+	if _, ok := form[persistFormFieldName]; ok {
+		// making the element never expire
+		return persistElementRequest
+	}
 	// setting the element
 	return setElementRequest
 }
 
-// deleteElementRequest processes delete HTTP request and returns HTTP code.
-func deleteElementRequest(storage readerWriter, key, value string) int {
+// deleteElementRequest processes delete HTTP request.
+func deleteElementRequest(req *request, form url.Values) (*reply, int) {
 	// deleting element by its key
-	delStatus, err := storage.Delete(key)
+	return foundReply(req.stor.Delete(req.key))
+}
+
+// touchElementRequest processes touch HTTP request.
+func touchElementRequest(req *request, form url.Values) (*reply, int) {
+	return foundReply(req.stor.Touch(req.key))
+}
+
+// expireAtElementRequest processes expireat HTTP request.
+func expireAtElementRequest(req *request, form url.Values) (*reply, int) {
+	expires, err := parseTime(form.Get(expireAtFormFieldName))
 	if err != nil {
-		// something went wrong with the storage
-		return 500
-	}
-	if delStatus {
-		// element deleted successfully
-		return 200
+		return nil, 400
 	}
-	// element was not found and is not deleted
-	return 404
+	return foundReply(req.stor.ExpireAt(req.key, expires))
+}
+
+// persistElementRequest processes persist HTTP request.
+func persistElementRequest(req *request, form url.Values) (*reply, int) {
+	return foundReply(req.stor.Persist(req.key))
 }
 
-func setElementRequest(storage readerWriter, key, value string) int {
+func setElementRequest(req *request, form url.Values) (*reply, int) {
+	value := form.Get(valueFormFieldName)
+	if value == "" {
+		// nothing is set, so NX may be retried with the value
+		return nil, 400
+	}
+	mode := kvstorage.Upsert
+	_, nx := form[nxFormFieldName]
+	_, xx := form[xxFormFieldName]
+	switch {
+	case nx && xx:
+		// the key can't be both absent and present
+		return nil, 400
+	case nx:
+		mode = kvstorage.IfAbsent
+	case xx:
+		mode = kvstorage.IfExists
+	}
 	// setting (updating) the value by its key
-	err := storage.Set(key, value)
+	err := req.stor.Set(req.key, value, mode)
+	if err != nil {
+		// something went wrong with the storage, the element does not fit into its memory quota or it's locked
+		return storageError(err)
+	}
+	return nil, 200
+}
+
+// storageError returns the reply with the error message and HTTP code for the storage's error
+func storageError(err error) (*reply, int) {
+	switch {
+	case errors.Is(err, kvstorage.ErrWrongType):
+		return &reply{text: wrongTypeMessage}, 409
+	case errors.Is(err, kvstorage.ErrNotInteger):
+		return &reply{text: notIntegerMessage}, 400
+	case errors.Is(err, kvstorage.ErrNotFloat):
+		return &reply{text: notFloatMessage}, 400
+	case errors.Is(err, kvstorage.ErrVersionMismatch):
+		return &reply{text: versionMismatchMessage}, 409
+	case errors.Is(err, kvstorage.ErrKeyExists):
+		// the element is created only
+		return &reply{text: keyExistsMessage}, 409
+	case errors.Is(err, kvstorage.ErrKeyNotFound):
+		// the element is updated only
+		return nil, 404
+	case errors.Is(err, kvstorage.ErrLocked):
+		return &reply{text: lockedMessage}, 409
+	case errors.Is(err, kvstorage.ErrNotOwner):
+		return &reply{text: notOwnerMessage}, 409
+	case errors.Is(err, kvstorage.ErrNoMemory):
+		// the element does not fit into the storage's memory quota
+		return nil, 507
+	}
+	// something went wrong with the storage
+	return nil, 500
+}
+
+// foundReply returns the reply for the storage operation which reports whether the element is found
+func foundReply(found bool, err error) (*reply, int) {
+	if err != nil {
+		return storageError(err)
+	}
+	return nil, foundCode(found, nil)
+}
+
+// foundCode returns HTTP code for the storage operation which reports whether the element is found
+func foundCode(found bool, err error) int {
 	if err != nil {
 		// something went wrong with the storage
 		return 500
 	}
-	if value != "" {
+	if found {
+		// element is processed successfully
 		return 200
 	}
-	return 400
+	// element was not found and is not processed
+	return 404
+}
+
+// parseTime parses either Unix time, secs or RFC 3339 time
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// lifetime returns element's lifetime in the storage: the storage's current TTL if it tells one, ttl otherwise
+func lifetime(stor interface{}, ttl time.Duration) time.Duration {
+	if t, ok := stor.(ttler); ok {
+		if current := t.TTL(); current > 0 {
+			return current
+		}
+	}
+	return ttl
 }
@@ -1,11 +1,17 @@
 package router
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/proway2/kvserver/kvstorage"
 )
@@ -38,7 +44,7 @@ func TestGetURLrouter(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			expectedType := reflect.TypeOf(func(w http.ResponseWriter, r *http.Request) {})
-			if got := GetURLrouter(tt.args.stor); reflect.TypeOf(got) != expectedType {
+			if got := GetURLrouter(tt.args.stor, 60); reflect.TypeOf(got) != expectedType {
 				t.Errorf("GetURLrouter() = %T, want %T", got, tt.want)
 			}
 		})
@@ -97,6 +103,42 @@ func Test_getKeyFromURL(t *testing.T) {
 			want:  "",
 			want1: false,
 		},
+		{
+			name:  "Key with slashes",
+			args:  args{inps: "/key/user/42/profile"},
+			want:  "user/42/profile",
+			want1: true,
+		},
+		{
+			name:  "Key with escaped slashes and bytes",
+			args:  args{inps: "/key/user%2F%2F42%20%FF"},
+			want:  "user//42 \xff",
+			want1: true,
+		},
+		{
+			name:  "Key is not properly escaped",
+			args:  args{inps: "/key/user%2"},
+			want:  "",
+			want1: false,
+		},
+		{
+			name:  "Good path with namespace",
+			args:  args{inps: "/ns/team1/key/fg"},
+			want:  "fg",
+			want1: true,
+		},
+		{
+			name:  "Namespace without a key",
+			args:  args{inps: "/ns/team1/key/"},
+			want:  "",
+			want1: false,
+		},
+		{
+			name:  "Namespace without a name",
+			args:  args{inps: "/ns/key/fg"},
+			want:  "",
+			want1: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -136,7 +178,7 @@ func (resp *myResponseWriter) getCode() int {
 func Test_closure(t *testing.T) {
 	storage := kvstorage.NewStorage()
 
-	handler := GetURLrouter(storage)
+	handler := GetURLrouter(storage, 60)
 	var writer = &myResponseWriter{code: 200}
 
 	form := url.Values{}
@@ -281,3 +323,594 @@ func Test_closure(t *testing.T) {
 		})
 	}
 }
+
+func TestGetKeysRouter(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("key1", correctValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Set("key2", correctValue); err != nil {
+		t.Fatal(err)
+	}
+	// the keys which would break the listing are quoted
+	for _, key := range []string{"line\nbreak", "tab\tkey", `"quoted"`} {
+		if err := storage.Set(key, correctValue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := GetKeysRouter(storage)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Listing the keys",
+			method:   "GET",
+			path:     "/keys/",
+			wantCode: 200,
+			wantBody: "key1\nkey2\n\"line\\nbreak\"\n\"tab\\tkey\"\n\"\\\"quoted\\\"\"\n",
+		},
+		{
+			name:     "Incorrect HTTP method (verb)",
+			method:   "POST",
+			path:     "/keys/",
+			wantCode: 400,
+			wantBody: httpStatusCodeMessages[400],
+		},
+		{
+			name:     "Extra part in the URL path",
+			method:   "GET",
+			path:     "/keys/key1",
+			wantCode: 400,
+			wantBody: httpStatusCodeMessages[400],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("GetKeysRouter() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("GetKeysRouter() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_methodGET_Expires(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set(correctKey, correctValue); err != nil {
+		t.Fatal(err)
+	}
+	elem, _, _ := storage.Lookup(correctKey)
+	handler := GetURLrouter(storage, 60)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/key/"+correctKey, nil))
+	if rec.Code != 200 || rec.Body.String() != correctValue {
+		t.Fatalf("urlHandler() = %v %q, want 200 %q", rec.Code, rec.Body.String(), correctValue)
+	}
+	want := elem.Timestamp.Add(60 * time.Second).UTC().Format(http.TimeFormat)
+	if got := rec.Header().Get("Expires"); got != want {
+		t.Errorf("urlHandler() Expires = %q, want %q", got, want)
+	}
+}
+
+func Test_methodGET_Expires_storageTTL(t *testing.T) {
+	storage := kvstorage.NewStorage(kvstorage.WithTTL(time.Minute))
+	if err := storage.Set(correctKey, correctValue); err != nil {
+		t.Fatal(err)
+	}
+	elem, _, _ := storage.Lookup(correctKey)
+	handler := GetURLrouter(storage, 60)
+	// TTL changed at runtime is told by the storage
+	if err := storage.SetTTL(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/key/"+correctKey, nil))
+	want := elem.Timestamp.Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := rec.Header().Get("Expires"); got != want {
+		t.Errorf("urlHandler() Expires = %q, want %q", got, want)
+	}
+}
+
+func Test_closure_touch(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set(correctKey, correctValue); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60)
+
+	tests := []struct {
+		name     string
+		key      string
+		wantCode int
+	}{
+		{name: "Touching existing key", key: correctKey, wantCode: 200},
+		{name: "Touching missing key", key: correctKey + "xxx", wantCode: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/key/"+tt.key, strings.NewReader(touchFormFieldName+"="))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+		})
+	}
+	// touch must not change the value
+	if val, _ := storage.Get(correctKey); string(val) != correctValue {
+		t.Errorf("value after touch = %q, want %q", val, correctValue)
+	}
+}
+
+func Test_closure_conditional(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set(correctKey, correctValue); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60)
+
+	tests := []struct {
+		name      string
+		key       string
+		form      url.Values
+		wantCode  int
+		wantValue string
+	}{
+		{
+			name:      "Creating existing key",
+			key:       correctKey,
+			form:      url.Values{valueFormFieldName: {"new"}, nxFormFieldName: {""}},
+			wantCode:  409,
+			wantValue: correctValue,
+		},
+		{
+			name:      "Updating existing key",
+			key:       correctKey,
+			form:      url.Values{valueFormFieldName: {"new"}, xxFormFieldName: {""}},
+			wantCode:  200,
+			wantValue: "new",
+		},
+		{
+			name:     "Updating missing key",
+			key:      "session",
+			form:     url.Values{valueFormFieldName: {"new"}, xxFormFieldName: {""}},
+			wantCode: 404,
+		},
+		{
+			name:      "Creating missing key",
+			key:       "session",
+			form:      url.Values{valueFormFieldName: {"new"}, nxFormFieldName: {""}},
+			wantCode:  200,
+			wantValue: "new",
+		},
+		{
+			name:      "Updating existing key with empty value",
+			key:       correctKey,
+			form:      url.Values{valueFormFieldName: {""}, xxFormFieldName: {""}},
+			wantCode:  400,
+			wantValue: "new",
+		},
+		{
+			name:     "Creating missing key with empty value",
+			key:      "idempotency",
+			form:     url.Values{valueFormFieldName: {""}, nxFormFieldName: {""}},
+			wantCode: 400,
+		},
+		{
+			name:      "Creating key after empty value",
+			key:       "idempotency",
+			form:      url.Values{valueFormFieldName: {"new"}, nxFormFieldName: {""}},
+			wantCode:  200,
+			wantValue: "new",
+		},
+		{
+			name:      "Both conditions",
+			key:       correctKey,
+			form:      url.Values{valueFormFieldName: {"newer"}, nxFormFieldName: {""}, xxFormFieldName: {""}},
+			wantCode:  400,
+			wantValue: "new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/key/"+tt.key, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if val, _ := storage.Get(tt.key); string(val) != tt.wantValue {
+				t.Errorf("value = %q, want %q", val, tt.wantValue)
+			}
+		})
+	}
+}
+
+func Test_closure_expiration(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set(correctKey, correctValue); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60)
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name        string
+		key         string
+		form        url.Values
+		wantCode    int
+		wantExpires string // Expires header of the subsequent GET request
+	}{
+		{
+			name:     "Bad expiration time",
+			key:      correctKey,
+			form:     url.Values{expireAtFormFieldName: {"tomorrow"}},
+			wantCode: 400,
+		},
+		{
+			name:     "Expiration time for missing key",
+			key:      correctKey + "xxx",
+			form:     url.Values{expireAtFormFieldName: {strconv.FormatInt(expires.Unix(), 10)}},
+			wantCode: 404,
+		},
+		{
+			name:        "Expiration time as Unix time",
+			key:         correctKey,
+			form:        url.Values{expireAtFormFieldName: {strconv.FormatInt(expires.Unix(), 10)}},
+			wantCode:    200,
+			wantExpires: expires.UTC().Format(http.TimeFormat),
+		},
+		{
+			name:        "Expiration time as RFC 3339",
+			key:         correctKey,
+			form:        url.Values{expireAtFormFieldName: {expires.Add(time.Hour).Format(time.RFC3339)}},
+			wantCode:    200,
+			wantExpires: expires.Add(time.Hour).UTC().Format(http.TimeFormat),
+		},
+		{
+			name:        "Persistent element has no Expires header",
+			key:         correctKey,
+			form:        url.Values{persistFormFieldName: {""}},
+			wantCode:    200,
+			wantExpires: "",
+		},
+		{
+			name:     "Persist missing key",
+			key:      correctKey + "xxx",
+			form:     url.Values{persistFormFieldName: {""}},
+			wantCode: 404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/key/"+tt.key, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Code != 200 {
+				return
+			}
+			rec = httptest.NewRecorder()
+			handler(rec, httptest.NewRequest("GET", "/key/"+tt.key, nil))
+			if got := rec.Header().Get("Expires"); got != tt.wantExpires {
+				t.Errorf("urlHandler() Expires = %q, want %q", got, tt.wantExpires)
+			}
+		})
+	}
+}
+
+// fakeNamespaces - namespaces backed by the storages created on demand
+type fakeNamespaces map[string]*kvstorage.KVStorage
+
+func (nss fakeNamespaces) Handlers(name string) (Handlers, bool) {
+	stor, ok := nss[name]
+	if !ok {
+		return Handlers{}, false
+	}
+	return Handlers{Key: GetURLrouter(stor, 60), Keys: GetKeysRouter(stor), Txn: GetTxnRouter(stor)}, true
+}
+
+func (nss fakeNamespaces) Create(name string, ttl, maxMemory uint64) (bool, error) {
+	if ttl == 0 {
+		return false, errors.New("ttl = 0")
+	}
+	if _, ok := nss[name]; ok {
+		return false, nil
+	}
+	nss[name] = kvstorage.NewStorage(kvstorage.WithMaxMemory(maxMemory))
+	return true, nil
+}
+
+func (nss fakeNamespaces) Drop(name string) (bool, error) {
+	_, ok := nss[name]
+	delete(nss, name)
+	return ok, nil
+}
+
+func (nss fakeNamespaces) Names() []string {
+	names := make([]string, 0, len(nss))
+	for name := range nss {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestGetNamespacesAdminRouter(t *testing.T) {
+	nss := fakeNamespaces{}
+	admin := GetNamespacesAdminRouter(nss)
+	handler := GetNamespaceRouter(nss)
+
+	tests := []struct {
+		name     string
+		handler  func(w http.ResponseWriter, r *http.Request)
+		method   string
+		path     string
+		form     url.Values
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Namespace does not exist",
+			handler:  handler,
+			method:   "GET",
+			path:     "/ns/team1/key/key1",
+			wantCode: 404,
+			wantBody: "404 There is no namespace 'team1'.\n",
+		},
+		{
+			name:     "Creating namespace without TTL",
+			handler:  admin,
+			method:   "POST",
+			path:     "/admin/ns/team1",
+			form:     url.Values{maxMemoryFormFieldName: {"100"}},
+			wantCode: 400,
+			wantBody: httpStatusCodeMessages[400],
+		},
+		{
+			name:     "Creating namespace",
+			handler:  admin,
+			method:   "POST",
+			path:     "/admin/ns/team1",
+			form:     url.Values{ttlFormFieldName: {"60"}, maxMemoryFormFieldName: {"10"}},
+			wantCode: 200,
+		},
+		{
+			name:     "Creating existing namespace",
+			handler:  admin,
+			method:   "POST",
+			path:     "/admin/ns/team1",
+			form:     url.Values{ttlFormFieldName: {"60"}},
+			wantCode: 409,
+			wantBody: "409 'team1' already exists.\n",
+		},
+		{
+			name:     "Setting the value in the namespace",
+			handler:  handler,
+			method:   "POST",
+			path:     "/ns/team1/key/key1",
+			form:     url.Values{valueFormFieldName: {"value"}},
+			wantCode: 200,
+		},
+		{
+			name:     "Memory quota is exceeded",
+			handler:  handler,
+			method:   "POST",
+			path:     "/ns/team1/key/key2",
+			form:     url.Values{valueFormFieldName: {"value"}},
+			wantCode: 507,
+			wantBody: "507 There is no room in the storage for key 'key2'.\n",
+		},
+		{
+			name:     "Getting the value from the namespace",
+			handler:  handler,
+			method:   "GET",
+			path:     "/ns/team1/key/key1",
+			wantCode: 200,
+			wantBody: "value",
+		},
+		{
+			name:     "Listing the keys of the namespace",
+			handler:  handler,
+			method:   "GET",
+			path:     "/ns/team1/keys",
+			wantCode: 200,
+			wantBody: "key1\n",
+		},
+		{
+			name:     "Transaction in the namespace",
+			handler:  handler,
+			method:   "POST",
+			path:     "/ns/team1/txn",
+			wantCode: 400,
+			wantBody: badTxnMessage,
+		},
+		{
+			name:     "Listing the namespaces",
+			handler:  admin,
+			method:   "GET",
+			path:     "/admin/ns/",
+			wantCode: 200,
+			wantBody: "team1\n",
+		},
+		{
+			name:     "Dropping the namespace",
+			handler:  admin,
+			method:   "POST",
+			path:     "/admin/ns/team1",
+			form:     url.Values{dropFormFieldName: {""}},
+			wantCode: 200,
+		},
+		{
+			name:     "Dropping missing namespace",
+			handler:  admin,
+			method:   "POST",
+			path:     "/admin/ns/team1",
+			form:     url.Values{dropFormFieldName: {""}},
+			wantCode: 404,
+			wantBody: "404 There is no namespace 'team1'.\n",
+		},
+		{
+			name:     "Namespace name is missing",
+			handler:  handler,
+			method:   "GET",
+			path:     "/ns/",
+			wantCode: 400,
+			wantBody: httpStatusCodeMessages[400],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			tt.handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("handler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("handler() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_closure_keys(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	handler := GetURLrouter(storage, 60, WithMaxKeyLength(16))
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		form     url.Values
+		wantCode int
+		wantBody string
+		wantKey  string // the key stored by the request
+	}{
+		{
+			name:     "Key with slashes",
+			method:   "POST",
+			target:   "/key/user/42/profile",
+			form:     url.Values{valueFormFieldName: {"v1"}},
+			wantCode: 200,
+			wantKey:  "user/42/profile",
+		},
+		{
+			name:     "Key with escaped slashes",
+			method:   "GET",
+			target:   "/key/user%2F42%2Fprofile",
+			wantCode: 200,
+			wantBody: "v1",
+		},
+		{
+			name:     "Binary key in the query",
+			method:   "POST",
+			target:   "/key/?key=%00%FF..",
+			form:     url.Values{valueFormFieldName: {"v2"}},
+			wantCode: 200,
+			wantKey:  "\x00\xff..",
+		},
+		{
+			name:     "Deleting by the key in the query",
+			method:   "POST",
+			target:   "/key/?key=%00%FF..",
+			wantCode: 200,
+		},
+		{
+			name:     "Key is missing",
+			method:   "GET",
+			target:   "/key/",
+			wantCode: 400,
+			wantBody: keyMissingMessage,
+		},
+		{
+			name:     "Key is in the query of the wrong path",
+			method:   "GET",
+			target:   "/keys/?key=a",
+			wantCode: 400,
+			wantBody: keyMissingMessage,
+		},
+		{
+			name:     "Key in both path and query",
+			method:   "GET",
+			target:   "/key/a?key=b",
+			wantCode: 400,
+			wantBody: keyAmbiguousMessage,
+		},
+		{
+			name:     "Key is too long",
+			method:   "GET",
+			target:   "/key/" + strings.Repeat("a", 17),
+			wantCode: 400,
+			wantBody: "400 Key is longer than 16 bytes.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("urlHandler() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if tt.wantKey == "" {
+				return
+			}
+			if _, found, _ := storage.Lookup(tt.wantKey); !found {
+				t.Errorf("urlHandler() key %q is not stored", tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestGetPrefixRouter(t *testing.T) {
+	route := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		}
+	}
+	handler := GetPrefixRouter(map[string]http.HandlerFunc{
+		"/key/":   route("key"),
+		"/key/a/": route("longest"),
+	}, route("next"))
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "Path is not cleaned up", path: "/key/b//../c", want: "key"},
+		{name: "The longest prefix wins", path: "/key/a/b", want: "longest"},
+		{name: "No prefix matches", path: "/keys/", want: "next"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Body.String() != tt.want {
+				t.Errorf("GetPrefixRouter() routed to %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package router
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type setter interface {
+	SAdd(key string, members ...string) (int, error)
+	SRem(key string, members ...string) (int, error)
+	SIsMember(key, member string) (bool, error)
+	SMembers(key string) ([]string, bool, error)
+	SCard(key string) (int, error)
+}
+
+const (
+	// POST form field name, the member to add to the set, may be repeated
+	saddFormFieldName = "sadd"
+	// POST form field name, the member to remove from the set, may be repeated
+	sremFormFieldName = "srem"
+	// GET query parameter name, the member to look for in the set
+	sismemberQueryName = "sismember"
+	// GET query parameter name, its presence returns all members of the set
+	smembersQueryName = "smembers"
+	// GET query parameter name, its presence returns the number of members of the set
+	scardQueryName = "scard"
+)
+
+// setQueryFactory returns the function for the GET request to the set, false if it's not such a request
+func setQueryFactory(query url.Values) (typeRequest, bool) {
+	if _, ok := query[sismemberQueryName]; ok {
+		return sismemberRequest, true
+	}
+	if _, ok := query[smembersQueryName]; ok {
+		return smembersRequest, true
+	}
+	if _, ok := query[scardQueryName]; ok {
+		return scardRequest, true
+	}
+	return nil, false
+}
+
+// setFormFactory returns the function for the POST request to the set, false if it's not such a request
+func setFormFactory(form url.Values) (typeRequest, bool) {
+	if _, ok := form[saddFormFieldName]; ok {
+		return saddRequest, true
+	}
+	if _, ok := form[sremFormFieldName]; ok {
+		return sremRequest, true
+	}
+	return nil, false
+}
+
+// saddRequest adds the members to the set, the reply is the number of new members
+func saddRequest(req *request, form url.Values) (*reply, int) {
+	added, err := req.stor.SAdd(req.key, form[saddFormFieldName]...)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: strconv.Itoa(added),
+		json: map[string]interface{}{"key": req.key, "added": added},
+	}, 200
+}
+
+// sremRequest removes the members from the set, the reply is the number of removed members
+func sremRequest(req *request, form url.Values) (*reply, int) {
+	removed, err := req.stor.SRem(req.key, form[sremFormFieldName]...)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: strconv.Itoa(removed),
+		json: map[string]interface{}{"key": req.key, "removed": removed},
+	}, 200
+}
+
+// sismemberRequest tells whether the member is in the set, missing set has no members
+func sismemberRequest(req *request, query url.Values) (*reply, int) {
+	member := query.Get(sismemberQueryName)
+	ok, err := req.stor.SIsMember(req.key, member)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: boolText(ok),
+		json: map[string]interface{}{"key": req.key, "member": member, "ismember": ok},
+	}, 200
+}
+
+// smembersRequest returns all members of the set, one member per line
+func smembersRequest(req *request, query url.Values) (*reply, int) {
+	members, found, err := req.stor.SMembers(req.key)
+	if err != nil {
+		return storageError(err)
+	}
+	if !found {
+		return nil, 404
+	}
+	var text strings.Builder
+	for _, member := range members {
+		text.WriteString(member + "\n")
+	}
+	return &reply{
+		text: text.String(),
+		json: map[string]interface{}{"key": req.key, "members": members},
+	}, 200
+}
+
+// scardRequest returns the number of members of the set, missing set has no members
+func scardRequest(req *request, query url.Values) (*reply, int) {
+	card, err := req.stor.SCard(req.key)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: strconv.Itoa(card),
+		json: map[string]interface{}{"key": req.key, "cardinality": card},
+	}, 200
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+func Test_closure_set(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("string", correctValue); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		form     url.Values
+		json     bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Adding the members",
+			method:   "POST",
+			target:   "/key/tags",
+			form:     url.Values{saddFormFieldName: {"go", "kv", "go"}},
+			wantCode: 200,
+			wantBody: "2",
+		},
+		{
+			name:     "Adding existing member",
+			method:   "POST",
+			target:   "/key/tags",
+			form:     url.Values{saddFormFieldName: {"go", "http"}},
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"added":1,"key":"tags"}` + "\n",
+		},
+		{
+			name:     "Checking the member",
+			method:   "GET",
+			target:   "/key/tags?sismember=kv",
+			wantCode: 200,
+			wantBody: "1",
+		},
+		{
+			name:     "Checking missing member",
+			method:   "GET",
+			target:   "/key/tags?sismember=c",
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"ismember":false,"key":"tags","member":"c"}` + "\n",
+		},
+		{
+			name:     "Getting the members",
+			method:   "GET",
+			target:   "/key/tags?smembers",
+			wantCode: 200,
+			wantBody: "go\nhttp\nkv\n",
+		},
+		{
+			name:     "Getting the members as JSON",
+			method:   "GET",
+			target:   "/key/tags?smembers",
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"key":"tags","members":["go","http","kv"]}` + "\n",
+		},
+		{
+			name:     "Cardinality",
+			method:   "GET",
+			target:   "/key/tags?scard",
+			wantCode: 200,
+			wantBody: "3",
+		},
+		{
+			name:     "Set operation on the string",
+			method:   "GET",
+			target:   "/key/string?smembers",
+			wantCode: 409,
+			wantBody: "409 Key 'string' holds the wrong kind of value.\n",
+		},
+		{
+			name:     "Removing the members",
+			method:   "POST",
+			target:   "/key/tags",
+			form:     url.Values{sremFormFieldName: {"go", "http", "kv"}},
+			wantCode: 200,
+			wantBody: "3",
+		},
+		{
+			name:     "The set is removed along with its last member",
+			method:   "GET",
+			target:   "/key/tags?smembers",
+			wantCode: 404,
+			wantBody: "404 There is no record in the storage for key 'tags'.\n",
+		},
+		{
+			name:     "Cardinality of missing set",
+			method:   "GET",
+			target:   "/key/tags?scard",
+			wantCode: 200,
+			wantBody: "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.json {
+				r.Header.Set("Accept", jsonContentType)
+			}
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("urlHandler() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package router
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+type ranker interface {
+	ZAdd(key, member string, score float64) (bool, error)
+	ZIncrBy(key, member string, incr float64) (float64, error)
+	ZRem(key string, members ...string) (int, error)
+	ZRange(key string, start, stop int) ([]kvstorage.ScoredMember, bool, error)
+	ZRangeByScore(key string, min, max float64) ([]kvstorage.ScoredMember, bool, error)
+}
+
+const (
+	// POST form field name, the member of the sorted set to add, the score is in scoreFormFieldName
+	zaddFormFieldName = "zadd"
+	// POST form field name, the score of the member
+	scoreFormFieldName = "score"
+	// POST form field name, the member of the sorted set to increment by valueFormFieldName
+	zincrbyFormFieldName = "zincrby"
+	// POST form field name, the member to remove from the sorted set, may be repeated
+	zremFormFieldName = "zrem"
+	// GET query parameter name, its presence returns the members from start to stop rank
+	zrangeQueryName = "zrange"
+	// GET query parameter name, its presence returns the members with the scores from min to max
+	zrangebyscoreQueryName = "zrangebyscore"
+	// the range of the scores, both inclusive, -inf and +inf are allowed
+	minQueryName = "min"
+	maxQueryName = "max"
+)
+
+const notFloatMessage = "400 Score is not a valid float.\n"
+
+// sortedSetQueryFactory returns the function for the GET request to the sorted set, false if it's not such a request
+func sortedSetQueryFactory(query url.Values) (typeRequest, bool) {
+	if _, ok := query[zrangeQueryName]; ok {
+		return zrangeRequest, true
+	}
+	if _, ok := query[zrangebyscoreQueryName]; ok {
+		return zrangebyscoreRequest, true
+	}
+	return nil, false
+}
+
+// sortedSetFormFactory returns the function for the POST request to the sorted set, false if it's not such a request
+func sortedSetFormFactory(form url.Values) (typeRequest, bool) {
+	if _, ok := form[zaddFormFieldName]; ok {
+		return zaddRequest, true
+	}
+	if _, ok := form[zincrbyFormFieldName]; ok {
+		return zincrbyRequest, true
+	}
+	if _, ok := form[zremFormFieldName]; ok {
+		return zremRequest, true
+	}
+	return nil, false
+}
+
+// zaddRequest sets the score of the member, the reply tells whether the member is new
+func zaddRequest(req *request, form url.Values) (*reply, int) {
+	member := form.Get(zaddFormFieldName)
+	score, ok := parseScore(form.Get(scoreFormFieldName), 0)
+	if !ok {
+		return &reply{text: notFloatMessage}, 400
+	}
+	created, err := req.stor.ZAdd(req.key, member, score)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: boolText(created),
+		json: map[string]interface{}{"key": req.key, "member": member, "created": created},
+	}, 200
+}
+
+// zincrbyRequest increments the score of the member, the reply is the new score
+func zincrbyRequest(req *request, form url.Values) (*reply, int) {
+	member := form.Get(zincrbyFormFieldName)
+	incr, ok := parseScore(form.Get(valueFormFieldName), 0)
+	if !ok {
+		return &reply{text: notFloatMessage}, 400
+	}
+	score, err := req.stor.ZIncrBy(req.key, member, incr)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: formatScore(score),
+		json: map[string]interface{}{"key": req.key, "member": member, "score": jsonScore(score)},
+	}, 200
+}
+
+// zremRequest removes the members from the sorted set, the reply is the number of removed members
+func zremRequest(req *request, form url.Values) (*reply, int) {
+	removed, err := req.stor.ZRem(req.key, form[zremFormFieldName]...)
+	if err != nil {
+		return storageError(err)
+	}
+	return &reply{
+		text: strconv.Itoa(removed),
+		json: map[string]interface{}{"key": req.key, "removed": removed},
+	}, 200
+}
+
+// zrangeRequest returns the members from start to stop rank, one member and its score per line
+func zrangeRequest(req *request, query url.Values) (*reply, int) {
+	start, stop, ok := parseRange(query)
+	if !ok {
+		return &reply{text: badRangeMessage}, 400
+	}
+	return membersReply(req.key)(req.stor.ZRange(req.key, start, stop))
+}
+
+// zrangebyscoreRequest returns the members with the scores from min to max, one member and its score per line
+func zrangebyscoreRequest(req *request, query url.Values) (*reply, int) {
+	min, ok := parseScore(query.Get(minQueryName), math.Inf(-1))
+	if !ok {
+		return &reply{text: notFloatMessage}, 400
+	}
+	max, ok := parseScore(query.Get(maxQueryName), math.Inf(1))
+	if !ok {
+		return &reply{text: notFloatMessage}, 400
+	}
+	return membersReply(req.key)(req.stor.ZRangeByScore(req.key, min, max))
+}
+
+// membersReply returns the function making the reply for the range of the sorted set
+func membersReply(key string) func([]kvstorage.ScoredMember, bool, error) (*reply, int) {
+	return func(members []kvstorage.ScoredMember, found bool, err error) (*reply, int) {
+		if err != nil {
+			return storageError(err)
+		}
+		if !found {
+			return nil, 404
+		}
+		var text strings.Builder
+		scored := make([]map[string]interface{}, 0, len(members))
+		for _, m := range members {
+			fmt.Fprintf(&text, "%v\t%v\n", m.Member, formatScore(m.Score))
+			scored = append(scored, map[string]interface{}{"member": m.Member, "score": jsonScore(m.Score)})
+		}
+		return &reply{
+			text: text.String(),
+			json: map[string]interface{}{"key": key, "members": scored},
+		}, 200
+	}
+}
+
+// parseScore parses the score, empty string is def, NaN is not allowed
+func parseScore(s string, def float64) (float64, bool) {
+	if s == "" {
+		return def, true
+	}
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+// formatScore returns the shortest text of the score, infinities are "+inf" and "-inf"
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "+inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// jsonScore returns the score for JSON reply, JSON has no infinities so they are strings
+func jsonScore(score float64) interface{} {
+	if math.IsInf(score, 0) {
+		return formatScore(score)
+	}
+	return score
+}
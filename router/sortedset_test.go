@@ -0,0 +1,124 @@
+package router
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+func Test_closure_sortedSet(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("string", correctValue); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetURLrouter(storage, 60)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		form     url.Values
+		json     bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Adding the member",
+			method:   "POST",
+			target:   "/key/board",
+			form:     url.Values{zaddFormFieldName: {"bob"}, scoreFormFieldName: {"20"}},
+			wantCode: 200,
+			wantBody: "1",
+		},
+		{
+			name:     "Adding the member with the same score",
+			method:   "POST",
+			target:   "/key/board",
+			form:     url.Values{zaddFormFieldName: {"amy"}, scoreFormFieldName: {"20"}},
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"created":true,"key":"board","member":"amy"}` + "\n",
+		},
+		{
+			name:     "Incrementing the score",
+			method:   "POST",
+			target:   "/key/board",
+			form:     url.Values{zincrbyFormFieldName: {"cat"}, valueFormFieldName: {"7.5"}},
+			wantCode: 200,
+			wantBody: "7.5",
+		},
+		{
+			name:     "Score is not a number",
+			method:   "POST",
+			target:   "/key/board",
+			form:     url.Values{zaddFormFieldName: {"dan"}, scoreFormFieldName: {"NaN"}},
+			wantCode: 400,
+			wantBody: notFloatMessage,
+		},
+		{
+			name:     "Range by rank",
+			method:   "GET",
+			target:   "/key/board?zrange&start=-2",
+			wantCode: 200,
+			wantBody: "amy\t20\nbob\t20\n",
+		},
+		{
+			name:     "Range by score as JSON",
+			method:   "GET",
+			target:   "/key/board?zrangebyscore&max=10",
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"key":"board","members":[{"member":"cat","score":7.5}]}` + "\n",
+		},
+		{
+			name:     "Range by score up to infinity",
+			method:   "GET",
+			target:   "/key/board?zrangebyscore&min=10&max=%2Binf",
+			wantCode: 200,
+			wantBody: "amy\t20\nbob\t20\n",
+		},
+		{
+			name:     "Sorted set operation on the string",
+			method:   "POST",
+			target:   "/key/string",
+			form:     url.Values{zaddFormFieldName: {"amy"}},
+			wantCode: 409,
+			wantBody: "409 Key 'string' holds the wrong kind of value.\n",
+		},
+		{
+			name:     "Removing the members",
+			method:   "POST",
+			target:   "/key/board",
+			form:     url.Values{zremFormFieldName: {"amy", "bob", "cat"}},
+			wantCode: 200,
+			wantBody: "3",
+		},
+		{
+			name:     "The sorted set is removed along with its last member",
+			method:   "GET",
+			target:   "/key/board?zrange",
+			wantCode: 404,
+			wantBody: "404 There is no record in the storage for key 'board'.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.json {
+				r.Header.Set("Accept", jsonContentType)
+			}
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("urlHandler() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("urlHandler() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/tracing"
+)
+
+// contexter - the storage which traces its operations within the request's span
+type contexter interface {
+	WithContext(ctx context.Context) *kvstorage.KVStorage
+}
+
+// routes of the traced handlers, the spans are named after them
+const (
+	keyRoute = "/key/{key}"
+	txnRoute = "/txn"
+)
+
+// withTracing returns the handler which traces every request served by next as the span named after the route.
+// The span is the child of the caller's one from traceparent header, the client gets it in traceresponse header.
+// Nothing is traced if tracer is nil.
+func withTracing(tracer *tracing.Tracer, route string, next http.HandlerFunc) http.HandlerFunc {
+	if tracer == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		// the malformed header starts the new trace, as W3C Trace Context requires
+		if sc, err := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); err == nil {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+		ctx, span := tracer.Start(ctx, r.Method+" "+route, tracing.Server,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("http.route", route),
+			tracing.String("url.path", r.URL.Path),
+			tracing.String("client.address", r.RemoteAddr),
+		)
+		defer span.End()
+		if span == nil {
+			next(w, r.WithContext(ctx))
+			return
+		}
+		if name, _ := splitNamespace(r.URL.EscapedPath()); name != "" {
+			span.SetAttributes(tracing.String("kvserver.namespace", name))
+		}
+		if route == keyRoute {
+			// the key is traced as it is, even if it's not valid
+			key, _ := getKeyFromRequest(r)
+			span.SetAttributes(tracing.String("kvserver.key", key))
+		}
+		w.Header().Set(tracing.TraceresponseHeader, span.SpanContext().Traceparent())
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(tracing.Int("http.response.status_code", int64(rec.status)))
+		if rec.status >= 500 {
+			span.SetError(http.StatusText(rec.status))
+		}
+	}
+}
+
+// tracedStorage returns the view of the storage which operations are traced within the request's span,
+// see kvstorage.KVStorage.WithContext. It's false if the requests are not traced or the storage can't trace.
+func tracedStorage(stor interface{}, r *http.Request, conf *config) (*kvstorage.KVStorage, bool) {
+	c, ok := stor.(contexter)
+	if !ok || conf.tracer == nil {
+		return nil, false
+	}
+	return c.WithContext(r.Context()), true
+}
@@ -0,0 +1,95 @@
+package router
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/tracing"
+)
+
+// memoryExporter keeps the exported spans
+type memoryExporter struct {
+	mux   sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *memoryExporter) Export(spans []tracing.SpanData) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestWithTracer(t *testing.T) {
+	const caller = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	exporter := &memoryExporter{}
+	tracer := tracing.NewTracer(exporter)
+	stor := kvstorage.NewStorage(kvstorage.WithTracer(
+		func(ctx context.Context, op string, start, locked, unlocked time.Time) {
+			tracer.Locked(ctx, "kvstorage."+op, start, locked, unlocked)
+		},
+	))
+	keyHandler := GetURLrouter(stor, 60, WithTracer(tracer))
+	txnHandler := GetTxnRouter(stor, WithTracer(tracer))
+
+	r := httptest.NewRequest("POST", "/key/a", strings.NewReader("value=1"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(tracing.TraceparentHeader, caller)
+	w := httptest.NewRecorder()
+	keyHandler(w, r)
+	response, err := tracing.ParseTraceparent(w.Header().Get(tracing.TraceresponseHeader))
+	if err != nil {
+		t.Fatalf("traceresponse header %q: %v", w.Header().Get(tracing.TraceresponseHeader), err)
+	}
+	// the caller doesn't record the trace
+	r = httptest.NewRequest("GET", "/key/a", nil)
+	r.Header.Set(tracing.TraceparentHeader, caller[:len(caller)-2]+"00")
+	w = httptest.NewRecorder()
+	keyHandler(w, r)
+	if got := w.Header().Get(tracing.TraceresponseHeader); got != "" {
+		t.Errorf("traceresponse header = %q for the trace which is not sampled", got)
+	}
+	txnHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/txn?watch=a", nil))
+	tracer.Shutdown()
+
+	exporter.mux.Lock()
+	defer exporter.mux.Unlock()
+	want := []struct {
+		name   string
+		parent int // index of the parent span, -1 for the caller's one
+	}{
+		{name: "kvstorage.Set", parent: 1},
+		{name: "POST /key/{key}", parent: -1},
+		{name: "kvstorage.Watch", parent: 3},
+		{name: "GET /txn"},
+	}
+	if len(exporter.spans) != len(want) {
+		t.Fatalf("exported %v spans, want %v", len(exporter.spans), len(want))
+	}
+	remote, _ := tracing.ParseTraceparent(caller)
+	for i, span := range exporter.spans {
+		if span.Name != want[i].name {
+			t.Errorf("span %v name = %v, want %v", i, span.Name, want[i].name)
+		}
+		switch want[i].parent {
+		case -1:
+			if span.Parent != remote.SpanID || span.SpanContext != response {
+				t.Errorf("span %v is not the child of the caller's span or it's not in traceresponse header", i)
+			}
+		case 0:
+			if span.Parent != (tracing.SpanID{}) {
+				t.Errorf("span %v is not the root span", i)
+			}
+		default:
+			parent := exporter.spans[want[i].parent]
+			if span.Parent != parent.SpanID || span.TraceID != parent.TraceID {
+				t.Errorf("span %v is not the child of span %v", i, want[i].parent)
+			}
+		}
+	}
+}
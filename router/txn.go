@@ -0,0 +1,228 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+type transactor interface {
+	Exec(watched map[string]uint64, ops []kvstorage.TxOp) ([]kvstorage.TxResult, error)
+	Watch(keys ...string) (map[string]uint64, error)
+}
+
+const (
+	// URL's path for the transactions
+	txnPath = "txn"
+	// GET query parameter name, the key to watch, may be repeated
+	watchQueryName = "watch"
+)
+
+// maxTxnOps - the transaction holds the storage's lock while it's applied, so it's bounded
+const maxTxnOps = 1000
+
+const (
+	badTxnMessage = "400 Transaction must be JSON like " +
+		`{"ops": [{"op": "set", "key": "<key>", "value": "<value>"}]} with 1 to 1000 operations.` + "\n"
+	badWatchMessage       = "400 Keys to watch must be like /txn?watch=<key>&watch=<key> with 1 to 1000 keys.\n"
+	watchedChangedMessage = "409 Watched key '%v' has changed.\n"
+)
+
+// txnRequest - JSON body of the transaction's request
+type txnRequest struct {
+	// versions of the watched keys, the transaction fails if any of them has changed
+	Watch map[string]uint64 `json:"watch"`
+	Ops   []txnOp           `json:"ops"`
+}
+
+// txnOp - the operation of the transaction, see kvstorage.TxOp
+type txnOp struct {
+	Op      string `json:"op"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Version uint64 `json:"version"`
+	Exists  *bool  `json:"exists"`
+}
+
+// txnResult - the result of the operation in JSON response, see kvstorage.TxResult
+type txnResult struct {
+	Key     string  `json:"key"`
+	Value   *string `json:"value,omitempty"`
+	Found   bool    `json:"found"`
+	Version uint64  `json:"version"`
+}
+
+var txnOpKinds = map[string]kvstorage.TxOpKind{
+	"get":    kvstorage.TxGet,
+	"set":    kvstorage.TxSet,
+	"delete": kvstorage.TxDelete,
+}
+
+// GetTxnRouter returns HTTP handler which applies the operations of the transaction atomically.
+// Either all operations are applied or none.
+// GET request returns the versions of the keys to watch, the transaction is applied only if they are the same.
+func GetTxnRouter(stor transactor, opts ...Option) func(
+	w http.ResponseWriter, r *http.Request,
+) {
+	conf := newConfig(opts)
+	return withTracing(conf.tracer, txnRoute, func(w http.ResponseWriter, r *http.Request) {
+		_, path := splitNamespace(r.URL.Path)
+		if strings.Trim(path, "/") != txnPath {
+			writeError(w, r, 400, "") // Bad request
+			return
+		}
+		view := stor
+		if traced, ok := tracedStorage(stor, r, conf); ok {
+			view = traced
+		}
+		switch r.Method {
+		case http.MethodGet:
+			watchRequest(w, r, view, conf)
+		case http.MethodPost:
+			txnRequestHandler(w, r, view, conf)
+		default:
+			writeError(w, r, 400, "") // Bad request
+		}
+	})
+}
+
+// watchRequest writes the versions of the keys to watch, one key and its version per line
+func watchRequest(w http.ResponseWriter, r *http.Request, stor transactor, conf *config) {
+	keys := r.URL.Query()[watchQueryName]
+	if len(keys) == 0 || len(keys) > maxTxnOps {
+		writeErrorMessage(w, r, 400, badWatchMessage, "")
+		return
+	}
+	for _, key := range keys {
+		if len(key) == 0 || len(key) > conf.maxKeyLength {
+			writeErrorMessage(w, r, 400, badWatchMessage, "")
+			return
+		}
+	}
+	versions, err := stor.Watch(keys...)
+	if err != nil {
+		writeError(w, r, 500, "")
+		return
+	}
+	if wantsJSON(r) {
+		writeJSON(w, 200, map[string]interface{}{"watch": versions})
+		return
+	}
+	w.WriteHeader(200)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v\t%v\n", key, versions[key])
+	}
+}
+
+// txnRequestHandler applies the transaction
+func txnRequestHandler(w http.ResponseWriter, r *http.Request, stor transactor, conf *config) {
+	watched, ops, msg := parseTxn(r, conf)
+	if msg != "" {
+		// the message tells which operation is not valid, the code is the same for all of them
+		writeCodedError(w, r, 400, messageErrorCodes[badTxnMessage], msg, "")
+		return
+	}
+	results, err := stor.Exec(watched, ops)
+	if err != nil {
+		writeTxnError(w, r, ops, err)
+		return
+	}
+	writeTxnResults(w, r, ops, results)
+}
+
+// parseTxn returns the watched keys and the operations of the transaction,
+// or the message telling why the request is not valid
+func parseTxn(r *http.Request, conf *config) (map[string]uint64, []kvstorage.TxOp, string) {
+	var req txnRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil || len(req.Ops) == 0 || len(req.Ops) > maxTxnOps ||
+		len(req.Watch) > maxTxnOps {
+		return nil, nil, badTxnMessage
+	}
+	ops := make([]kvstorage.TxOp, 0, len(req.Ops))
+	for i, o := range req.Ops {
+		kind, ok := txnOpKinds[o.Op]
+		switch {
+		case !ok:
+			return nil, nil, fmt.Sprintf("400 Operation %v of the transaction is unknown, it must be get, set or delete.\n", i)
+		case len(o.Key) == 0:
+			return nil, nil, fmt.Sprintf("400 Key of the operation %v of the transaction is missing.\n", i)
+		case len(o.Key) > conf.maxKeyLength:
+			return nil, nil, fmt.Sprintf("400 Key of the operation %v of the transaction is longer than %v bytes.\n", i, conf.maxKeyLength)
+		case kind == kvstorage.TxSet && o.Value == "":
+			return nil, nil, fmt.Sprintf("400 Value of the operation %v of the transaction is missing.\n", i)
+		}
+		ops = append(ops, kvstorage.TxOp{
+			Kind: kind, Key: o.Key, Value: o.Value, Version: o.Version, Mode: existsMode(o.Exists),
+		})
+	}
+	return req.Watch, ops, ""
+}
+
+// existsMode returns the mode of the write for the precondition telling whether the key must be in the storage,
+// nil means no precondition
+func existsMode(exists *bool) kvstorage.SetMode {
+	switch {
+	case exists == nil:
+		return kvstorage.Upsert
+	case *exists:
+		return kvstorage.IfExists
+	}
+	return kvstorage.IfAbsent
+}
+
+// writeTxnError writes the error response telling which operation has failed the transaction
+func writeTxnError(w http.ResponseWriter, r *http.Request, ops []kvstorage.TxOp, err error) {
+	var watchErr *kvstorage.WatchError
+	if errors.As(err, &watchErr) {
+		writeErrorMessage(w, r, 409, watchedChangedMessage, watchErr.Key)
+		return
+	}
+	var txErr *kvstorage.TxError
+	rep, code := storageError(err)
+	if !errors.As(err, &txErr) || code == 500 {
+		writeError(w, r, 500, "")
+		return
+	}
+	msg := httpStatusCodeMessages[code]
+	if rep != nil {
+		msg = rep.text
+	}
+	// the same message and code as for the single operation on the key, the key is substituted later
+	errCode := errorCode(code, msg)
+	reason := errorText(code, msg)
+	msg = fmt.Sprintf("%v Operation %v of the transaction failed: %v\n", code, txErr.Index, reason)
+	writeCodedError(w, r, code, errCode, msg, ops[txErr.Index].Key)
+}
+
+// writeTxnResults writes the results of the operations, one operation per line:
+// 1 or 0 telling whether the key was in the storage, element's version after the operation
+// and the value for get, all separated by tab
+func writeTxnResults(w http.ResponseWriter, r *http.Request, ops []kvstorage.TxOp, results []kvstorage.TxResult) {
+	if wantsJSON(r) {
+		body := make([]txnResult, 0, len(results))
+		for i, res := range results {
+			result := txnResult{Key: res.Key, Found: res.Found, Version: res.Version}
+			if ops[i].Kind == kvstorage.TxGet {
+				value := res.Value
+				result.Value = &value
+			}
+			body = append(body, result)
+		}
+		writeJSON(w, 200, map[string]interface{}{"results": body})
+		return
+	}
+	w.WriteHeader(200)
+	for i, res := range results {
+		fmt.Fprintf(w, "%v\t%v", boolText(res.Found), res.Version)
+		if ops[i].Kind == kvstorage.TxGet {
+			fmt.Fprintf(w, "\t%v", res.Value)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,156 @@
+package router
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/proway2/kvserver/kvstorage"
+)
+
+func TestGetTxnRouter(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("from", "100"); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetTxnRouter(storage, WithMaxKeyLength(8))
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		json     bool
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Moving the value",
+			method:   "POST",
+			body:     `{"ops": [{"op": "get", "key": "from", "version": 1}, {"op": "set", "key": "to", "value": "100", "exists": false}, {"op": "delete", "key": "from"}]}`,
+			wantCode: 200,
+			wantBody: "1\t1\t100\n0\t2\n1\t0\n",
+		},
+		{
+			name:     "Moving the value as JSON",
+			method:   "POST",
+			body:     `{"ops": [{"op": "set", "key": "from", "value": "100", "exists": false}, {"op": "delete", "key": "to"}, {"op": "get", "key": "to"}]}`,
+			json:     true,
+			wantCode: 200,
+			wantBody: `{"results":[{"key":"from","found":false,"version":3},{"key":"to","found":true,"version":0},{"key":"to","value":"","found":false,"version":0}]}` + "\n",
+		},
+		{
+			name:     "Version doesn't match",
+			method:   "POST",
+			body:     `{"ops": [{"op": "set", "key": "to", "value": "1"}, {"op": "set", "key": "from", "value": "1", "version": 1}]}`,
+			wantCode: 409,
+			wantBody: "409 Operation 1 of the transaction failed: Version of key 'from' doesn't match.\n",
+		},
+		{
+			name:     "Version doesn't match as JSON",
+			method:   "POST",
+			body:     `{"ops": [{"op": "set", "key": "to", "value": "1"}, {"op": "set", "key": "from", "value": "1", "version": 1}]}`,
+			json:     true,
+			wantCode: 409,
+			wantBody: `{"error":{"status":409,"code":"version_mismatch",` +
+				`"message":"Operation 1 of the transaction failed: Version of key 'from' doesn't match."}}` + "\n",
+		},
+		{
+			name:     "Key is not found",
+			method:   "POST",
+			body:     `{"ops": [{"op": "delete", "key": "to", "exists": true}]}`,
+			json:     true,
+			wantCode: 404,
+			wantBody: `{"error":{"status":404,"code":"not_found","message":"Operation 0 of the transaction failed: There is no record in the storage for key 'to'."}}` + "\n",
+		},
+		{
+			name:     "Unknown operation",
+			method:   "POST",
+			body:     `{"ops": [{"op": "get", "key": "from"}, {"op": "incr", "key": "from"}]}`,
+			wantCode: 400,
+			wantBody: "400 Operation 1 of the transaction is unknown, it must be get, set or delete.\n",
+		},
+		{
+			name:     "Key is too long",
+			method:   "POST",
+			body:     `{"ops": [{"op": "get", "key": "very long key"}]}`,
+			wantCode: 400,
+			wantBody: "400 Key of the operation 0 of the transaction is longer than 8 bytes.\n",
+		},
+		{
+			name:     "Not a JSON",
+			method:   "POST",
+			body:     `op=get&key=from`,
+			wantCode: 400,
+			wantBody: badTxnMessage,
+		},
+		{
+			name:     "Incorrect HTTP method",
+			method:   "PUT",
+			wantCode: 400,
+			wantBody: "400 Malformed request.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/txn", strings.NewReader(tt.body))
+			if tt.json {
+				r.Header.Set("Accept", jsonContentType)
+			}
+			handler(rec, r)
+			if rec.Code != tt.wantCode {
+				t.Errorf("GetTxnRouter() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("GetTxnRouter() body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+	if val, _ := storage.Get("from"); string(val) != "100" {
+		t.Errorf("value = %q, want %q", val, "100")
+	}
+}
+
+func TestGetTxnRouter_watch(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	if err := storage.Set("counter", "1"); err != nil {
+		t.Fatal(err)
+	}
+	handler := GetTxnRouter(storage)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do("GET", "/txn?watch=counter&watch=missing", "")
+	if rec.Code != 200 || rec.Body.String() != "counter\t1\nmissing\t0\n" {
+		t.Errorf("watch = %v, %q", rec.Code, rec.Body.String())
+	}
+	if rec = do("GET", "/txn", ""); rec.Code != 400 || rec.Body.String() != badWatchMessage {
+		t.Errorf("watch without keys = %v, %q", rec.Code, rec.Body.String())
+	}
+
+	commit := `{"watch": {"counter": 1, "missing": 0}, "ops": [{"op": "set", "key": "counter", "value": "2"}]}`
+	// somebody else creates the missing key in between
+	if err := storage.Set("missing", "1"); err != nil {
+		t.Fatal(err)
+	}
+	rec = do("POST", "/txn", commit)
+	if rec.Code != 409 || rec.Body.String() != "409 Watched key 'missing' has changed.\n" {
+		t.Errorf("commit = %v, %q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/txn", strings.NewReader(commit))
+	r.Header.Set("Accept", jsonContentType)
+	if handler(rec, r); !strings.Contains(rec.Body.String(), `"code":"watch_conflict"`) {
+		t.Errorf("commit = %v, %q", rec.Code, rec.Body.String())
+	}
+	if _, err := storage.Delete("missing"); err != nil {
+		t.Fatal(err)
+	}
+	rec = do("POST", "/txn", commit)
+	if rec.Code != 200 || rec.Body.String() != "1\t3\n" {
+		t.Errorf("commit = %v, %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,421 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/proway2/kvserver/element"
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/pubsub"
+)
+
+const (
+	// maxWebSocketMessage - the client's message is bounded, since it's read into memory as a whole, bytes
+	maxWebSocketMessage = 1 << 20
+	// writeWait - the client which doesn't read its messages for so long is disconnected
+	writeWait = 10 * time.Second
+)
+
+const (
+	badWebSocketMessage = "400 Message must be JSON like " +
+		`{"id": 1, "op": "get", "key": "<key>"} with op get, set, delete, touch, expireat, persist, watch, unwatch, ` +
+		"subscribe or unsubscribe.\n"
+	webSocketKeyMissingMessage  = "400 Key is missing.\n"
+	tooManyWatchesMessage       = "400 Connection watches 1000 keys already.\n"
+	badWebSocketSubscription    = "400 Subscription must have either channel or pattern up to %v bytes.\n"
+	notSubscribedMessage        = "404 Connection is not subscribed to '%v'.\n"
+	tooManySubscriptionsMessage = "400 Connection is subscribed to 1000 channels and patterns already.\n"
+)
+
+// wsRequest - the client's message, the operation on the key
+type wsRequest struct {
+	// correlation ID, it's returned as is in the reply, so the client may have several requests in flight
+	ID      json.RawMessage `json:"id,omitempty"`
+	Op      string          `json:"op"`
+	Key     string          `json:"key"`
+	Value   string          `json:"value"`
+	Exists  *bool           `json:"exists"`  // set only: the key must or must not be in the storage
+	Expires string          `json:"expires"` // expireat only: Unix time, secs or RFC 3339
+	// subscribe and unsubscribe only: either the channel or the pattern of the channels
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern"`
+}
+
+// wsTopic - the channel or the pattern the connection is subscribed to
+type wsTopic struct {
+	Channel string `json:"channel,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// name returns either the channel or the pattern
+func (t wsTopic) name() string {
+	if t.Pattern != "" {
+		return t.Pattern
+	}
+	return t.Channel
+}
+
+// wsReply - the reply to the client's message
+type wsReply struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Status int             `json:"status"`
+	Result interface{}     `json:"result,omitempty"`
+	Error  *wsError        `json:"error,omitempty"`
+}
+
+// wsError - the error of the operation, the same as the one of JSON response
+type wsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// wsEvent - the change of the watched key, version 0 means the key is removed.
+// The overflow event means the key is not watched anymore, since the changes came faster than the client read them.
+type wsEvent struct {
+	Event   string  `json:"event"`
+	Key     string  `json:"key"`
+	Version *uint64 `json:"version,omitempty"`
+}
+
+// wsMessage - the message published to the subscribed channel, the same as the event of /subscribe.
+// The overflow event means the subscription is over, since the messages came faster than the client read them.
+type wsMessage struct {
+	Event   string  `json:"event"`
+	Channel string  `json:"channel,omitempty"`
+	Pattern string  `json:"pattern,omitempty"`
+	Message *string `json:"message,omitempty"`
+}
+
+// wsOp processes the client's message and returns the reply and HTTP code, the same as typeRequest
+type wsOp func(c *wsConn, req *wsRequest) (*reply, int)
+
+var wsOps = map[string]wsOp{
+	"get":      wsGet,
+	"set":      wsSet,
+	"delete":   func(c *wsConn, req *wsRequest) (*reply, int) { return foundReply(c.stor.Delete(req.Key)) },
+	"touch":    func(c *wsConn, req *wsRequest) (*reply, int) { return foundReply(c.stor.Touch(req.Key)) },
+	"expireat": wsExpireAt,
+	"persist":  func(c *wsConn, req *wsRequest) (*reply, int) { return foundReply(c.stor.Persist(req.Key)) },
+	"watch":    wsWatch,
+	"unwatch":  wsUnwatch,
+}
+
+// wsTopicOps - the operations on the channels instead of the keys
+var wsTopicOps = map[string]wsOp{
+	"subscribe":   wsSubscribe,
+	"unsubscribe": wsUnsubscribe,
+}
+
+// wsConn - the client's connection
+type wsConn struct {
+	conn *websocket.Conn
+	stor readerWriter
+	bus  subscriber
+	ttl  time.Duration
+	conf *config
+	// messages are written by both the reading loop and the watches
+	writeMux sync.Mutex
+	// watched keys and subscribed channels, these are used by the reading loop only
+	watches map[string]*pubsub.Subscription
+	topics  map[wsTopic]*pubsub.Subscription
+}
+
+// GetWebSocketRouter returns HTTP handler which upgrades the connection to WebSocket.
+// Every client's message is JSON with the operation on the key, the reply has the same ID as the message.
+// Changes of the watched keys come from the bus as the messages published by pubsub.Bus.KeyspaceNotifier,
+// the client may subscribe to the other channels of the bus as well.
+// ttl is the element's lifetime in the storage, secs, see GetURLrouter.
+func GetWebSocketRouter(stor readerWriter, bus subscriber, ttl uint64, opts ...Option) func(
+	w http.ResponseWriter, r *http.Request,
+) {
+	conf := newConfig(opts)
+	upgrader := websocket.Upgrader{CheckOrigin: originChecker(conf.allowedOrigins)}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			writeError(w, r, 400, "") // Bad request
+			return
+		}
+		// the upgrader writes the error response itself
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c := &wsConn{
+			conn:    conn,
+			stor:    stor,
+			bus:     bus,
+			ttl:     time.Duration(ttl) * time.Second,
+			conf:    conf,
+			watches: make(map[string]*pubsub.Subscription),
+			topics:  make(map[wsTopic]*pubsub.Subscription),
+		}
+		c.serve()
+	}
+}
+
+// serve processes the client's messages one by one until the client disconnects
+func (c *wsConn) serve() {
+	defer c.conn.Close()
+	defer func() {
+		for _, sub := range c.watches {
+			sub.Close()
+		}
+		for _, sub := range c.topics {
+			sub.Close()
+		}
+	}()
+	c.conn.SetReadLimit(maxWebSocketMessage)
+	done := make(chan struct{})
+	defer close(done)
+	go c.keepAlive(done)
+	for {
+		kind, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if kind != websocket.TextMessage || json.Unmarshal(data, &req) != nil {
+			c.reply(&req, &reply{text: badWebSocketMessage}, 400)
+			continue
+		}
+		rep, code := c.process(&req)
+		c.reply(&req, rep, code)
+	}
+}
+
+// process validates the client's message and applies the operation
+func (c *wsConn) process(req *wsRequest) (*reply, int) {
+	if op, ok := wsTopicOps[req.Op]; ok {
+		if (req.Channel == "") == (req.Pattern == "") || len(req.Channel+req.Pattern) > c.conf.maxKeyLength {
+			return &reply{text: badWebSocketSubscription}, 400
+		}
+		return op(c, req)
+	}
+	op, ok := wsOps[req.Op]
+	switch {
+	case !ok:
+		return &reply{text: badWebSocketMessage}, 400
+	case len(req.Key) == 0:
+		return &reply{text: webSocketKeyMissingMessage}, 400
+	case len(req.Key) > c.conf.maxKeyLength:
+		return &reply{text: keyTooLongMessage}, 400
+	}
+	return op(c, req)
+}
+
+// reply writes the reply to the client's message, either the result or the error
+func (c *wsConn) reply(req *wsRequest, rep *reply, code int) {
+	msg := wsReply{ID: req.ID, Status: code}
+	switch {
+	case code == 200 && rep != nil:
+		msg.Result = rep.json
+	case code == 200:
+		msg.Result = map[string]string{"key": req.Key}
+	default:
+		text := httpStatusCodeMessages[code]
+		if rep != nil {
+			text = rep.text
+		}
+		errCode := errorCode(code, text)
+		arg := req.Key
+		switch text {
+		case keyTooLongMessage, badWebSocketSubscription:
+			arg = strconv.Itoa(c.conf.maxKeyLength)
+		case notSubscribedMessage:
+			arg = wsTopic{Channel: req.Channel, Pattern: req.Pattern}.name()
+		}
+		if strings.Contains(text, "%v") {
+			text = fmt.Sprintf(text, arg)
+		}
+		msg.Error = &wsError{Code: errCode, Message: errorText(code, text)}
+	}
+	c.write(msg)
+}
+
+// write sends the message to the client, the connection is closed if the client doesn't read it in time
+func (c *wsConn) write(msg interface{}) {
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.conn.WriteJSON(msg); err != nil {
+		// the reading loop fails as well, so the connection is cleaned up
+		c.conn.Close()
+	}
+}
+
+// keepAlive pings the client while the connection is idle, so the proxies don't drop it
+func (c *wsConn) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// wsGet returns the value of the key, the same as GET request
+func wsGet(c *wsConn, req *wsRequest) (*reply, int) {
+	elem, found, err := c.stor.Lookup(req.Key)
+	if err != nil {
+		return nil, 500
+	}
+	if !found {
+		return nil, 404
+	}
+	if elem.Kind != element.String {
+		return storageError(kvstorage.ErrWrongType)
+	}
+	return valueReply(newValue(req.Key, elem, lifetime(c.stor, c.ttl), c.conf.clock.Now())), 200
+}
+
+// wsSet stores the value of the key, the same as POST request with the value
+func wsSet(c *wsConn, req *wsRequest) (*reply, int) {
+	if req.Value == "" {
+		return nil, 400
+	}
+	if err := c.stor.Set(req.Key, req.Value, existsMode(req.Exists)); err != nil {
+		return storageError(err)
+	}
+	return nil, 200
+}
+
+// wsExpireAt sets absolute expiration time of the key
+func wsExpireAt(c *wsConn, req *wsRequest) (*reply, int) {
+	expires, err := parseTime(req.Expires)
+	if err != nil {
+		return nil, 400
+	}
+	return foundReply(c.stor.ExpireAt(req.Key, expires))
+}
+
+// wsWatch sends the changes of the key to the client until it's unwatched.
+// The result has the current version of the key, so the client knows which changes come after it.
+func wsWatch(c *wsConn, req *wsRequest) (*reply, int) {
+	if sub, ok := c.watches[req.Key]; !ok || sub.Overflowed() {
+		if !ok && len(c.watches) >= maxSubscriptions {
+			return &reply{text: tooManyWatchesMessage}, 400
+		}
+		// the key is subscribed to before its version is read, so no change is missed
+		sub, err := c.bus.Subscribe([]string{pubsub.KeyspacePrefix + req.Key}, nil)
+		if err != nil {
+			return nil, 500
+		}
+		c.watches[req.Key] = sub
+		go c.forward(req.Key, sub)
+	}
+	elem, found, err := c.stor.Lookup(req.Key)
+	if err != nil {
+		return nil, 500
+	}
+	var version uint64
+	if found {
+		version = elem.Version
+	}
+	return &reply{json: map[string]interface{}{"key": req.Key, "version": version}}, 200
+}
+
+// wsUnwatch stops sending the changes of the key
+func wsUnwatch(c *wsConn, req *wsRequest) (*reply, int) {
+	sub, ok := c.watches[req.Key]
+	if !ok {
+		return nil, 404
+	}
+	sub.Close()
+	delete(c.watches, req.Key)
+	return nil, 200
+}
+
+// wsSubscribe sends the messages of the channel or of the channels matching the pattern to the client
+// until it unsubscribes
+func wsSubscribe(c *wsConn, req *wsRequest) (*reply, int) {
+	topic := wsTopic{Channel: req.Channel, Pattern: req.Pattern}
+	if sub, ok := c.topics[topic]; !ok || sub.Overflowed() {
+		if !ok && len(c.topics) >= maxSubscriptions {
+			return &reply{text: tooManySubscriptionsMessage}, 400
+		}
+		var channels, patterns []string
+		if topic.Pattern != "" {
+			patterns = []string{topic.Pattern}
+		} else {
+			channels = []string{topic.Channel}
+		}
+		sub, err := c.bus.Subscribe(channels, patterns)
+		if err != nil {
+			return nil, 500
+		}
+		c.topics[topic] = sub
+		go c.forwardMessages(topic, sub)
+	}
+	return &reply{json: topic}, 200
+}
+
+// wsUnsubscribe stops sending the messages of the channel or the pattern
+func wsUnsubscribe(c *wsConn, req *wsRequest) (*reply, int) {
+	topic := wsTopic{Channel: req.Channel, Pattern: req.Pattern}
+	sub, ok := c.topics[topic]
+	if !ok {
+		return &reply{text: notSubscribedMessage}, 404
+	}
+	sub.Close()
+	delete(c.topics, topic)
+	return &reply{json: topic}, 200
+}
+
+// forwardMessages sends the messages of the subscribed channel or pattern to the client
+func (c *wsConn) forwardMessages(topic wsTopic, sub *pubsub.Subscription) {
+	for msg := range sub.C {
+		payload := msg.Payload
+		c.write(wsMessage{Event: "message", Channel: msg.Channel, Pattern: msg.Pattern, Message: &payload})
+	}
+	if sub.Overflowed() {
+		c.write(wsMessage{Event: "overflow", Channel: topic.Channel, Pattern: topic.Pattern})
+	}
+}
+
+// forward sends the changes of the watched key to the client
+func (c *wsConn) forward(key string, sub *pubsub.Subscription) {
+	for msg := range sub.C {
+		version, err := strconv.ParseUint(msg.Payload, 10, 64)
+		if err != nil {
+			continue
+		}
+		c.write(wsEvent{Event: "change", Key: key, Version: &version})
+	}
+	if sub.Overflowed() {
+		c.write(wsEvent{Event: "overflow", Key: key})
+	}
+}
+
+// originChecker returns the function which allows the upgrade for the server's own origin
+// and the allowed ones
+func originChecker(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// not a browser
+			return true
+		}
+		for _, o := range allowed {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
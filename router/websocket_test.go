@@ -0,0 +1,267 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/proway2/kvserver/kvstorage"
+	"github.com/proway2/kvserver/pubsub"
+)
+
+func TestGetWebSocketRouter(t *testing.T) {
+	bus := pubsub.NewBus()
+	storage := kvstorage.NewStorage(kvstorage.WithNotifier(bus.KeyspaceNotifier()))
+	if _, err := storage.HSet("hash", "field", correctValue); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(GetWebSocketRouter(storage, bus, 0, WithMaxKeyLength(16))))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{
+			name:    "Setting the value",
+			message: `{"id": 1, "op": "set", "key": "key", "value": "value"}`,
+			want:    `{"id":1,"status":200,"result":{"key":"key"}}`,
+		},
+		{
+			name:    "Creating existing key",
+			message: `{"id": "a", "op": "set", "key": "key", "value": "other", "exists": false}`,
+			want:    `{"id":"a","status":409,"error":{"code":"key_exists","message":"'key' already exists."}}`,
+		},
+		{
+			name:    "Watching the key",
+			message: `{"id": 2, "op": "watch", "key": "key"}`,
+			want:    `{"id":2,"status":200,"result":{"key":"key","version":2}}`,
+		},
+		{
+			name:    "Watched key is updated",
+			message: `{"id": 3, "op": "set", "key": "key", "value": "new", "exists": true}`,
+			want: `{"event":"change","key":"key","version":3}` + "\n" +
+				`{"id":3,"status":200,"result":{"key":"key"}}`,
+		},
+		{
+			name:    "Watched key is deleted",
+			message: `{"id": 4, "op": "delete", "key": "key"}`,
+			want: `{"event":"change","key":"key","version":0}` + "\n" +
+				`{"id":4,"status":200,"result":{"key":"key"}}`,
+		},
+		{
+			name:    "Unwatching the key",
+			message: `{"id": 5, "op": "unwatch", "key": "key"}`,
+			want:    `{"id":5,"status":200,"result":{"key":"key"}}`,
+		},
+		{
+			name:    "Unwatched key is not reported",
+			message: `{"id": 6, "op": "set", "key": "key", "value": "again"}`,
+			want:    `{"id":6,"status":200,"result":{"key":"key"}}`,
+		},
+		{
+			name:    "Getting missing key",
+			message: `{"id": 7, "op": "get", "key": "missing"}`,
+			want: `{"id":7,"status":404,"error":{"code":"not_found",` +
+				`"message":"There is no record in the storage for key 'missing'."}}`,
+		},
+		{
+			name:    "Getting the hash",
+			message: `{"id": 8, "op": "get", "key": "hash"}`,
+			want: `{"id":8,"status":409,"error":{"code":"wrong_type",` +
+				`"message":"Key 'hash' holds the wrong kind of value."}}`,
+		},
+		{
+			name:    "Key is too long",
+			message: `{"id": 9, "op": "get", "key": "` + strings.Repeat("a", 17) + `"}`,
+			want:    `{"id":9,"status":400,"error":{"code":"key_too_long","message":"Key is longer than 16 bytes."}}`,
+		},
+		{
+			name:    "Unknown operation",
+			message: `{"id": 10, "op": "incr", "key": "key"}`,
+			want: `{"id":10,"status":400,"error":{"code":"bad_message","message":` +
+				`"Message must be JSON like {\"id\": 1, \"op\": \"get\", \"key\": \"\u003ckey\u003e\"} ` +
+				`with op get, set, delete, touch, expireat, persist, watch, unwatch, subscribe or unsubscribe."}}`,
+		},
+		{
+			name:    "Malformed message",
+			message: `{"id": 11`,
+			want: `{"status":400,"error":{"code":"bad_message","message":` +
+				`"Message must be JSON like {\"id\": 1, \"op\": \"get\", \"key\": \"\u003ckey\u003e\"} ` +
+				`with op get, set, delete, touch, expireat, persist, watch, unwatch, subscribe or unsubscribe."}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.message)); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for len(got) < strings.Count(tt.want, "\n")+1 {
+				_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatalf("reading the reply: %v", err)
+				}
+				got = append(got, strings.TrimSpace(string(data)))
+			}
+			// the change of the watched key may come either before or after the reply
+			sort.Strings(got)
+			want := strings.Split(tt.want, "\n")
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("reply = %v, want %v", strings.Join(got, "\n"), tt.want)
+			}
+		})
+	}
+}
+
+func TestGetWebSocketRouter_subscribe(t *testing.T) {
+	bus := pubsub.NewBus()
+	server := httptest.NewServer(http.HandlerFunc(GetWebSocketRouter(kvstorage.NewStorage(), bus, 0,
+		WithMaxKeyLength(16))))
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name    string
+		message string
+		publish string // the channel the message is published to after the reply
+		want    string
+	}{
+		{
+			name:    "Subscribing to the channel",
+			message: `{"id": 1, "op": "subscribe", "channel": "news.world"}`,
+			want:    `{"id":1,"status":200,"result":{"channel":"news.world"}}`,
+		},
+		{
+			name:    "Subscribing to the pattern",
+			message: `{"id": 2, "op": "subscribe", "pattern": "news.*"}`,
+			publish: "news.world",
+			want: `{"id":2,"status":200,"result":{"pattern":"news.*"}}` + "\n" +
+				`{"event":"message","channel":"news.world","message":"hello"}` + "\n" +
+				`{"event":"message","channel":"news.world","pattern":"news.*","message":"hello"}`,
+		},
+		{
+			name:    "Unsubscribing from the channel",
+			message: `{"id": 3, "op": "unsubscribe", "channel": "news.world"}`,
+			publish: "news.sport",
+			want: `{"id":3,"status":200,"result":{"channel":"news.world"}}` + "\n" +
+				`{"event":"message","channel":"news.sport","pattern":"news.*","message":"hello"}`,
+		},
+		{
+			name:    "Unsubscribing from the channel which is not subscribed to",
+			message: `{"id": 4, "op": "unsubscribe", "channel": "news.world"}`,
+			want: `{"id":4,"status":404,"error":{"code":"not_subscribed",` +
+				`"message":"Connection is not subscribed to 'news.world'."}}`,
+		},
+		{
+			name:    "Both channel and pattern",
+			message: `{"id": 5, "op": "subscribe", "channel": "news", "pattern": "news.*"}`,
+			want: `{"id":5,"status":400,"error":{"code":"bad_subscription",` +
+				`"message":"Subscription must have either channel or pattern up to 16 bytes."}}`,
+		},
+		{
+			name:    "Channel is too long",
+			message: `{"id": 6, "op": "subscribe", "channel": "` + strings.Repeat("a", 17) + `"}`,
+			want: `{"id":6,"status":400,"error":{"code":"bad_subscription",` +
+				`"message":"Subscription must have either channel or pattern up to 16 bytes."}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.message)); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for len(got) < strings.Count(tt.want, "\n")+1 {
+				_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatalf("reading the reply: %v", err)
+				}
+				got = append(got, strings.TrimSpace(string(data)))
+				if len(got) == 1 && tt.publish != "" {
+					// the subscription is made once the reply is sent
+					bus.Publish(tt.publish, "hello")
+				}
+			}
+			// the messages of the channel and of the pattern come in any order
+			sort.Strings(got)
+			want := strings.Split(tt.want, "\n")
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("reply = %v, want %v", strings.Join(got, "\n"), tt.want)
+			}
+		})
+	}
+}
+
+func TestGetWebSocketRouter_origin(t *testing.T) {
+	storage := kvstorage.NewStorage()
+	bus := pubsub.NewBus()
+	tests := []struct {
+		name    string
+		opts    []Option
+		origin  string
+		wantErr bool
+	}{
+		{name: "Not a browser", origin: ""},
+		{name: "Server's own origin", origin: "http://{host}"},
+		{name: "Other origin", origin: "https://dashboard.example.com", wantErr: true},
+		{
+			name:   "Allowed origin",
+			opts:   []Option{WithAllowedOrigins("https://dashboard.example.com")},
+			origin: "https://dashboard.example.com",
+		},
+		{name: "Any origin", opts: []Option{WithAllowedOrigins("*")}, origin: "https://dashboard.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(GetWebSocketRouter(storage, bus, 0, tt.opts...)))
+			defer server.Close()
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", strings.Replace(tt.origin, "{host}", strings.TrimPrefix(server.URL, "http://"), 1))
+			}
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dial() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+		})
+	}
+}
+
+func Test_wsReply_id(t *testing.T) {
+	// correlation ID is returned as is, whatever JSON value it is
+	for _, id := range []string{`1`, `"req-1"`, `{"n":1}`} {
+		var req wsRequest
+		if err := json.Unmarshal([]byte(`{"id":`+id+`}`), &req); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := json.Marshal(wsReply{ID: req.ID, Status: 200})
+		if want := `{"id":` + id + `,"status":200}`; string(got) != want {
+			t.Errorf("wsReply = %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package skiplist - the members ordered by their scores, the ones with the same score are
+// in lexicographical order. Adding and removing a member, finding the member by its rank
+// or the first member with the score not less than the given one take O(log n).
+package skiplist
+
+import "math/rand"
+
+const (
+	// maxLevel is enough for 4^32 members
+	maxLevel = 32
+	// the node is promoted to the next level with probability of 1/4
+	promoteBits = 2
+)
+
+// Node - the member of the list along with its score
+type Node struct {
+	member   string
+	score    float64
+	backward *Node
+	levels   []level
+}
+
+// level - the link to the next node of the level, span is the number of the nodes it skips plus one
+type level struct {
+	forward *Node
+	span    int
+}
+
+// Member returns the node's member
+func (n *Node) Member() string {
+	return n.member
+}
+
+// Score returns the node's score
+func (n *Node) Score() float64 {
+	return n.score
+}
+
+// Next returns the node with the next rank, nil for the last one
+func (n *Node) Next() *Node {
+	return n.levels[0].forward
+}
+
+// List - the skip list with the span of every link, so the rank of the node is the sum of the spans
+// on the way to it. The members are unique, the caller keeps their scores to find them.
+type List struct {
+	head   *Node
+	tail   *Node
+	length int
+	level  int
+}
+
+// New returns the empty list
+func New() *List {
+	return &List{head: &Node{levels: make([]level, maxLevel)}, level: 1}
+}
+
+// Len returns the number of the members
+func (l *List) Len() int {
+	return l.length
+}
+
+// less reports whether the node goes before the member with the score
+func (n *Node) less(member string, score float64) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// Insert adds the member with the score, the member must not be in the list
+func (l *List) Insert(member string, score float64) {
+	var update [maxLevel]*Node
+	var rank [maxLevel]int
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && x.levels[i].forward.less(member, score) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	lvl := randomLevel()
+	if lvl > l.level {
+		for i := l.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = l.head
+			update[i].levels[i].span = l.length
+		}
+		l.level = lvl
+	}
+	x = &Node{member: member, score: score, levels: make([]level, lvl)}
+	for i := 0; i < lvl; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	// the links above the node's level skip it
+	for i := lvl; i < l.level; i++ {
+		update[i].levels[i].span++
+	}
+	if update[0] != l.head {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		l.tail = x
+	}
+	l.length++
+}
+
+// Delete removes the member with the score, it returns false if there is no such member
+func (l *List) Delete(member string, score float64) bool {
+	var update [maxLevel]*Node
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.less(member, score) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.levels[0].forward
+	if x == nil || x.member != member || x.score != score {
+		return false
+	}
+	for i := 0; i < l.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		l.tail = x.backward
+	}
+	for l.level > 1 && l.head.levels[l.level-1].forward == nil {
+		l.level--
+	}
+	l.length--
+	return true
+}
+
+// ByRank returns the node of the rank, 0 is the member with the lowest score; nil if the rank is out of range
+func (l *List) ByRank(rank int) *Node {
+	if rank < 0 || rank >= l.length {
+		return nil
+	}
+	// the ranks of the links are counted from 1, the head is 0
+	traversed := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// First returns the first node with the score not less than min, nil if there is no such node
+func (l *List) First(min float64) *Node {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.score < min {
+			x = x.levels[i].forward
+		}
+	}
+	return x.levels[0].forward
+}
+
+func randomLevel() int {
+	lvl := 1
+	for lvl < maxLevel && rand.Uint32()&(1<<promoteBits-1) == 0 {
+		lvl++
+	}
+	return lvl
+}
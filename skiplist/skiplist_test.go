@@ -0,0 +1,113 @@
+package skiplist
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// member - the reference the list is compared with
+type member struct {
+	name  string
+	score float64
+}
+
+func sorted(scores map[string]float64) []member {
+	members := make([]member, 0, len(scores))
+	for name, score := range scores {
+		members = append(members, member{name: name, score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].score != members[j].score {
+			return members[i].score < members[j].score
+		}
+		return members[i].name < members[j].name
+	})
+	return members
+}
+
+// check compares every rank of the list and the way back with the reference
+func check(t *testing.T, l *List, scores map[string]float64) {
+	t.Helper()
+	want := sorted(scores)
+	if l.Len() != len(want) {
+		t.Fatalf("List.Len() = %v, want %v", l.Len(), len(want))
+	}
+	for rank, m := range want {
+		n := l.ByRank(rank)
+		if n == nil || n.Member() != m.name || n.Score() != m.score {
+			t.Fatalf("List.ByRank(%v) = %+v, want %+v", rank, n, m)
+		}
+	}
+	i := 0
+	for n := l.ByRank(0); n != nil; n = n.Next() {
+		i++
+	}
+	if i != len(want) {
+		t.Fatalf("List has %v nodes by Next(), want %v", i, len(want))
+	}
+	i = len(want)
+	for n := l.tail; n != nil; n = n.backward {
+		i--
+		if n.member != want[i].name {
+			t.Fatalf("backward link of rank %v = %v, want %v", i, n.member, want[i].name)
+		}
+	}
+	if l.ByRank(-1) != nil || l.ByRank(len(want)) != nil {
+		t.Error("List.ByRank() returns the node out of range")
+	}
+}
+
+func TestList(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	l := New()
+	scores := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		name := strconv.Itoa(rnd.Intn(500))
+		// few distinct scores, so many members have the same one
+		score := float64(rnd.Intn(50))
+		if old, ok := scores[name]; ok {
+			if !l.Delete(name, old) {
+				t.Fatalf("List.Delete(%v, %v) = false", name, old)
+			}
+			delete(scores, name)
+		}
+		if rnd.Intn(3) > 0 {
+			l.Insert(name, score)
+			scores[name] = score
+		}
+		if i%500 == 0 {
+			check(t, l, scores)
+		}
+	}
+	check(t, l, scores)
+	if l.Delete("missing", 1) {
+		t.Error("List.Delete() = true for missing member")
+	}
+}
+
+func TestList_First(t *testing.T) {
+	l := New()
+	for i, score := range []float64{math.Inf(-1), 1, 2, 2, 3, math.Inf(1)} {
+		l.Insert(strconv.Itoa(i), score)
+	}
+	tests := []struct {
+		min        float64
+		wantMember string
+	}{
+		{min: math.Inf(-1), wantMember: "0"},
+		{min: 1.5, wantMember: "2"},
+		{min: 2, wantMember: "2"},
+		{min: 4, wantMember: "5"},
+	}
+	for _, tt := range tests {
+		if n := l.First(tt.min); n == nil || n.Member() != tt.wantMember {
+			t.Errorf("List.First(%v) = %+v, want %v", tt.min, n, tt.wantMember)
+		}
+	}
+	if n := New().First(0); n != nil {
+		t.Errorf("empty List.First() = %+v", n)
+	}
+}
@@ -0,0 +1,207 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// scopeName - the instrumentation scope of the spans
+const scopeName = "github.com/proway2/kvserver"
+
+// exportTimeout - the collector which doesn't accept the spans in time gets the next batch only
+const exportTimeout = 10 * time.Second
+
+// OTLP/JSON messages, see opentelemetry-proto: IDs are hex, 64-bit integers are strings
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Events            []otlpEvent    `json:"events,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpEvent struct {
+		TimeUnixNano string `json:"timeUnixNano"`
+		Name         string `json:"name"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // STATUS_CODE_ERROR = 2
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// encodeOTLP returns ExportTraceServiceRequest of the spans as OTLP/JSON
+func encodeOTLP(service string, spans []SpanData) ([]byte, error) {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: scopeName}, Spans: make([]otlpSpan, 0, len(spans))}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: unixNano(s.Start),
+			EndTimeUnixNano:   unixNano(s.End),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.Parent != (SpanID{}) {
+			span.ParentSpanID = hex.EncodeToString(s.Parent[:])
+		}
+		for _, e := range s.Events {
+			span.Events = append(span.Events, otlpEvent{TimeUnixNano: unixNano(e.Time), Name: e.Name})
+		}
+		if s.Error {
+			span.Status = &otlpStatus{Code: 2, Message: s.ErrorMessage}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attribute{String("service.name", service)})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpAnyValue
+		switch value := a.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int:
+			s := strconv.Itoa(value)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return kvs
+}
+
+// FileExporter writes the spans to the file as OTLP/JSON, one ExportTraceServiceRequest per line,
+// the same as the file exporter of OpenTelemetry Collector
+type FileExporter struct {
+	mux     sync.Mutex
+	w       io.WriteCloser
+	service string
+}
+
+// NewFileExporter returns the exporter appending the spans of the service to the file, it's created if needed
+func NewFileExporter(path, service string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{w: f, service: service}, nil
+}
+
+// Export writes the spans as a single line
+func (e *FileExporter) Export(spans []SpanData) error {
+	data, err := encodeOTLP(e.service, spans)
+	if err != nil {
+		return err
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the file
+func (e *FileExporter) Close() error {
+	return e.w.Close()
+}
+
+// HTTPExporter sends the spans to OTLP/HTTP collector as JSON
+type HTTPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewHTTPExporter returns the exporter posting the spans of the service to the collector's endpoint
+// like http://localhost:4318/v1/traces
+func NewHTTPExporter(endpoint, service string) *HTTPExporter {
+	return &HTTPExporter{endpoint: endpoint, service: service, client: &http.Client{Timeout: exportTimeout}}
+}
+
+// Export posts the spans, the collector's rejection is an error
+func (e *HTTPExporter) Export(spans []SpanData) error {
+	data, err := encodeOTLP(e.service, spans)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector rejected %v spans: %v", len(spans), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	// TraceparentHeader - W3C Trace Context header carrying the caller's span
+	TraceparentHeader = "traceparent"
+	// TraceresponseHeader - W3C Trace Context Level 2 response header carrying the server's span,
+	// its value is the same as the one of traceparent
+	TraceresponseHeader = "traceresponse"
+)
+
+// TraceID - the ID of the trace, all spans of the request have the same one
+type TraceID [16]byte
+
+// SpanID - the ID of the span within the trace
+type SpanID [8]byte
+
+// SpanContext - the span's identity propagated between the services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// the caller records the trace, so the server records its spans as well
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set, all-zero IDs are not valid
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent returns the value of traceparent header like 00-<trace ID>-<span ID>-01
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+var errTraceparent = errors.New("traceparent is not valid")
+
+// ParseTraceparent returns the caller's span from traceparent header. The versions after 00 are parsed
+// the same way, their extra fields are ignored as W3C Trace Context requires.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+	// version-traceid-spanid-flags
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, errTraceparent
+	}
+	version, ok := decodeHex(value[:2], 1)
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+		return sc, errTraceparent
+	}
+	traceID, ok := decodeHex(value[3:35], 16)
+	if !ok {
+		return sc, errTraceparent
+	}
+	spanID, ok := decodeHex(value[36:52], 8)
+	if !ok {
+		return sc, errTraceparent
+	}
+	flags, ok := decodeHex(value[53:55], 1)
+	if !ok {
+		return sc, errTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, errTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lowercase hex of n bytes, uppercase is not allowed by W3C Trace Context
+func decodeHex(s string, n int) ([]byte, bool) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, false
+	}
+	data, err := hex.DecodeString(s)
+	return data, err == nil
+}
+
+type remoteKey struct{}
+
+// ContextWithRemoteSpanContext returns the context with the caller's span, the spans started
+// within the context are its children
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanContextFromContext returns the span the spans started within the context are the children of:
+// either the span started by Tracer.Start or the caller's one
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if s := spanFromContext(ctx); s != nil {
+		return s.SpanContext(), true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
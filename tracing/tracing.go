@@ -0,0 +1,316 @@
+// Package tracing - spans of the server's requests and storage operations exported as OTLP/JSON,
+// the spans are linked to the callers' ones by W3C Trace Context (traceparent header)
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanKind - the role of the span in the trace, the values are the ones of OTLP
+type SpanKind int
+
+const (
+	// Internal - the operation within the server, e.g. the storage's one
+	Internal SpanKind = 1
+	// Server - the request served by the server
+	Server SpanKind = 2
+)
+
+// Attribute - the key-value pair describing the span, the value is a string, an integer, a float or a bool
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns the attribute with the string value
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns the attribute with the integer value
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Event - the moment within the span, e.g. the storage's lock is acquired
+type Event struct {
+	Name string
+	Time time.Time
+}
+
+// SpanData - the finished span as it's exported
+type SpanData struct {
+	SpanContext
+	Parent     SpanID // zero for the root span
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Events     []Event
+	// the operation has failed, the message tells why
+	Error        bool
+	ErrorMessage string
+}
+
+// Exporter - sends the finished spans somewhere, see NewFileExporter and NewHTTPExporter
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+const (
+	// default maximum number of spans exported at once
+	defaultBatchSize = 512
+	// default period the finished spans are exported at
+	defaultBatchInterval = time.Second
+	// the spans finished while the exporter is busy are kept in memory up to this number, the rest are dropped
+	queueSize = 4096
+)
+
+// Tracer - starts the spans and exports them in batches in the background.
+// Nil tracer records nothing, so the code may be traced unconditionally.
+type Tracer struct {
+	exporter      Exporter
+	batchSize     int
+	batchInterval time.Duration
+	onError       func(err error)
+	queue         chan SpanData
+	dropped       uint64 // spans not exported because the queue was full
+	flush         chan chan struct{}
+	done          chan struct{}
+	stopOnce      sync.Once
+	stopped       chan struct{}
+}
+
+// Option configures the tracer, see NewTracer
+type Option func(*Tracer)
+
+// WithBatch sets the maximum number of spans exported at once and the period they are exported at
+func WithBatch(size int, interval time.Duration) Option {
+	return func(t *Tracer) {
+		if size > 0 && interval > 0 {
+			t.batchSize, t.batchInterval = size, interval
+		}
+	}
+}
+
+// WithErrorHandler sets the function which gets the errors of the exporter, they are ignored by default
+func WithErrorHandler(onError func(err error)) Option {
+	return func(t *Tracer) {
+		t.onError = onError
+	}
+}
+
+// NewTracer returns the tracer exporting the spans with the exporter, Shutdown stops it
+func NewTracer(exporter Exporter, opts ...Option) *Tracer {
+	t := &Tracer{
+		exporter:      exporter,
+		batchSize:     defaultBatchSize,
+		batchInterval: defaultBatchInterval,
+		onError:       func(error) {},
+		queue:         make(chan SpanData, queueSize),
+		flush:         make(chan chan struct{}),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	go t.run()
+	return t
+}
+
+// Start starts the span now, see StartAt
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	return t.StartAt(ctx, name, kind, time.Now(), attrs...)
+}
+
+// StartAt starts the span at the time, it's the child of the span in ctx if there is one.
+// The span is not recorded if the caller doesn't record the trace, i.e. its traceparent is not sampled,
+// then nil span is returned, it's safe to use. The returned context has the span.
+func (t *Tracer) StartAt(ctx context.Context, name string, kind SpanKind, start time.Time,
+	attrs ...Attribute,
+) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent, ok := SpanContextFromContext(ctx)
+	if ok && !parent.Sampled {
+		return ctx, nil
+	}
+	s := &Span{tracer: t}
+	s.data.Name, s.data.Kind, s.data.Start = name, kind, start
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+	s.data.Sampled = true
+	if ok {
+		s.data.TraceID, s.data.Parent = parent.TraceID, parent.SpanID
+	} else {
+		_, _ = rand.Read(s.data.TraceID[:])
+	}
+	_, _ = rand.Read(s.data.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Locked records the finished operation which held the lock as the span: the operation started at start,
+// acquired the lock at locked and released it at unlocked. The time spent waiting for the lock
+// and holding it are the span's attributes, acquiring the lock is its event.
+func (t *Tracer) Locked(ctx context.Context, name string, start, locked, unlocked time.Time) {
+	_, span := t.StartAt(ctx, name, Internal, start,
+		Int("lock.wait_ns", locked.Sub(start).Nanoseconds()),
+		Int("lock.held_ns", unlocked.Sub(locked).Nanoseconds()),
+	)
+	span.AddEvent("lock acquired", locked)
+	span.EndAt(unlocked)
+}
+
+// Dropped returns the number of spans which are not exported because they were finished faster
+// than the exporter sent them
+func (t *Tracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Flush exports the finished spans right away
+func (t *Tracer) Flush() {
+	done := make(chan struct{})
+	select {
+	case t.flush <- done:
+		<-done
+	case <-t.stopped:
+	}
+}
+
+// Shutdown exports the finished spans and stops the tracer, the spans finished afterwards are dropped
+func (t *Tracer) Shutdown() {
+	t.stopOnce.Do(func() { close(t.done) })
+	<-t.stopped
+}
+
+// finished queues the span for export, it's dropped if the queue is full
+func (t *Tracer) finished(data SpanData) {
+	select {
+	case <-t.done:
+		atomic.AddUint64(&t.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case t.queue <- data:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// run exports the spans batch by batch until the tracer is shut down
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(t.batchInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, t.batchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			t.onError(err)
+		}
+		batch = make([]SpanData, 0, t.batchSize)
+	}
+	add := func(data SpanData) {
+		batch = append(batch, data)
+		if len(batch) == t.batchSize {
+			export()
+		}
+	}
+	// drain takes the spans queued so far
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				add(data)
+			default:
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			add(data)
+		case <-ticker.C:
+			export()
+		case done := <-t.flush:
+			drain()
+			export()
+			close(done)
+		case <-t.done:
+			drain()
+			export()
+			return
+		}
+	}
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Span - the operation being traced, it's used by one goroutine. Nil span records nothing.
+type Span struct {
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+}
+
+// SpanContext returns the span's identity, e.g. for traceparent header
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetAttributes adds the attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// AddEvent adds the event which happened at the time
+func (s *Span) AddEvent(name string, at time.Time) {
+	if s == nil {
+		return
+	}
+	s.data.Events = append(s.data.Events, Event{Name: name, Time: at})
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.data.Error, s.data.ErrorMessage = true, msg
+}
+
+// End finishes the span now, see EndAt
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt finishes the span at the time and queues it for export, the span is finished once
+func (s *Span) EndAt(end time.Time) {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	s.tracer.finished(s.data)
+}
@@ -0,0 +1,224 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name        string
+		value       string
+		wantErr     bool
+		wantSampled bool
+	}{
+		{name: "Sampled", value: "00-" + traceID + "-" + spanID + "-01", wantSampled: true},
+		{name: "Not sampled", value: "00-" + traceID + "-" + spanID + "-00"},
+		{name: "Future version with extra fields", value: "01-" + traceID + "-" + spanID + "-09-extra", wantSampled: true},
+		{name: "Extra fields of version 00", value: "00-" + traceID + "-" + spanID + "-01-extra", wantErr: true},
+		{name: "Forbidden version", value: "ff-" + traceID + "-" + spanID + "-01", wantErr: true},
+		{name: "Uppercase", value: "00-" + strings.ToUpper(traceID) + "-" + spanID + "-01", wantErr: true},
+		{name: "Zero trace ID", value: "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", wantErr: true},
+		{name: "Zero span ID", value: "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", wantErr: true},
+		{name: "Too short", value: "00-" + traceID + "-" + spanID, wantErr: true},
+		{name: "Empty", value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sc.Sampled != tt.wantSampled {
+				t.Errorf("ParseTraceparent() sampled = %v, want %v", sc.Sampled, tt.wantSampled)
+			}
+			if tt.value[:2] == "00" && sc.Traceparent() != tt.value {
+				t.Errorf("SpanContext.Traceparent() = %v, want %v", sc.Traceparent(), tt.value)
+			}
+		})
+	}
+}
+
+// memoryExporter keeps the exported spans
+type memoryExporter struct {
+	mux   sync.Mutex
+	spans []SpanData
+}
+
+func (e *memoryExporter) Export(spans []SpanData) error {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter, WithBatch(2, time.Hour))
+	defer tracer.Shutdown()
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, server := tracer.Start(ContextWithRemoteSpanContext(context.Background(), remote), "GET /key/", Server)
+	_, op := tracer.Start(ctx, "kvstorage.Lookup", Internal, String("key", "a"))
+	op.AddEvent("lock acquired", time.Now())
+	op.End()
+	op.End()
+	server.SetError("500")
+	server.End()
+	// the caller doesn't record the trace
+	notSampled := remote
+	notSampled.Sampled = false
+	_, span := tracer.Start(ContextWithRemoteSpanContext(context.Background(), notSampled), "GET /key/", Server)
+	if span != nil {
+		t.Error("Tracer.Start() span is recorded for the trace which is not sampled")
+	}
+	span.End()
+	// nil tracer records nothing
+	var none *Tracer
+	if _, span := none.Start(ctx, "nothing", Internal); span != nil {
+		t.Error("nil Tracer.Start() span is recorded")
+	}
+	tracer.Flush()
+
+	exporter.mux.Lock()
+	defer exporter.mux.Unlock()
+	if len(exporter.spans) != 2 {
+		t.Fatalf("exported %v spans, want 2", len(exporter.spans))
+	}
+	got, parent := exporter.spans[0], exporter.spans[1]
+	if got.TraceID != remote.TraceID || parent.TraceID != remote.TraceID {
+		t.Errorf("spans are not in the caller's trace")
+	}
+	if got.Parent != parent.SpanID || parent.Parent != remote.SpanID {
+		t.Errorf("spans' parents = %x, %x, want %x, %x", got.Parent, parent.Parent, parent.SpanID, remote.SpanID)
+	}
+	if len(got.Events) != 1 || len(got.Attributes) != 1 || !parent.Error {
+		t.Errorf("spans = %+v, %+v", got, parent)
+	}
+}
+
+func TestTracer_Locked(t *testing.T) {
+	exporter := &memoryExporter{}
+	tracer := NewTracer(exporter)
+	start := time.Unix(1000, 0)
+	tracer.Locked(context.Background(), "kvstorage.Set", start, start.Add(10), start.Add(25))
+	tracer.Shutdown()
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("exported %v spans, want 1", len(exporter.spans))
+	}
+	got := exporter.spans[0]
+	wantAttrs := []Attribute{Int("lock.wait_ns", 10), Int("lock.held_ns", 15)}
+	if got.Name != "kvstorage.Set" || got.Kind != Internal || !got.End.Equal(start.Add(25)) ||
+		!reflect.DeepEqual(got.Attributes, wantAttrs) || len(got.Events) != 1 || !got.Events[0].Time.Equal(start.Add(10)) {
+		t.Errorf("span = %+v", got)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := NewFileExporter(path, "kvserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	start := time.Unix(1000, 5)
+	span := SpanData{
+		SpanContext: SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}, Sampled: true},
+		Parent:      SpanID{3},
+		Name:        "kvstorage.Set",
+		Kind:        Internal,
+		Start:       start,
+		End:         start.Add(time.Millisecond),
+		Attributes:  []Attribute{Int("kvstorage.lock.wait_ns", 10), String("kvstorage.key", "a")},
+		Events:      []Event{{Name: "lock acquired", Time: start.Add(10)}},
+	}
+	for i := 0; i < 2; i++ {
+		if err := exporter.Export([]SpanData{span}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"kvserver"}}]},` +
+		`"scopeSpans":[{"scope":{"name":"github.com/proway2/kvserver"},"spans":[{` +
+		`"traceId":"01000000000000000000000000000000","spanId":"0200000000000000","parentSpanId":"0300000000000000",` +
+		`"name":"kvstorage.Set","kind":1,"startTimeUnixNano":"1000000000005","endTimeUnixNano":"1000001000005",` +
+		`"attributes":[{"key":"kvstorage.lock.wait_ns","value":{"intValue":"10"}},{"key":"kvstorage.key","value":{"stringValue":"a"}}],` +
+		`"events":[{"timeUnixNano":"1000000000015","name":"lock acquired"}]}]}]}]}`
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		if scanner.Text() != want {
+			t.Errorf("FileExporter wrote %v, want %v", scanner.Text(), want)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("FileExporter wrote %v lines, want 2", lines)
+	}
+}
+
+func TestHTTPExporter(t *testing.T) {
+	var got []byte
+	status := http.StatusOK
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer collector.Close()
+
+	exporter := NewHTTPExporter(collector.URL+"/v1/traces", "kvserver")
+	span := SpanData{SpanContext: SpanContext{TraceID: TraceID{1}, SpanID: SpanID{2}}, Name: "GET /key/", Kind: Server}
+	if err := exporter.Export([]SpanData{span}); err != nil {
+		t.Fatal(err)
+	}
+	var req otlpRequest
+	if err := json.Unmarshal(got, &req); err != nil || req.ResourceSpans[0].ScopeSpans[0].Spans[0].Name != "GET /key/" {
+		t.Errorf("collector got %s, %v", got, err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := exporter.Export([]SpanData{span}); err == nil {
+		t.Error("HTTPExporter.Export() error = nil for the rejected spans")
+	}
+}
+
+// failingExporter never exports the spans
+type failingExporter struct{}
+
+func (failingExporter) Export([]SpanData) error { return errors.New("collector is down") }
+
+func TestWithErrorHandler(t *testing.T) {
+	var errs []error
+	tracer := NewTracer(failingExporter{}, WithErrorHandler(func(err error) { errs = append(errs, err) }))
+	_, span := tracer.Start(context.Background(), "GET /key/", Server)
+	span.End()
+	tracer.Shutdown()
+	if len(errs) != 1 {
+		t.Errorf("errors = %v, want 1", errs)
+	}
+}
@@ -3,58 +3,204 @@ package vacuum
 import (
 	"errors"
 	"log"
+	"runtime"
+	"sync"
 	"time"
+
+	"github.com/proway2/kvserver/clock"
 )
 
 type writer interface {
 	OldestElementTime() (time.Time, error)
-	DeleteFrontIfOlder(time.Time) (bool, error)
+	DeleteFrontOlder(ctxTime time.Time, limit int, budget time.Duration) (int, bool, error)
+	EarliestExpiration() (time.Time, error)
+	DeleteExpired(ctxTime time.Time, limit int, budget time.Duration) (int, bool, error)
+}
+
+// debugLogger - the log of the cleaner's work: sleep periods and purged elements
+type debugLogger interface {
+	Debug(msg string, keyvals ...interface{})
 }
 
+// nopLogger writes nothing, it's used unless WithLogger is given
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+
+const (
+	// default maximum number of elements purged at once, the storage is unlocked between batches
+	defaultBatchLimit = 1024
+	// default maximum time the storage is locked for purging a batch
+	defaultBatchBudget = time.Millisecond
+)
+
 // Vacuum - struct for cleaner
 type Vacuum struct {
 	storage     writer
+	mux         sync.Mutex // guards ttl, it may be changed while the cleaner is running
 	ttl         uint64
 	ttlDelim    uint
+	clock       clock.Clock
+	batchLimit  int
+	batchBudget time.Duration
+	done        chan struct{} // closed when the cleaner is stopped
+	reload      chan struct{} // wakes the sleeping cleaner up when TTL is changed
+	log         debugLogger
+	stopOnce    sync.Once
 	initialized bool
 }
 
+// Option configures the cleaner, see NewCleaner
+type Option func(*Vacuum)
+
+// WithClock sets the source of the current time and timers, the real clock is used by default.
+// It must be the same clock the storage uses.
+func WithClock(c clock.Clock) Option {
+	return func(q *Vacuum) {
+		if c != nil {
+			q.clock = c
+		}
+	}
+}
+
+// WithBatch bounds the work done while the storage is locked: at most limit elements
+// are purged within budget time (zero budget means no time limit).
+// The cleaner unlocks the storage between batches until all expired elements are purged.
+func WithBatch(limit int, budget time.Duration) Option {
+	return func(q *Vacuum) {
+		if limit > 0 && budget >= 0 {
+			q.batchLimit, q.batchBudget = limit, budget
+		}
+	}
+}
+
+// WithLogger sets the log of the cleaner's work, nothing is logged by default.
+// The keys of the purged elements are logged by the storage, see kvstorage.WithPurgeNotifier.
+func WithLogger(l debugLogger) Option {
+	return func(q *Vacuum) {
+		if l != nil {
+			q.log = l
+		}
+	}
+}
+
 // NewCleaner returns an initialized cleaner for storage 'w' with TTL of 'ttl'
-func NewCleaner(w writer, ttl uint64) (*Vacuum, error) {
+func NewCleaner(w writer, ttl uint64, opts ...Option) (*Vacuum, error) {
 	if w == nil || ttl == 0 {
 		return &Vacuum{}, errors.New("newCleaner: no storage provided or TTL = 0")
 	}
-	return &Vacuum{
+	q := &Vacuum{
 		storage:     w,
 		ttl:         ttl,
 		ttlDelim:    2,
+		clock:       clock.Real{},
+		batchLimit:  defaultBatchLimit,
+		batchBudget: defaultBatchBudget,
+		done:        make(chan struct{}),
+		reload:      make(chan struct{}, 1),
+		log:         nopLogger{},
 		initialized: true,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q, nil
 }
 
-// Run - infinite storage cleaner
+// Run - storage cleaner, runs until Stop is called
 func (q *Vacuum) Run() {
 	if !q.initialized {
 		log.Fatalln("Cleaner is not properly initialized.")
 	}
-	// we need to hit the oldest element periodically
-	emptyQueueSleepPeriod := getSleepPeriodEmptyQueue(q.ttl, q.ttlDelim)
 	for {
+		ttl := q.TTL()
+		now := q.clock.Now()
 		elementTime, err := q.storage.OldestElementTime()
 		var sleepPeriod time.Duration
 		if err != nil {
-			sleepPeriod = emptyQueueSleepPeriod
+			// we need to hit the oldest element periodically
+			sleepPeriod = getSleepPeriodEmptyQueue(ttl, q.ttlDelim)
 		} else {
-			sleepPeriod = getSleepPeriod(elementTime, nil, q.ttl, q.ttlDelim)
+			sleepPeriod = getSleepPeriod(now, elementTime, nil, ttl, q.ttlDelim)
+		}
+		// elements with absolute expiration time are approximated the same way
+		if expires, err := q.storage.EarliestExpiration(); err == nil {
+			if period := getSleepPeriodDeadline(now, expires, q.ttlDelim); period < sleepPeriod {
+				sleepPeriod = period
+			}
 		}
 
-		time.Sleep(sleepPeriod)
-		testTime := time.Now().Add(
-			time.Duration(-q.ttl * uint64(time.Second)),
+		q.log.Debug("cleaner is sleeping", "period", sleepPeriod, "ttl", ttl)
+		select {
+		case <-q.clock.After(sleepPeriod):
+		case <-q.reload:
+			// the sleep period is counted by the new TTL
+			q.log.Debug("cleaner's TTL is changed", "ttl", q.TTL())
+			continue
+		case <-q.done:
+			return
+		}
+		now = q.clock.Now()
+		testTime := now.Add(
+			time.Duration(-q.TTL() * uint64(time.Second)),
 		)
-		if _, err := q.storage.DeleteFrontIfOlder(testTime); err != nil {
+		outlived, err := q.purge(testTime, q.storage.DeleteFrontOlder)
+		if err != nil {
 			return
 		}
+		expired, err := q.purge(now, q.storage.DeleteExpired)
+		if err != nil {
+			return
+		}
+		if outlived+expired != 0 {
+			q.log.Debug("expired elements are purged", "outlived_ttl", outlived, "expired", expired)
+		}
+	}
+}
+
+// TTL returns element's lifetime the cleaner purges the storage by, secs
+func (q *Vacuum) TTL() uint64 {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.ttl
+}
+
+// SetTTL changes element's lifetime at runtime, secs. The sleeping cleaner wakes up
+// and goes to sleep again for the period counted by the new TTL, so shorter TTL is applied right away.
+func (q *Vacuum) SetTTL(ttl uint64) error {
+	if !q.initialized || ttl == 0 {
+		return errors.New("setTTL: cleaner is not initialized or TTL = 0")
+	}
+	q.mux.Lock()
+	q.ttl = ttl
+	q.mux.Unlock()
+	select {
+	case q.reload <- struct{}{}:
+	default:
+		// the cleaner is going to be woken up already
+	}
+	return nil
+}
+
+// Stop makes Run return, the storage is not purged anymore. It's safe to call Stop more than once.
+func (q *Vacuum) Stop() {
+	if q.initialized {
+		q.stopOnce.Do(func() { close(q.done) })
+	}
+}
+
+// purge calls the storage's delete function batch by batch until all expired elements are purged.
+// It returns the number of purged elements.
+func (q *Vacuum) purge(ctxTime time.Time, deleteBatch func(time.Time, int, time.Duration) (int, bool, error)) (int, error) {
+	purged := 0
+	for {
+		n, more, err := deleteBatch(ctxTime, q.batchLimit, q.batchBudget)
+		purged += n
+		if err != nil || !more {
+			return purged, err
+		}
+		// let the others have the storage between batches
+		runtime.Gosched()
 	}
 }
 
@@ -64,7 +210,19 @@ func getSleepPeriodEmptyQueue(ttl uint64, ttlDelim uint) time.Duration {
 	)
 }
 
-func getSleepPeriod(elementTime time.Time, err error, ttl uint64, ttlDelim uint) time.Duration {
+// getSleepPeriodDeadline returns the period to sleep before checking the element which expires at the deadline
+func getSleepPeriodDeadline(now, deadline time.Time, ttlDelim uint) time.Duration {
+	if ttlDelim < 2 {
+		return time.Duration(1 * time.Second)
+	}
+	sleepDuration := deadline.Sub(now) / time.Duration(ttlDelim)
+	if sleepDuration < 1 {
+		return time.Duration(0 * time.Nanosecond)
+	}
+	return sleepDuration
+}
+
+func getSleepPeriod(now, elementTime time.Time, err error, ttl uint64, ttlDelim uint) time.Duration {
 	// need to handle special case scenario when
 	// either no ttl or ttlDelim provided or these are wrong
 	if ttl < 1 || ttlDelim < 2 {
@@ -76,7 +234,7 @@ func getSleepPeriod(elementTime time.Time, err error, ttl uint64, ttlDelim uint)
 		),
 	)
 
-	timeDiffNS := float64(time.Until(oldestElementFinalTime).Nanoseconds()) / float64(ttlDelim)
+	timeDiffNS := float64(oldestElementFinalTime.Sub(now).Nanoseconds()) / float64(ttlDelim)
 
 	// to handle already expired elements must check for negative numbers
 	if timeDiffNS < 0.0 {
@@ -1,10 +1,16 @@
 package vacuum
 
 import (
+	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/proway2/kvserver/clock"
+	"github.com/proway2/kvserver/clock/clocktest"
 	"github.com/proway2/kvserver/kvstorage"
 )
 
@@ -93,7 +99,7 @@ func Test_getSleepPeriod(t *testing.T) {
 	var toleranceNS int64 = 1000000
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := getSleepPeriod(tt.args.elementTime, tt.args.err, tt.args.ttl, tt.args.ttlDelim)
+			got := getSleepPeriod(time.Now(), tt.args.elementTime, tt.args.err, tt.args.ttl, tt.args.ttlDelim)
 			if got.Nanoseconds() < tt.want.Nanoseconds()-toleranceNS || got.Nanoseconds() > tt.want.Nanoseconds()+toleranceNS {
 				t.Errorf("getSleepPeriod() = %v, want %v", got, tt.want)
 			}
@@ -140,6 +146,10 @@ func TestNewCleaner(t *testing.T) {
 				storage:     kvstorage.NewStorage(),
 				ttl:         20,
 				ttlDelim:    2,
+				clock:       clock.Real{},
+				batchLimit:  defaultBatchLimit,
+				batchBudget: defaultBatchBudget,
+				log:         nopLogger{},
 				initialized: true,
 			},
 			wantErr: false,
@@ -152,6 +162,11 @@ func TestNewCleaner(t *testing.T) {
 				t.Errorf("NewCleaner() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			// every cleaner has its own stop and reload channels, these are checked separately
+			if (got.done != nil) != tt.want.initialized || (got.reload != nil) != tt.want.initialized {
+				t.Errorf("NewCleaner() done = %v, reload = %v", got.done, got.reload)
+			}
+			got.done, got.reload = nil, nil
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewCleaner() = %v, want %v", got, tt.want)
 			}
@@ -186,3 +201,231 @@ func Test_getSleepPeriodEmptyQueue(t *testing.T) {
 		})
 	}
 }
+
+func Test_getSleepPeriodDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		deadline time.Time
+		ttlDelim uint
+		want     time.Duration
+	}{
+		{
+			name:     "No TTL delimiter provided",
+			deadline: time.Now().Add(time.Minute),
+			want:     time.Duration(1 * time.Second),
+		},
+		{
+			name:     "Expired element",
+			deadline: time.Now().Add(-time.Minute),
+			ttlDelim: 2,
+			want:     time.Duration(0 * time.Nanosecond),
+		},
+		{
+			name:     "30 secs to the deadline",
+			deadline: time.Now().Add(30 * time.Second),
+			ttlDelim: 2,
+			want:     time.Duration(15 * time.Second),
+		},
+	}
+	var toleranceNS int64 = 1000000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getSleepPeriodDeadline(time.Now(), tt.deadline, tt.ttlDelim)
+			if got.Nanoseconds() < tt.want.Nanoseconds()-toleranceNS || got.Nanoseconds() > tt.want.Nanoseconds()+toleranceNS {
+				t.Errorf("getSleepPeriodDeadline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVacuum_Run_fakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clocktest.NewFake(start)
+	storage := kvstorage.NewStorage(kvstorage.WithClock(clk))
+	for _, key := range []string{"ttl", "expireat", "persist"} {
+		if err := storage.Set(key, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := storage.ExpireAt("expireat", start.Add(30*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Persist("persist"); err != nil {
+		t.Fatal(err)
+	}
+
+	cleaner, err := NewCleaner(storage, 60, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go cleaner.Run()
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		wantKeys []string
+	}{
+		{
+			name:     "Nothing is expired",
+			now:      start.Add(29 * time.Second),
+			wantKeys: []string{"expireat", "persist", "ttl"}, // same timestamp, sorted by the key
+		},
+		{
+			name:     "Absolute expiration time",
+			now:      start.Add(31 * time.Second),
+			wantKeys: []string{"persist", "ttl"},
+		},
+		{
+			name:     "TTL",
+			now:      start.Add(61 * time.Second),
+			wantKeys: []string{"persist"},
+		},
+		{
+			name:     "Persistent element is never purged",
+			now:      start.Add(24 * time.Hour),
+			wantKeys: []string{"persist"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the cleaner is sleeping, it wakes up at the new time and goes to sleep again
+			clk.BlockUntil(1)
+			clk.Set(tt.now)
+			clk.BlockUntil(1)
+			got, err := storage.Keys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.wantKeys) {
+				t.Errorf("Vacuum.Run() keys = %v, want %v", got, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestVacuum_Run_burst(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clocktest.NewFake(start)
+	storage := kvstorage.NewStorage(kvstorage.WithClock(clk))
+	for i := 0; i < 5000; i++ {
+		if err := storage.Set(strconv.Itoa(i), "value"); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if _, err := storage.ExpireAt(strconv.Itoa(i), start.Add(time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	cleaner, err := NewCleaner(storage, 60, WithClock(clk), WithBatch(100, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go cleaner.Run()
+
+	// all expired elements are purged in a single wake-up, batch by batch
+	clk.BlockUntil(1)
+	clk.Set(start.Add(61 * time.Second))
+	clk.BlockUntil(1)
+	if keys, _ := storage.Keys(); len(keys) != 0 {
+		t.Errorf("Vacuum.Run() %v keys are left, want 0", len(keys))
+	}
+}
+
+func TestVacuum_SetTTL(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clocktest.NewFake(start)
+	storage := kvstorage.NewStorage(kvstorage.WithClock(clk))
+	if err := storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	cleaner, err := NewCleaner(storage, 3600, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cleaner.SetTTL(0); err == nil {
+		t.Error("Vacuum.SetTTL(0) error = nil, want error")
+	}
+	go cleaner.Run()
+	defer cleaner.Stop()
+
+	// the cleaner is sleeping for half an hour, it wakes up and goes to sleep for the new period
+	clk.BlockUntil(1)
+	if err := cleaner.SetTTL(60); err != nil {
+		t.Fatal(err)
+	}
+	if got := cleaner.TTL(); got != 60 {
+		t.Errorf("Vacuum.TTL() = %v, want 60", got)
+	}
+	clk.BlockUntil(2)
+	clk.Set(start.Add(61 * time.Second))
+	clk.BlockUntil(2)
+	if keys, _ := storage.Keys(); len(keys) != 0 {
+		t.Errorf("Vacuum.Run() keys = %v, want none", keys)
+	}
+}
+
+// recordingLogger keeps the messages of the records
+type recordingLogger struct {
+	mux      sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.messages = append(l.messages, strings.TrimSuffix(fmt.Sprintln(append([]interface{}{msg}, keyvals...)...), "\n"))
+}
+
+func TestVacuum_WithLogger(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := clocktest.NewFake(start)
+	storage := kvstorage.NewStorage(kvstorage.WithClock(clk))
+	if err := storage.Set("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	log := &recordingLogger{}
+	cleaner, err := NewCleaner(storage, 60, WithClock(clk), WithLogger(log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go cleaner.Run()
+	defer cleaner.Stop()
+
+	clk.BlockUntil(1)
+	clk.Set(start.Add(61 * time.Second))
+	clk.BlockUntil(1)
+	log.mux.Lock()
+	defer log.mux.Unlock()
+	want := []string{
+		"cleaner is sleeping period 30s ttl 60",
+		"expired elements are purged outlived_ttl 1 expired 0",
+		"cleaner is sleeping period 30s ttl 60",
+	}
+	if !reflect.DeepEqual(log.messages, want) {
+		t.Errorf("Vacuum.Run() logged %q, want %q", log.messages, want)
+	}
+}
+
+func TestVacuum_Stop(t *testing.T) {
+	clk := clocktest.NewFake(time.Unix(1000, 0))
+	cleaner, err := NewCleaner(kvstorage.NewStorage(kvstorage.WithClock(clk)), 60, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		cleaner.Run()
+		close(stopped)
+	}()
+	// the cleaner is sleeping
+	clk.BlockUntil(1)
+	cleaner.Stop()
+	cleaner.Stop()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Vacuum.Run() is still running after Vacuum.Stop()")
+	}
+}